@@ -1,18 +1,37 @@
 package main
 
 import (
-	"github.com/go-playground/validator/v10"
+	"context"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
 	"github.com/hermantrym/go-firebase-api/internal/auth"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hermantrym/go-firebase-api/internal/config"
 	"github.com/hermantrym/go-firebase-api/internal/handler"
+	"github.com/hermantrym/go-firebase-api/internal/mail"
+	"github.com/hermantrym/go-firebase-api/internal/oidc"
+	"github.com/hermantrym/go-firebase-api/internal/ratelimit"
 	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/requestid"
+	"github.com/hermantrym/go-firebase-api/internal/role"
 	"github.com/hermantrym/go-firebase-api/internal/service"
 	"github.com/joho/godotenv"
 )
 
+// Default rate limits for each route group, used when the corresponding environment variables are not set.
+var (
+	defaultPublicRateLimit = ratelimit.Limit{Requests: 60, Period: time.Minute}
+	defaultUserRateLimit   = ratelimit.Limit{Requests: 300, Period: time.Minute}
+	defaultAdminRateLimit  = ratelimit.Limit{Requests: 120, Period: time.Minute}
+	// defaultIPRateLimit applies to protected and admin routes before authentication, so it
+	// counts every request from an IP address, and must leave room for several users behind it.
+	defaultIPRateLimit = ratelimit.Limit{Requests: 600, Period: time.Minute}
+)
+
 // main is the entry point for the application.
 // It initializes the configuration, database connection, dependency injection,
 // router, and starts the HTTP server.
@@ -26,50 +45,170 @@ func main() {
 
 	// Initialize Services & Dependencies
 	// Initialize the Firestore client connection.
-	firestoreClient := config.InitializeFirebase()
+	firestoreClient, err := config.InitializeFirebase()
+	if err != nil {
+		log.Fatalf("Failed to initialize Firestore: %v", err)
+	}
 	// Ensure the client is closed gracefully when the application exits.
 	defer func() {
 		if err := firestoreClient.Close(); err != nil {
 			log.Printf("ERROR: Failed to close Firestore client: %v", err)
 		}
 	}()
-	// Create a new instance of the validator.
-	validate := validator.New()
+	// Create a new instance of the validator, with the project's custom validation tags.
+	validate := handler.NewValidator()
+	// Load the role definitions. Without ROLES_CONFIG_FILE the built-in "user" and "admin" roles are used.
+	if err := role.LoadConfig(); err != nil {
+		log.Fatalf("Failed to load role configuration: %v", err)
+	}
+	// Load the keys and validation settings for JWTs. The server refuses to start without a key.
+	tokenConfig, err := auth.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load JWT configuration: %v", err)
+	}
+
+	// Select how emails are delivered. MAILER must be set explicitly.
+	mailer, err := mail.LoadMailer()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
 
 	// Dependency Injection
 	// Wire together the application layers.
 	userRepo := repository.NewUserRepository(firestoreClient)
-	userService := service.NewUserService(userRepo)
+	tokenRepo := repository.NewRefreshTokenRepository(firestoreClient)
+	revocationRepo := repository.NewRevocationRepository(firestoreClient)
+	magicLinkRepo := repository.NewMagicLinkRepository(firestoreClient)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(firestoreClient)
+	mfaRepo := repository.NewMFARepository(firestoreClient)
+	apiKeyRepo := repository.NewAPIKeyRepository(firestoreClient)
+	authService := service.NewAuthService(userRepo, tokenRepo, revocationRepo, magicLinkRepo, loginAttemptRepo, mfaRepo, tokenConfig, mailer)
+	userService := service.NewUserService(userRepo, authService)
+	mfaService := service.NewMFAService(userRepo, mfaRepo, loginAttemptRepo, tokenConfig)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo)
+	// Purge deleted users once their retention period has passed, unless USER_PURGE_INTERVAL is "off".
+	purgeConfig, err := service.LoadUserPurgeConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure the purge of deleted users: %v", err)
+	}
+	if purgeConfig.Interval > 0 {
+		go service.RunUserPurge(context.Background(), userService, purgeConfig)
+	} else {
+		log.Println("Warning: scheduled purge of deleted users disabled")
+	}
+	// Configure the OpenID Connect providers users can log in with, if any.
+	oidcProviders, err := oidc.LoadProviders()
+	if err != nil {
+		log.Fatalf("Failed to configure OIDC providers: %v", err)
+	}
+	oidcService := service.NewOIDCService(oidcProviders, authService, tokenConfig)
+	// Optionally accept Firebase ID tokens in the AuthMiddleware, mapping Firebase UIDs to users.
+	firebaseVerifier, err := auth.LoadFirebaseVerifier(tokenConfig.Leeway)
+	if err != nil {
+		log.Fatalf("Failed to configure Firebase Authentication: %v", err)
+	}
+	if firebaseVerifier != nil {
+		tokenConfig.Firebase = &auth.FirebaseAuth{
+			Verifier: firebaseVerifier,
+			Users:    service.NewFirebaseUserResolver(userRepo),
+		}
+	}
+	// Accept API keys for machine-to-machine clients in the X-API-Key header.
+	tokenConfig.APIKeys = apiKeyRepo
 	userHandler := handler.NewUserHandler(userService, validate)
-	authHandler := handler.NewAuthHandler(userService)
+	authHandler := handler.NewAuthHandler(authService, tokenConfig.Keys)
+	mfaHandler := handler.NewMFAHandler(mfaService)
+	oidcHandler := handler.NewOIDCHandler(oidcService)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService, validate)
 
 	// Setup Router (Gin)
 	r := gin.Default()
+	// Only trust X-Forwarded-For from the configured proxies, so that clients cannot spoof
+	// the IP address that failed logins and rate limits are counted against.
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	// Give every request an ID, which is echoed in the X-Request-ID header and in error responses.
+	r.Use(requestid.Middleware())
+	// Unknown routes get the same problem details response as every other error.
+	r.NoRoute(func(c *gin.Context) {
+		apierror.Respond(c, apierror.NewNotFoundError(""))
+	})
+
+	// Rate limit buckets are kept in memory, so each instance of the API enforces its own limits.
+	rateLimitStore := ratelimit.NewMemoryStore()
+	// Protected and admin routes are also limited per client IP before authentication, so that
+	// requests with invalid tokens or API keys are throttled too. Both groups share the bucket.
+	ipRateLimit := rateLimit(rateLimitStore, "ip", "RATE_LIMIT_IP", defaultIPRateLimit, ratelimit.ByIP)
 
 	// --- PUBLIC ROUTES ---
-	// Routes that can be accessed without authentication/token.
-	r.POST("/login", authHandler.Login)
-	r.POST("/users", userHandler.CreateUser) // Endpoint for user registration.
+	// Routes that can be accessed without authentication/token. Rate limited per client IP.
+	public := r.Group("/")
+	public.Use(rateLimit(rateLimitStore, "public", "RATE_LIMIT_PUBLIC", defaultPublicRateLimit, ratelimit.ByIP)...)
+	{
+		public.POST("/login", authHandler.Login)
+		public.POST("/auth/refresh", authHandler.Refresh)
+		public.GET("/.well-known/jwks.json", authHandler.JWKS)
+		public.POST("/auth/verify-email", authHandler.VerifyEmail)
+		public.POST("/auth/verify-email/resend", authHandler.ResendVerificationEmail)
+		public.POST("/auth/magic-link", authHandler.RequestMagicLink)
+		public.POST("/auth/magic-link/consume", authHandler.ConsumeMagicLink)
+		public.POST("/auth/mfa/verify", authHandler.VerifyMFA)
+		public.GET("/auth/:provider/start", oidcHandler.Start)
+		public.GET("/auth/:provider/callback", oidcHandler.Callback)
+		public.POST("/users", userHandler.CreateUser) // Endpoint for user registration.
+	}
 
 	// --- PROTECTED ROUTES ---
-	// This group of routes requires a valid JWT.
+	// This group of routes requires a valid JWT or API key. Rate limited per client IP, then
+	// per user or key.
 	authorized := r.Group("/")
-	authorized.Use(auth.AuthMiddleware())
+	authorized.Use(ipRateLimit...)
+	authorized.Use(auth.AuthMiddleware(tokenConfig, revocationRepo))
+	authorized.Use(rateLimit(rateLimitStore, "user", "RATE_LIMIT_USER", defaultUserRateLimit, ratelimit.ByUser)...)
 	{
-		// The endpoint to get user details is now protected.
-		authorized.GET("/users/:id", userHandler.GetUser)
+		authorized.POST("/logout", authHandler.Logout)
+
+		// MFA management is exempt from the MFA policy, so that admins can enroll.
+		authorized.POST("/auth/mfa/totp", mfaHandler.EnrollTOTP)
+		authorized.POST("/auth/mfa/totp/confirm", mfaHandler.ConfirmTOTP)
+		authorized.DELETE("/auth/mfa", mfaHandler.DisableMFA)
+
+		// Users can only read and modify their own profile, while roles with the matching
+		// users:* permission can access any profile, like on the admin routes.
+		// With REQUIRE_ADMIN_MFA, admins must have logged in with MFA.
+		mfaPolicy := auth.MFAPolicyMiddleware(tokenConfig)
+		authorized.GET("/users/:id", mfaPolicy, auth.SelfOrPermissionMiddleware("id", role.UsersRead), userHandler.GetUser)
+		authorized.PUT("/users/:id", mfaPolicy, auth.SelfOrPermissionMiddleware("id", role.UsersWrite), userHandler.UpdateUser)
+		authorized.PATCH("/users/:id", mfaPolicy, auth.SelfOrPermissionMiddleware("id", role.UsersWrite), userHandler.PatchUser)
+		authorized.DELETE("/users/:id", mfaPolicy, auth.SelfOrPermissionMiddleware("id", role.UsersDelete), userHandler.DeleteUser)
 	}
 
 	// --- PROTECTED ADMIN ROUTES ---
-	// This group of routes is protected by two layers of middleware:
-	// AuthMiddleware() - Ensures the user has a valid JWT.
-	// RoleAuthMiddleware("admin") - Ensures the user has the 'admin' role.
+	// This group of routes is protected by three layers of middleware:
+	// AuthMiddleware() - Ensures the user has a valid, unrevoked JWT or API key.
+	// RequirePermission() - Ensures the user's role grants the permission each route needs.
+	// MFAPolicyMiddleware() - With REQUIRE_ADMIN_MFA, ensures admins logged in with MFA.
+	// Admin routes are rate limited per client IP like the other protected routes, then per
+	// user, separately from the other protected routes.
 	adminRoutes := r.Group("/admin")
-	adminRoutes.Use(auth.AuthMiddleware())
-	adminRoutes.Use(auth.RoleAuthMiddleware("admin"))
+	adminRoutes.Use(ipRateLimit...)
+	adminRoutes.Use(auth.AuthMiddleware(tokenConfig, revocationRepo))
+	adminRoutes.Use(rateLimit(rateLimitStore, "admin", "RATE_LIMIT_ADMIN", defaultAdminRateLimit, ratelimit.ByUser)...)
+	adminRoutes.Use(auth.MFAPolicyMiddleware(tokenConfig))
 	{
-		adminRoutes.GET("/users", userHandler.GetAllUsers)
-		adminRoutes.POST("/users", userHandler.AdminCreateUser)
+		adminRoutes.GET("/users", auth.RequirePermission(role.UsersRead), userHandler.GetAllUsers)
+		adminRoutes.POST("/users", auth.RequirePermission(role.UsersWrite), userHandler.AdminCreateUser)
+		adminRoutes.PUT("/users/:id", auth.RequirePermission(role.UsersWrite), userHandler.AdminUpdateUser)
+		adminRoutes.PATCH("/users/:id", auth.RequirePermission(role.UsersWrite), userHandler.AdminPatchUser)
+		adminRoutes.DELETE("/users/:id", auth.RequirePermission(role.UsersDelete), userHandler.AdminDeleteUser)
+		adminRoutes.PUT("/users/:id/role", auth.RequirePermission(role.RolesAssign), userHandler.ChangeUserRole)
+		adminRoutes.POST("/users/:id/suspend", auth.RequirePermission(role.UsersSuspend), userHandler.SuspendUser)
+		adminRoutes.POST("/users/:id/restore", auth.RequirePermission(role.UsersSuspend), userHandler.RestoreUser)
+		adminRoutes.DELETE("/users/:id/sessions", auth.RequirePermission(role.SessionsRevoke), authHandler.RevokeUserSessions)
+		adminRoutes.POST("/api-keys", auth.RequirePermission(role.APIKeysManage), apiKeyHandler.CreateAPIKey)
+		adminRoutes.GET("/api-keys", auth.RequirePermission(role.APIKeysManage), apiKeyHandler.ListAPIKeys)
+		adminRoutes.DELETE("/api-keys/:id", auth.RequirePermission(role.APIKeysManage), apiKeyHandler.RevokeAPIKey)
 	}
 
 	// Run Server
@@ -78,3 +217,29 @@ func main() {
 		log.Fatalf("Failed to run server: %v", err)
 	}
 }
+
+// trustedProxies returns the comma-separated addresses or CIDR ranges in TRUSTED_PROXIES,
+// or nil to trust no proxy and use the connection's remote address as the client IP.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// rateLimit returns the rate limiting middleware for a route group, using the limit in
+// the given environment variable or the fallback. It returns no middleware if the
+// variable is set to "off".
+func rateLimit(store ratelimit.Store, name, envKey string, fallback ratelimit.Limit, identify ratelimit.KeyFunc) []gin.HandlerFunc {
+	limit, enabled := ratelimit.LimitFromEnv(envKey, fallback)
+	if !enabled {
+		log.Printf("Warning: rate limiting disabled for %s routes", name)
+		return nil
+	}
+
+	return []gin.HandlerFunc{ratelimit.Middleware(store, name, limit, identify)}
+}
@@ -0,0 +1,40 @@
+// Command backfill-emails migrates users created before email addresses were normalized
+// and indexed: it lowercases their stored addresses and creates the missing entries in the
+// "emails" index collection that registration and login rely on. It is safe to run more
+// than once. Run it once after upgrading, before serving traffic.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/hermantrym/go-firebase-api/internal/config"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found")
+	}
+
+	firestoreClient, err := config.InitializeFirebase()
+	if err != nil {
+		log.Fatalf("Failed to initialize Firestore: %v", err)
+	}
+	defer func() {
+		if err := firestoreClient.Close(); err != nil {
+			log.Printf("ERROR: Failed to close Firestore client: %v", err)
+		}
+	}()
+
+	result, err := repository.BackfillEmailIndex(context.Background(), firestoreClient)
+	if err != nil {
+		log.Fatalf("Email backfill failed after %d users: %v", result.Scanned, err)
+	}
+
+	log.Printf("Scanned %d users: normalized %d emails, created %d index entries", result.Scanned, result.Normalized, result.Indexed)
+	if len(result.Conflicts) > 0 {
+		log.Printf("Warning: %d users share an address with another user and were skipped: %v", len(result.Conflicts), result.Conflicts)
+	}
+}
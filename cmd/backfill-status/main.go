@@ -0,0 +1,37 @@
+// Command backfill-status migrates users created before accounts had a status: it marks
+// them as active, so that they are included in user lists, which leave out deleted users
+// by filtering on the status. It is safe to run more than once. Run it once after
+// upgrading, before serving traffic.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/hermantrym/go-firebase-api/internal/config"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found")
+	}
+
+	firestoreClient, err := config.InitializeFirebase()
+	if err != nil {
+		log.Fatalf("Failed to initialize Firestore: %v", err)
+	}
+	defer func() {
+		if err := firestoreClient.Close(); err != nil {
+			log.Printf("ERROR: Failed to close Firestore client: %v", err)
+		}
+	}()
+
+	scanned, updated, err := repository.BackfillUserStatus(context.Background(), firestoreClient)
+	if err != nil {
+		log.Fatalf("Status backfill failed after %d users: %v", scanned, err)
+	}
+
+	log.Printf("Scanned %d users: marked %d as active", scanned, updated)
+}
@@ -1,14 +1,44 @@
 package apierror
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 // APIError defines a standard error structure for our API responses.
+// It is rendered as an RFC 7807 problem details object (application/problem+json).
 type APIError struct {
-	// Code is the HTTP status code. The `json:"-"` tag prevents it from being
-	// rendered in the JSON response body.
-	Code int `json:"-"`
+	// Type is a URI identifying the problem type. It is "about:blank" for every error,
+	// as the problem is identified by Code.
+	Type string `json:"type"`
+	// Title is a short summary of the problem, the text of the HTTP status code.
+	Title string `json:"title"`
+	// Status is the HTTP status code.
+	Status int `json:"status"`
 	// Message is the user-friendly error message.
-	Message string `json:"error"`
+	Message string `json:"detail"`
+	// Code is a stable, machine-readable error code such as "USER_NOT_FOUND".
+	// Constructors default it from the status code; WithCode sets a more specific one.
+	Code string `json:"code"`
+	// Instance is the path of the request that failed. It is set when the error is rendered.
+	Instance string `json:"instance,omitempty"`
+	// RequestID identifies the request in the server's logs. It is set when the error is rendered.
+	RequestID string `json:"request_id,omitempty"`
+	// Details lists the individual problems with the fields of a request body.
+	Details []FieldError `json:"errors,omitempty"`
+	// RetryAfter is how long the client should wait before retrying. When set, it is
+	// sent in the Retry-After header rather than in the JSON response body.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// FieldError describes why a single field of a request body was rejected.
+type FieldError struct {
+	// Field is the name of the field, as sent by the client.
+	Field string `json:"field"`
+	// Code is the validation rule that failed, e.g. "required" or "email".
+	Code string `json:"code"`
+	// Message is a user-friendly description of the problem.
+	Message string `json:"message"`
 }
 
 // Error implements the standard Go error interface, allowing APIError to be
@@ -17,11 +47,22 @@ func (e *APIError) Error() string {
 	return e.Message
 }
 
-// NewAPIError creates a new instance of APIError.
-func NewAPIError(code int, message string) *APIError {
+// WithCode returns a copy of the error with a more specific machine-readable code.
+// It returns a copy so that errors shared by several call sites are never modified.
+func (e *APIError) WithCode(code string) *APIError {
+	copied := *e
+	copied.Code = code
+	return &copied
+}
+
+// NewAPIError creates a new instance of APIError, with the default code for the status.
+func NewAPIError(status int, message string) *APIError {
 	return &APIError{
-		Code:    code,
+		Type:    "about:blank",
+		Title:   http.StatusText(status),
+		Status:  status,
 		Message: message,
+		Code:    defaultCode(status),
 	}
 }
 
@@ -54,3 +95,56 @@ func NewBadRequestError(message string) *APIError {
 
 	return NewAPIError(http.StatusBadRequest, message)
 }
+
+// NewUnauthorizedError is a shortcut for creating a 401 Unauthorized error, for requests
+// without valid credentials.
+// It uses a default message if none is provided.
+func NewUnauthorizedError(message string) *APIError {
+	if message == "" {
+		message = "Authentication is required"
+	}
+
+	return NewAPIError(http.StatusUnauthorized, message)
+}
+
+// NewForbiddenError is a shortcut for creating a 403 Forbidden error, for authenticated
+// requests that are not allowed.
+// It uses a default message if none is provided.
+func NewForbiddenError(message string) *APIError {
+	if message == "" {
+		message = "You do not have permission to access this resource"
+	}
+
+	return NewAPIError(http.StatusForbidden, message)
+}
+
+// NewConflictError is a shortcut for creating a 409 Conflict error.
+// It uses a default message if none is provided.
+func NewConflictError(message string) *APIError {
+	if message == "" {
+		message = "The resource already exists"
+	}
+
+	return NewAPIError(http.StatusConflict, message)
+}
+
+// NewValidationError is a shortcut for creating a 422 Unprocessable Entity error for a
+// well-formed request body whose fields failed validation.
+func NewValidationError(details []FieldError) *APIError {
+	err := NewAPIError(http.StatusUnprocessableEntity, "The request body failed validation")
+	err.Details = details
+	return err
+}
+
+// NewTooManyRequestsError is a shortcut for creating a 429 Too Many Requests error
+// that tells the client how long to wait before retrying.
+// It uses a default message if none is provided.
+func NewTooManyRequestsError(message string, retryAfter time.Duration) *APIError {
+	if message == "" {
+		message = "Too many requests, please try again later"
+	}
+
+	err := NewAPIError(http.StatusTooManyRequests, message)
+	err.RetryAfter = retryAfter
+	return err
+}
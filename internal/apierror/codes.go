@@ -0,0 +1,97 @@
+package apierror
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Generic error codes, used by default for each status code.
+const (
+	CodeBadRequest       = "BAD_REQUEST"
+	CodeUnauthorized     = "UNAUTHORIZED"
+	CodeForbidden        = "FORBIDDEN"
+	CodeNotFound         = "NOT_FOUND"
+	CodeConflict         = "CONFLICT"
+	CodeValidationFailed = "VALIDATION_FAILED"
+	CodeRateLimited      = "RATE_LIMITED"
+	CodeInternal         = "INTERNAL_ERROR"
+)
+
+// Specific error codes that clients can rely on to handle particular problems.
+const (
+	// CodeInvalidJSON means that the request body is not valid JSON or misses required fields.
+	CodeInvalidJSON = "INVALID_JSON"
+	// CodeInvalidCursor means that a pagination cursor was not issued by this API.
+	CodeInvalidCursor = "INVALID_CURSOR"
+
+	// CodeAuthenticationRequired means that the request has no or a malformed Authorization header.
+	CodeAuthenticationRequired = "AUTHENTICATION_REQUIRED"
+	// CodeInvalidToken means that a token is invalid, expired or has already been used.
+	CodeInvalidToken = "INVALID_TOKEN"
+	// CodeTokenRevoked means that the access token was revoked by logout or by revoking all sessions.
+	CodeTokenRevoked = "TOKEN_REVOKED"
+	// CodeInvalidAPIKey means that an API key is unknown, invalid, expired or revoked.
+	CodeInvalidAPIKey = "INVALID_API_KEY"
+	// CodeInvalidCredentials means that the email address or password is wrong.
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	// CodeEmailNotVerified means that the user must verify their email address first.
+	CodeEmailNotVerified = "EMAIL_NOT_VERIFIED"
+	// CodeTooManyLoginAttempts means that logins are locked out after repeated failures.
+	CodeTooManyLoginAttempts = "TOO_MANY_LOGIN_ATTEMPTS"
+	// CodeAccountSuspended means that an administrator has suspended the user's account.
+	CodeAccountSuspended = "ACCOUNT_SUSPENDED"
+	// CodeAccountDeleted means that the user's account has been deleted.
+	CodeAccountDeleted = "ACCOUNT_DELETED"
+
+	// CodeMFARequired means that the account must use multi-factor authentication.
+	CodeMFARequired = "MFA_REQUIRED"
+	// CodeInvalidMFACode means that a TOTP or recovery code is wrong.
+	CodeInvalidMFACode = "INVALID_MFA_CODE"
+	// CodeMFAAlreadyEnabled means that MFA is already enabled for the user.
+	CodeMFAAlreadyEnabled = "MFA_ALREADY_ENABLED"
+
+	// CodeUserNotFound means that no user has the given ID or email address.
+	CodeUserNotFound = "USER_NOT_FOUND"
+	// CodeEmailTaken means that another user already has the email address.
+	CodeEmailTaken = "EMAIL_TAKEN"
+	// CodePasswordTooLong means that the password exceeds the 72 bytes bcrypt accepts.
+	CodePasswordTooLong = "PASSWORD_TOO_LONG"
+	// CodeInvalidRole means that the role is not one of the configured roles.
+	CodeInvalidRole = "INVALID_ROLE"
+	// CodeRoleChangeNotAllowed means that a role was changed outside PUT /admin/users/:id/role.
+	CodeRoleChangeNotAllowed = "ROLE_CHANGE_NOT_ALLOWED"
+	// CodeLastAdmin means that the change would leave no active admin.
+	CodeLastAdmin = "LAST_ADMIN"
+	// CodeInvalidStatusTransition means that the user's current status does not allow the change,
+	// e.g. restoring a user who is active.
+	CodeInvalidStatusTransition = "INVALID_STATUS_TRANSITION"
+	// CodeIdentityAlreadyLinked means that an external account is linked to another user.
+	CodeIdentityAlreadyLinked = "IDENTITY_ALREADY_LINKED"
+
+	// CodeAPIKeyNotFound means that no API key has the given ID.
+	CodeAPIKeyNotFound = "API_KEY_NOT_FOUND"
+	// CodeUnknownProvider means that no login provider has the given name.
+	CodeUnknownProvider = "UNKNOWN_PROVIDER"
+)
+
+// defaultCodes maps status codes to their generic error code.
+var defaultCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeValidationFailed,
+	http.StatusTooManyRequests:     CodeRateLimited,
+	http.StatusInternalServerError: CodeInternal,
+}
+
+// defaultCode returns the generic error code for a status code. Status codes without
+// one get their status text in upper snake case, e.g. "BAD_GATEWAY".
+func defaultCode(status int) string {
+	if code, ok := defaultCodes[status]; ok {
+		return code
+	}
+
+	return strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
@@ -0,0 +1,45 @@
+package apierror
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/requestid"
+)
+
+// ContentType is the media type of error responses (RFC 7807).
+const ContentType = "application/problem+json"
+
+// Respond writes an error response. An APIError is rendered with its own status code,
+// and a Retry-After header when it has one; any other error falls back to a generic 500
+// response, so that internal details never reach the client. The request path and ID
+// are added to the rendered error.
+func Respond(c *gin.Context, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = NewInternalServerError("An unexpected error occurred")
+	}
+
+	if apiErr.RetryAfter > 0 {
+		// Retry-After is given in whole seconds, rounded up so clients never retry too early.
+		seconds := int64((apiErr.RetryAfter + time.Second - 1) / time.Second)
+		c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+
+	// Render a copy, as errors may be shared by several requests.
+	rendered := *apiErr
+	rendered.Instance = c.Request.URL.Path
+	rendered.RequestID = requestid.Get(c)
+
+	c.Header("Content-Type", ContentType)
+	c.JSON(rendered.Status, rendered)
+}
+
+// Abort writes an error response like Respond and stops the remaining handlers from
+// running. Middlewares use it to refuse a request.
+func Abort(c *gin.Context, err error) {
+	c.Abort()
+	Respond(c, err)
+}
@@ -0,0 +1,78 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/requestid"
+)
+
+func TestRespond(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter string
+		wantDetails    int
+	}{
+		{name: "not found with specific code", err: NewNotFoundError("User not found").WithCode(CodeUserNotFound), wantStatus: http.StatusNotFound, wantCode: CodeUserNotFound},
+		{name: "default code", err: NewConflictError(""), wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "unauthorized", err: NewUnauthorizedError(""), wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "forbidden", err: NewForbiddenError(""), wantStatus: http.StatusForbidden, wantCode: CodeForbidden},
+		{name: "validation", err: NewValidationError([]FieldError{{Field: "email", Code: "email", Message: "email must be a valid email address"}}), wantStatus: http.StatusUnprocessableEntity, wantCode: CodeValidationFailed, wantDetails: 1},
+		{name: "retry after is rounded up", err: NewTooManyRequestsError("", 1500*time.Millisecond), wantStatus: http.StatusTooManyRequests, wantCode: CodeRateLimited, wantRetryAfter: "2"},
+		{name: "status without a generic code", err: NewAPIError(http.StatusBadGateway, "Upstream failed"), wantStatus: http.StatusBadGateway, wantCode: "BAD_GATEWAY"},
+		{name: "plain error is hidden", err: errors.New("database password is wrong"), wantStatus: http.StatusInternalServerError, wantCode: CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(requestid.Middleware())
+			r.GET("/users/:id", func(c *gin.Context) { Abort(c, tt.err) })
+
+			req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+			req.Header.Set(requestid.Header, "req-123")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); got != ContentType {
+				t.Errorf("Content-Type = %q, want %q", got, ContentType)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			var body APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON body %s: %v", w.Body.String(), err)
+			}
+			if body.Status != tt.wantStatus || body.Code != tt.wantCode {
+				t.Errorf("status, code = %d, %q, want %d, %q", body.Status, body.Code, tt.wantStatus, tt.wantCode)
+			}
+			if body.Type != "about:blank" || body.Title != http.StatusText(tt.wantStatus) {
+				t.Errorf("type, title = %q, %q", body.Type, body.Title)
+			}
+			if body.Instance != "/users/42" || body.RequestID != "req-123" {
+				t.Errorf("instance, request_id = %q, %q", body.Instance, body.RequestID)
+			}
+			if len(body.Details) != tt.wantDetails {
+				t.Errorf("got %d field errors, want %d", len(body.Details), tt.wantDetails)
+			}
+			if tt.wantStatus == http.StatusInternalServerError && body.Message != "An unexpected error occurred" {
+				t.Errorf("internal error detail leaked: %q", body.Message)
+			}
+		})
+	}
+}
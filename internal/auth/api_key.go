@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// APIKeyHeader is the request header that carries API keys.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to recognize.
+const apiKeyPrefix = "gfa_"
+
+// apiKeyPrincipalPrefix is followed by the key ID in the userID of requests authenticated
+// with an API key, so that they are never mistaken for a user.
+const apiKeyPrincipalPrefix = "apikey:"
+
+// apiKeyTouchInterval is how often the last use of an API key is written to the store.
+const apiKeyTouchInterval = time.Minute
+
+// APIKeyStore looks up API keys by ID and records when they were last used.
+type APIKeyStore interface {
+	GetAPIKey(ctx context.Context, id string) (*model.APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// GenerateAPIKey creates a new API key of the form "gfa_<id>.<secret>".
+// It returns the plaintext key for the client, its ID and the hash of its secret for storage.
+func GenerateAPIKey() (key, id, secretHash string, err error) {
+	id, err = GenerateRandomToken(12)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return apiKeyPrefix + id + "." + secret, id, HashToken(secret), nil
+}
+
+// parseAPIKey splits an API key into its ID and secret.
+func parseAPIKey(key string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	id, secret, ok = strings.Cut(rest, ".")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// IsAPIKey reports whether the request was authenticated with an API key rather than a user's token.
+func IsAPIKey(c *gin.Context) bool {
+	return c.GetString("apiKeyID") != ""
+}
+
+// authenticateAPIKey verifies an API key and stores its role in the context like
+// AuthMiddleware does for user tokens. The userID is "apikey:<id>", which never matches
+// a user, so the key can only act on users through its role's permissions, limited to
+// its scopes. Last use is recorded at most once per apiKeyTouchInterval.
+func authenticateAPIKey(c *gin.Context, keys APIKeyStore, presented string) {
+	ctx := c.Request.Context()
+	invalidKey := apierror.NewUnauthorizedError("Invalid or expired API key").WithCode(apierror.CodeInvalidAPIKey)
+
+	id, secret, ok := parseAPIKey(presented)
+	if !ok {
+		apierror.Abort(c, invalidKey)
+		return
+	}
+
+	key, err := keys.GetAPIKey(ctx, id)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			apierror.Abort(c, invalidKey)
+			return
+		}
+		apiErr = apierror.NewInternalServerError("Failed to verify API key")
+		apierror.Abort(c, apiErr)
+		return
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(key.SecretHash)) != 1 ||
+		key.RevokedAt != nil || !now.Before(key.ExpiresAt) {
+		apierror.Abort(c, invalidKey)
+		return
+	}
+
+	// Failing to record the last use must not fail the request.
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := keys.TouchAPIKey(ctx, key.ID, now); err != nil {
+			log.Printf("Error recording use of API key %s: %v", key.ID, err)
+		}
+	}
+
+	c.Set("userID", apiKeyPrincipalPrefix+key.ID)
+	c.Set("userRole", key.Role)
+	c.Set("apiKeyID", key.ID)
+	c.Set("apiKeyScopes", key.Scopes)
+
+	c.Next()
+}
+
+// scopeAllows reports whether the API key of the request, if any, is allowed to use the
+// permission. Requests authenticated otherwise, and keys without scopes, are not restricted.
+func scopeAllows(c *gin.Context, permission role.Permission) bool {
+	value, _ := c.Get("apiKeyScopes")
+	scopes, _ := value.([]role.Permission)
+	if len(scopes) == 0 {
+		return true
+	}
+
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+
+	return false
+}
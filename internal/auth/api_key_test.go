@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// stubAPIKeys holds API keys in a map and records which keys were touched.
+type stubAPIKeys struct {
+	keys    map[string]*model.APIKey
+	touched map[string]time.Time
+}
+
+func (s *stubAPIKeys) GetAPIKey(_ context.Context, id string) (*model.APIKey, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, apierror.NewNotFoundError("API key not found")
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (s *stubAPIKeys) TouchAPIKey(_ context.Context, id string, usedAt time.Time) error {
+	s.touched[id] = usedAt
+	return nil
+}
+
+func TestAuthMiddlewareAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, id, secretHash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recently := time.Now().Add(-10 * time.Second)
+	revokedAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		presented  string
+		modify     func(*model.APIKey)
+		permission role.Permission
+		wantStatus int
+		wantTouch  bool
+	}{
+		{name: "valid key", presented: plaintext, wantStatus: http.StatusOK, wantTouch: true},
+		{name: "recently used key is not touched again", presented: plaintext, modify: func(k *model.APIKey) { k.LastUsedAt = &recently }, wantStatus: http.StatusOK},
+		{name: "wrong secret", presented: apiKeyPrefix + id + ".wrong", wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", presented: apiKeyPrefix + "unknown." + "secret", wantStatus: http.StatusUnauthorized},
+		{name: "malformed key", presented: "not-a-key", wantStatus: http.StatusUnauthorized},
+		{name: "expired key", presented: plaintext, modify: func(k *model.APIKey) { k.ExpiresAt = time.Now().Add(-time.Minute) }, wantStatus: http.StatusUnauthorized},
+		{name: "revoked key", presented: plaintext, modify: func(k *model.APIKey) { k.RevokedAt = &revokedAt }, wantStatus: http.StatusUnauthorized},
+		{name: "permission granted by role", presented: plaintext, permission: role.UsersRead, wantStatus: http.StatusOK, wantTouch: true},
+		{name: "permission outside scopes", presented: plaintext, modify: func(k *model.APIKey) { k.Scopes = []role.Permission{role.UsersRead} }, permission: role.UsersDelete, wantStatus: http.StatusForbidden, wantTouch: true},
+		{name: "permission within scopes", presented: plaintext, modify: func(k *model.APIKey) { k.Scopes = []role.Permission{role.UsersRead} }, permission: role.UsersRead, wantStatus: http.StatusOK, wantTouch: true},
+		{name: "permission not granted by role", presented: plaintext, modify: func(k *model.APIKey) { k.Role = role.User }, permission: role.UsersRead, wantStatus: http.StatusForbidden, wantTouch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &model.APIKey{
+				ID:         id,
+				Name:       "batch job",
+				Role:       role.Admin,
+				SecretHash: secretHash,
+				ExpiresAt:  time.Now().Add(time.Hour),
+			}
+			if tt.modify != nil {
+				tt.modify(key)
+			}
+			store := &stubAPIKeys{keys: map[string]*model.APIKey{id: key}, touched: map[string]time.Time{}}
+			cfg := &Config{Keys: keys, Issuer: "test-issuer", Audience: "test-audience", APIKeys: store}
+
+			handlers := []gin.HandlerFunc{AuthMiddleware(cfg, nil)}
+			if tt.permission != "" {
+				handlers = append(handlers, RequirePermission(tt.permission))
+			}
+			var gotUserID string
+			var gotRole role.Role
+			handlers = append(handlers, func(c *gin.Context) {
+				gotUserID = c.GetString("userID")
+				gotRole = CurrentRole(c)
+				c.Status(http.StatusOK)
+			})
+
+			r := gin.New()
+			r.GET("/", handlers...)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(APIKeyHeader, tt.presented)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if _, touched := store.touched[id]; touched != tt.wantTouch {
+				t.Errorf("touched = %t, want %t", touched, tt.wantTouch)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if gotUserID != apiKeyPrincipalPrefix+id {
+				t.Errorf("userID = %q, want %q", gotUserID, apiKeyPrincipalPrefix+id)
+			}
+			if gotRole != key.Role {
+				t.Errorf("userRole = %q, want %q", gotRole, key.Role)
+			}
+		})
+	}
+}
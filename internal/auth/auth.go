@@ -1,16 +1,17 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"github.com/hermantrym/go-firebase-api/internal/role"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
 )
 
 // JWTClaims defines the custom claims to be stored in the JWT payload,
@@ -19,117 +20,324 @@ type JWTClaims struct {
 	UserID string    `json:"user_id"`
 	Email  string    `json:"email"`
 	Role   role.Role `json:"role"`
+	// AuthMethods lists how the user authenticated (the amr claim), e.g. "mfa" after a second factor.
+	AuthMethods []string `json:"amr,omitempty"`
+	// IssuedAtMillis is the time the token was issued in milliseconds since the epoch. The iat
+	// claim only has whole-second precision, which cannot tell a token issued right after a
+	// revocation of the user's sessions from one issued right before it.
+	IssuedAtMillis int64 `json:"iat_ms,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT creates a new signed JWT for a given user, including their role.
-// It relies on the JWT_SECRET_KEY environment variable for signing.
-func GenerateJWT(userID, email string, userRole role.Role) (string, error) {
-	// Retrieve the secret key from environment variables.
-	secretKey := os.Getenv("JWT_SECRET_KEY")
-	if secretKey == "" {
-		return "", errors.New("JWT_SECRET_KEY environment variable not set")
-	}
+// RevocationStore reports whether an access token has been revoked, either individually
+// by its ID (the jti claim) or because all sessions of its user were revoked after it was issued.
+type RevocationStore interface {
+	IsRevoked(ctx context.Context, tokenID, userID string, issuedAt time.Time) (bool, error)
+}
+
+// GenerateJWT creates a new JWT for a given user, including their role, signed with the
+// current signing key of the configured key set. Each token gets a unique ID (the jti claim)
+// so that it can be revoked individually. The optional methods are recorded in the amr claim.
+func GenerateJWT(cfg *Config, userID, email string, userRole role.Role, methods ...string) (string, error) {
+	now := time.Now()
+	// Set the token's expiration time. Access tokens are short-lived and renewed with a refresh token.
+	expirationTime := now.Add(cfg.AccessTokenTTL)
 
-	// Set the token's expiration time (e.g., 24 hours).
-	expirationTime := time.Now().Add(24 * time.Hour)
+	tokenID, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
 
 	// Create the JWT claims, including custom and registered claims.
 	claims := &JWTClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   userRole,
+		UserID:      userID,
+		Email:       email,
+		Role:        userRole,
+		AuthMethods: methods,
+		// Truncated like iat, so that the claim is never later than the actual issue time.
+		IssuedAtMillis: now.UnixMilli(),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "go-firebase-api",
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.Audience},
 		},
 	}
 
-	// Create a new token with the claims and HS256 signing method.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token with the secret key to get the complete token string.
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	// Sign the token to get the complete token string. The kid header identifies the key.
+	return cfg.Keys.sign(claims)
 }
 
 // AuthMiddleware creates a gin middleware to verify the JWT from the Authorization header.
-func AuthMiddleware() gin.HandlerFunc {
+// Tokens must be signed with one of the configured keys and algorithms, carry the configured
+// issuer and audience, and be within their nbf/exp window, allowing for the configured leeway.
+// If a revocation store is provided, tokens that have been revoked are rejected as well.
+// Suspending or deleting a user revokes all of their sessions, so their tokens are refused
+// from then on.
+// If cfg.Firebase is set, Firebase ID tokens are accepted too (see authenticateFirebaseToken),
+// and if cfg.APIKeys is set, so are API keys in the X-API-Key header (see authenticateAPIKey).
+// It panics if the configuration has no keys, so that a misconfigured server fails at startup.
+func AuthMiddleware(cfg *Config, revocations RevocationStore) gin.HandlerFunc {
+	if cfg == nil || cfg.Keys == nil {
+		panic("auth: AuthMiddleware requires a configuration with signing keys")
+	}
+	parser := cfg.parser()
+
 	return func(c *gin.Context) {
-		secretKey := os.Getenv("JWT_SECRET_KEY")
+		// Machine-to-machine clients authenticate with an API key instead of a token.
+		if key := c.GetHeader(APIKeyHeader); key != "" && cfg.APIKeys != nil {
+			authenticateAPIKey(c, cfg.APIKeys, key)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 
 		if authHeader == "" {
-			err := apierror.NewAPIError(http.StatusUnauthorized, "Authorization header is required")
-			c.AbortWithStatusJSON(err.Code, err)
+			err := apierror.NewUnauthorizedError("Authorization header is required").WithCode(apierror.CodeAuthenticationRequired)
+			apierror.Abort(c, err)
 			return
 		}
 
 		// The token is expected in the format "Bearer <token>".
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			err := apierror.NewAPIError(http.StatusUnauthorized, "Authorization header format must be Bearer {token}")
-			c.AbortWithStatusJSON(err.Code, err)
+			err := apierror.NewUnauthorizedError("Authorization header format must be Bearer {token}").WithCode(apierror.CodeAuthenticationRequired)
+			apierror.Abort(c, err)
 			return
 		}
 
 		tokenString := parts[1]
+
+		// Tokens issued by Firebase Authentication are verified against Google's keys instead.
+		if cfg.Firebase != nil && isFirebaseToken(tokenString) {
+			authenticateFirebaseToken(c, cfg, revocations, tokenString)
+			return
+		}
+
 		claims := &JWTClaims{}
 
 		// Parse and validate the token.
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Provide the key for signature verification.
-			return []byte(secretKey), nil
-		})
-
-		if err != nil || !token.Valid {
-			apiErr := apierror.NewAPIError(http.StatusUnauthorized, "Invalid or expired token")
-			c.AbortWithStatusJSON(apiErr.Code, apiErr)
+		// The key set selects the verification key from the token's kid header.
+		token, err := parser.ParseWithClaims(tokenString, claims, cfg.Keys.keyfunc)
+
+		// Every token issued by GenerateJWT has an nbf claim, so reject tokens without one.
+		if err != nil || !token.Valid || claims.NotBefore == nil {
+			apiErr := apierror.NewUnauthorizedError("Invalid or expired token").WithCode(apierror.CodeInvalidToken)
+			apierror.Abort(c, apiErr)
 			return
 		}
 
+		// Reject tokens that were revoked by logout or by revoking all of the user's sessions.
+		if revocations != nil {
+			var issuedAt time.Time
+			switch {
+			case claims.IssuedAtMillis > 0:
+				issuedAt = time.UnixMilli(claims.IssuedAtMillis)
+			case claims.IssuedAt != nil:
+				issuedAt = claims.IssuedAt.Time
+			}
+
+			revoked, err := revocations.IsRevoked(c.Request.Context(), claims.ID, claims.UserID, issuedAt)
+			if err != nil {
+				apiErr := apierror.NewInternalServerError("Failed to verify token")
+				apierror.Abort(c, apiErr)
+				return
+			}
+			if revoked {
+				apiErr := apierror.NewUnauthorizedError("Token has been revoked").WithCode(apierror.CodeTokenRevoked)
+				apierror.Abort(c, apiErr)
+				return
+			}
+		}
+
 		// Store the user ID in the context for use by subsequent handlers.
 		c.Set("userID", claims.UserID)
 		c.Set("userRole", claims.Role)
+		c.Set("authMethods", claims.AuthMethods)
+		// Store the token ID and expiry so that the token can be revoked on logout.
+		c.Set("tokenID", claims.ID)
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 
 		// Continue to the next handler.
 		c.Next()
 	}
 }
 
+// authenticateFirebaseToken verifies a Firebase ID token, maps it to a user and stores the
+// user in the context like AuthMiddleware does for the API's own tokens. The role is the
+// user's stored role; the token's custom claim only applies to new users. Firebase tokens
+// have no token ID, so they can only be revoked by revoking all of the user's sessions.
+// Suspended and deleted users are refused, and so, with cfg.RequireVerifiedEmail, are users
+// whose email is not verified, as they are at every other login.
+func authenticateFirebaseToken(c *gin.Context, cfg *Config, revocations RevocationStore, tokenString string) {
+	ctx := c.Request.Context()
+	firebase := cfg.Firebase
+
+	token, err := firebase.Verifier.Verify(ctx, tokenString)
+	if err != nil {
+		apiErr := apierror.NewUnauthorizedError("Invalid or expired token").WithCode(apierror.CodeInvalidToken)
+		apierror.Abort(c, apiErr)
+		return
+	}
+
+	user, err := firebase.Users.ResolveFirebaseUser(ctx, token)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if !errors.As(err, &apiErr) {
+			apiErr = apierror.NewInternalServerError("Failed to verify token")
+		}
+		apierror.Abort(c, apiErr)
+		return
+	}
+
+	if err := CheckUserActive(user); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	if cfg.RequireVerifiedEmail && !user.Verified {
+		apiErr := apierror.NewForbiddenError("Email address has not been verified").WithCode(apierror.CodeEmailNotVerified)
+		apierror.Abort(c, apiErr)
+		return
+	}
+
+	if revocations != nil {
+		revoked, err := revocations.IsRevoked(ctx, "", user.ID, token.IssuedAt)
+		if err != nil {
+			apiErr := apierror.NewInternalServerError("Failed to verify token")
+			apierror.Abort(c, apiErr)
+			return
+		}
+		if revoked {
+			apiErr := apierror.NewUnauthorizedError("Token has been revoked").WithCode(apierror.CodeTokenRevoked)
+			apierror.Abort(c, apiErr)
+			return
+		}
+	}
+
+	// A user demoted through the API must not keep their old role through the claim.
+	c.Set("userID", user.ID)
+	c.Set("userRole", user.Role)
+	c.Set("authMethods", token.AuthMethods)
+	c.Set("tokenExpiresAt", token.ExpiresAt)
+
+	c.Next()
+}
+
+// CheckUserActive returns a 403 APIError if the user's account is suspended or deleted,
+// and nil if the user may log in and use the API.
+func CheckUserActive(user *model.User) error {
+	switch {
+	case user.IsActive():
+		return nil
+	case user.Status == model.UserStatusDeleted:
+		return apierror.NewForbiddenError("Account has been deleted").WithCode(apierror.CodeAccountDeleted)
+	default:
+		return apierror.NewForbiddenError("Account has been suspended").WithCode(apierror.CodeAccountSuspended)
+	}
+}
+
 // RoleAuthMiddleware creates a gin middleware to authorize access based on a required role.
+// Roles that inherit from the required role are allowed as well, so an admin can access
+// a route gated for "user".
 // This middleware should be used *after* the AuthMiddleware.
-func RoleAuthMiddleware(requiredRole string) gin.HandlerFunc {
+func RoleAuthMiddleware(requiredRole role.Role) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Retrieve the user's role from the context (set by AuthMiddleware).
-		userRole, exists := c.Get("userRole")
-		if !exists {
-			err := apierror.NewAPIError(http.StatusForbidden, "User role not found in token")
-			c.AbortWithStatusJSON(err.Code, err)
+		userRole, apiErr := roleFromContext(c)
+		if apiErr != nil {
+			apierror.Abort(c, apiErr)
 			return
 		}
 
-		// Type assert the role from the context.
-		roleFromContext, ok := userRole.(role.Role)
-		if !ok {
-			err := apierror.NewAPIError(http.StatusInternalServerError, "User role in context has an invalid type")
-			c.AbortWithStatusJSON(err.Code, err)
+		// Check if the user's role is, or inherits from, the required role.
+		if !userRole.Includes(requiredRole) {
+			err := apierror.NewForbiddenError("You do not have permission to access this resource")
+			apierror.Abort(c, err)
 			return
 		}
 
-		// Check if the user's role matches the required role.
-		if string(roleFromContext) != requiredRole {
-			err := apierror.NewAPIError(http.StatusForbidden, "You do not have permission to access this resource")
-			c.AbortWithStatusJSON(err.Code, err)
+		// If authorization is successful, proceed to the next handler.
+		c.Next()
+	}
+}
+
+// RequirePermission creates a gin middleware that only allows the request through when
+// the user's role grants every one of the given permissions, directly or through inheritance.
+// Requests authenticated with an API key are further limited to the key's scopes.
+// This middleware should be used *after* the AuthMiddleware.
+func RequirePermission(permissions ...role.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRole, apiErr := roleFromContext(c)
+		if apiErr != nil {
+			apierror.Abort(c, apiErr)
+			return
+		}
+
+		for _, permission := range permissions {
+			if !userRole.HasPermission(permission) || !scopeAllows(c, permission) {
+				err := apierror.NewForbiddenError("You do not have permission to access this resource")
+				apierror.Abort(c, err)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// IsSelfOrPermitted reports whether the authenticated user (set by AuthMiddleware) is either
+// the user identified by targetUserID or has a role that grants the permission, which is
+// what allows acting on other users' profiles. API keys are limited to their scopes.
+// Handlers can use it directly when the target user is not taken from a path parameter.
+func IsSelfOrPermitted(c *gin.Context, targetUserID string, permission role.Permission) bool {
+	if userRole, apiErr := roleFromContext(c); apiErr == nil && userRole.HasPermission(permission) && scopeAllows(c, permission) {
+		return true
+	}
+
+	userID := c.GetString("userID")
+	return userID != "" && userID == targetUserID
+}
+
+// SelfOrPermissionMiddleware creates a gin middleware that only allows the request through
+// when the authenticated user owns the resource identified by the given path parameter
+// (e.g. "id" for /users/:id), or has a role that grants the permission.
+// This middleware should be used *after* the AuthMiddleware.
+func SelfOrPermissionMiddleware(param string, permission role.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsSelfOrPermitted(c, c.Param(param), permission) {
+			err := apierror.NewForbiddenError("You do not have permission to access this resource")
+			apierror.Abort(c, err)
 			return
 		}
 
-		// If authorization is successful, proceed to the next handler.
 		c.Next()
 	}
 }
+
+// CurrentRole returns the role of the authenticated user (set by AuthMiddleware), or an
+// empty role, which grants no permissions, if there is none.
+func CurrentRole(c *gin.Context) role.Role {
+	userRole, _ := roleFromContext(c)
+	return userRole
+}
+
+// roleFromContext retrieves the user's role from the context (set by AuthMiddleware).
+// It returns an APIError describing the problem if the role is missing or has an invalid type.
+func roleFromContext(c *gin.Context) (role.Role, *apierror.APIError) {
+	userRole, exists := c.Get("userRole")
+	if !exists {
+		return "", apierror.NewForbiddenError("User role not found in token")
+	}
+
+	// Type assert the role from the context.
+	r, ok := userRole.(role.Role)
+	if !ok {
+		return "", apierror.NewAPIError(http.StatusInternalServerError, "User role in context has an invalid type")
+	}
+
+	return r, nil
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// stubTokenRevocations reports the configured tokens, and all tokens of the configured users, as revoked.
+type stubTokenRevocations struct {
+	tokens map[string]bool
+	users  map[string]bool
+	err    error
+}
+
+func (s *stubTokenRevocations) IsRevoked(_ context.Context, tokenID, userID string, _ time.Time) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.tokens[tokenID] || s.users[userID], nil
+}
+
+// newTestConfig returns a token configuration with a freshly generated Ed25519 signing key.
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Config{Keys: keys, Issuer: "test-issuer", Audience: "test-audience", AccessTokenTTL: time.Minute}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := newTestConfig(t)
+
+	token := func(modify func(*Config)) string {
+		tokenCfg := *cfg
+		if modify != nil {
+			modify(&tokenCfg)
+		}
+		signed, err := GenerateJWT(&tokenCfg, "user-1", "user@example.com", role.Admin, "pwd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	valid := token(nil)
+
+	otherCfg := newTestConfig(t)
+	foreign, err := GenerateJWT(otherCfg, "user-1", "user@example.com", role.Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		header      string
+		revocations RevocationStore
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "valid token", header: "Bearer " + valid, wantStatus: http.StatusOK},
+		{name: "missing header", wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeAuthenticationRequired},
+		{name: "not a bearer token", header: "Basic " + valid, wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeAuthenticationRequired},
+		{name: "extra parts", header: "Bearer " + valid + " extra", wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeAuthenticationRequired},
+		{name: "malformed token", header: "Bearer not-a-token", wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidToken},
+		{name: "signed with an unknown key", header: "Bearer " + foreign, wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidToken},
+		{name: "expired", header: "Bearer " + token(func(c *Config) { c.AccessTokenTTL = -time.Minute }), wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidToken},
+		{name: "wrong audience", header: "Bearer " + token(func(c *Config) { c.Audience = "other-audience" }), wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidToken},
+		{name: "wrong issuer", header: "Bearer " + token(func(c *Config) { c.Issuer = "other-issuer" }), wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidToken},
+		{name: "sessions of the user revoked", header: "Bearer " + valid, revocations: &stubTokenRevocations{users: map[string]bool{"user-1": true}}, wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeTokenRevoked},
+		{name: "other user revoked", header: "Bearer " + valid, revocations: &stubTokenRevocations{users: map[string]bool{"user-2": true}}, wantStatus: http.StatusOK},
+		{name: "revocation store failure", header: "Bearer " + valid, revocations: &stubTokenRevocations{err: errors.New("unavailable")}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID string
+			var gotRole role.Role
+			var gotMethods []string
+
+			r := gin.New()
+			r.GET("/", AuthMiddleware(cfg, tt.revocations), func(c *gin.Context) {
+				gotUserID = c.GetString("userID")
+				gotRole = CurrentRole(c)
+				gotMethods = c.GetStringSlice("authMethods")
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				assertProblemCode(t, w, tt.wantCode)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if gotUserID != "user-1" || gotRole != role.Admin {
+				t.Errorf("context = %q, %q, want %q, %q", gotUserID, gotRole, "user-1", role.Admin)
+			}
+			if len(gotMethods) != 1 || gotMethods[0] != "pwd" {
+				t.Errorf("authMethods = %v, want [pwd]", gotMethods)
+			}
+		})
+	}
+}
+
+// recordingRevocations records the issue time of the tokens it is asked about.
+type recordingRevocations struct {
+	issuedAt time.Time
+}
+
+func (s *recordingRevocations) IsRevoked(_ context.Context, _, _ string, issuedAt time.Time) (bool, error) {
+	s.issuedAt = issuedAt
+	return false, nil
+}
+
+func TestAuthMiddlewareIssuedAtPrecision(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := newTestConfig(t)
+
+	// Wait for a time within a second, so that whole seconds would lose the difference.
+	for time.Now().Nanosecond() < 100*int(time.Millisecond) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	before := time.Now()
+	signed, err := GenerateJWT(cfg, "user-1", "user@example.com", role.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revocations := &recordingRevocations{}
+	r := gin.New()
+	r.GET("/", AuthMiddleware(cfg, revocations), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	// Revocations in the same second must be able to tell whether the token came after them.
+	if got := revocations.issuedAt; got.Before(before.Truncate(time.Millisecond)) || got.After(time.Now()) {
+		t.Errorf("issue time = %s, want the millisecond the token was issued, at or after %s", got, before)
+	}
+}
+
+func TestAuthorizationMiddlewares(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		userID     string
+		userRole   interface{}
+		path       string
+		middleware gin.HandlerFunc
+		wantStatus int
+	}{
+		{name: "permission granted", userRole: role.Admin, middleware: RequirePermission(role.UsersRead, role.UsersWrite), wantStatus: http.StatusOK},
+		{name: "one permission missing", userRole: role.User, middleware: RequirePermission(role.UsersRead), wantStatus: http.StatusForbidden},
+		{name: "permission without a role", middleware: RequirePermission(role.UsersRead), wantStatus: http.StatusForbidden},
+		{name: "role of an invalid type", userRole: "admin", middleware: RequirePermission(role.UsersRead), wantStatus: http.StatusInternalServerError},
+		{name: "required role", userRole: role.User, middleware: RoleAuthMiddleware(role.User), wantStatus: http.StatusOK},
+		{name: "inheriting role", userRole: role.Admin, middleware: RoleAuthMiddleware(role.User), wantStatus: http.StatusOK},
+		{name: "insufficient role", userRole: role.User, middleware: RoleAuthMiddleware(role.Admin), wantStatus: http.StatusForbidden},
+		{name: "role missing", middleware: RoleAuthMiddleware(role.User), wantStatus: http.StatusForbidden},
+		{name: "own resource", userID: "user-1", userRole: role.User, path: "/users/user-1", middleware: SelfOrPermissionMiddleware("id", role.UsersRead), wantStatus: http.StatusOK},
+		{name: "other user's resource", userID: "user-1", userRole: role.User, path: "/users/user-2", middleware: SelfOrPermissionMiddleware("id", role.UsersRead), wantStatus: http.StatusForbidden},
+		{name: "other user's resource with permission", userID: "admin-1", userRole: role.Admin, path: "/users/user-2", middleware: SelfOrPermissionMiddleware("id", role.UsersRead), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUser := func(c *gin.Context) {
+				if tt.userID != "" {
+					c.Set("userID", tt.userID)
+				}
+				if tt.userRole != nil {
+					c.Set("userRole", tt.userRole)
+				}
+			}
+
+			r := gin.New()
+			r.GET("/users/:id", setUser, tt.middleware, func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			path := tt.path
+			if path == "" {
+				path = "/users/any"
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCheckUserActive(t *testing.T) {
+	tests := []struct {
+		status   model.UserStatus
+		wantCode string
+	}{
+		{status: ""},
+		{status: model.UserStatusActive},
+		{status: model.UserStatusSuspended, wantCode: apierror.CodeAccountSuspended},
+		{status: model.UserStatusDeleted, wantCode: apierror.CodeAccountDeleted},
+	}
+
+	for _, tt := range tests {
+		err := CheckUserActive(&model.User{Status: tt.status})
+		if tt.wantCode == "" {
+			if err != nil {
+				t.Errorf("status %q: unexpected error: %v", tt.status, err)
+			}
+			continue
+		}
+
+		var apiErr *apierror.APIError
+		if !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden || apiErr.Code != tt.wantCode {
+			t.Errorf("status %q: error = %v, want a 403 %s", tt.status, err, tt.wantCode)
+		}
+	}
+}
+
+// assertProblemCode fails the test if the response is not a problem with the given code.
+func assertProblemCode(t *testing.T, w *httptest.ResponseRecorder, want string) {
+	t.Helper()
+
+	var problem struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("invalid problem body %q: %v", w.Body.String(), err)
+	}
+	if problem.Code != want {
+		t.Errorf("code = %q, want %q", problem.Code, want)
+	}
+}
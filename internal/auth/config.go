@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Default values used when the corresponding environment variables are not set.
+const (
+	defaultIssuer          = "go-firebase-api"
+	defaultAudience        = "go-firebase-api"
+	defaultClockSkew       = 30 * time.Second
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	defaultEmailVerificationTTL = 24 * time.Hour
+	defaultMagicLinkTTL         = 15 * time.Minute
+	defaultMFAChallengeTTL      = 5 * time.Minute
+
+	defaultLoginMaxAccountFailures = 5
+	defaultLoginMaxIPFailures      = 20
+	defaultLoginBackoffBase        = 30 * time.Second
+	defaultLoginBackoffMax         = 15 * time.Minute
+	defaultLoginFailureWindow      = time.Hour
+)
+
+// Config holds everything needed to issue and verify access tokens.
+// It is loaded once at startup and shared by the AuthMiddleware and the services.
+type Config struct {
+	// Keys signs new tokens and verifies presented ones.
+	Keys *KeySet
+
+	// Issuer is written to and required in the iss claim.
+	Issuer string
+
+	// Audience is written to and required in the aud claim.
+	Audience string
+
+	// Leeway is the clock skew tolerated when checking the exp, nbf and iat claims.
+	Leeway time.Duration
+
+	// AccessTokenTTL is the lifetime of access tokens.
+	AccessTokenTTL time.Duration
+
+	// RefreshTokenTTL is the lifetime of refresh tokens.
+	RefreshTokenTTL time.Duration
+
+	// EmailVerificationTTL is the lifetime of email verification tokens.
+	EmailVerificationTTL time.Duration
+
+	// RequireVerifiedEmail blocks login for users who have not verified their email address.
+	RequireVerifiedEmail bool
+
+	// EmailVerificationURL is the page linked from verification emails. The token is
+	// appended as the "token" query parameter. When empty, only the token is sent.
+	EmailVerificationURL string
+
+	// MagicLinkTTL is the lifetime of passwordless login links.
+	MagicLinkTTL time.Duration
+
+	// MagicLinkURL is the page linked from login emails. The token is appended as the
+	// "token" query parameter. When empty, only the token is sent.
+	MagicLinkURL string
+
+	// MFAChallengeTTL is the lifetime of the challenge token returned by the first login step
+	// for users with MFA enabled.
+	MFAChallengeTTL time.Duration
+
+	// RequireAdminMFA refuses admin routes to admins whose token was issued without MFA.
+	RequireAdminMFA bool
+
+	// TOTPIssuer labels the account in authenticator apps.
+	TOTPIssuer string
+
+	// Firebase, if set, makes the AuthMiddleware accept Firebase ID tokens as well.
+	Firebase *FirebaseAuth
+
+	// APIKeys, if set, makes the AuthMiddleware accept API keys in the X-API-Key header.
+	APIKeys APIKeyStore
+
+	// LoginThrottle limits failed password logins per account and per client IP.
+	LoginThrottle LoginThrottle
+}
+
+// LoginThrottle configures brute-force protection for password logins.
+// Once an account or IP address reaches its failure limit, every further failure doubles
+// the time it is locked out for, starting at BackoffBase and capped at BackoffMax.
+// Failure counts are forgotten after FailureWindow without failures, and an account's
+// count is reset by a successful login.
+type LoginThrottle struct {
+	// MaxAccountFailures is the number of failures allowed for an email address before it is locked out.
+	MaxAccountFailures int
+
+	// MaxIPFailures is the number of failures allowed from an IP address before it is locked out.
+	MaxIPFailures int
+
+	// BackoffBase is the lockout applied when a limit is first reached.
+	BackoffBase time.Duration
+
+	// BackoffMax caps the lockout duration.
+	BackoffMax time.Duration
+
+	// FailureWindow is how long failures are remembered after the most recent one.
+	FailureWindow time.Duration
+}
+
+// Lockout returns how long a key with the given number of consecutive failures stays
+// locked out after its most recent failure, or zero if it is still below the limit.
+func (t LoginThrottle) Lockout(failures, limit int) time.Duration {
+	if failures < limit {
+		return 0
+	}
+
+	lockout := t.BackoffBase
+	for i := limit; i < failures && lockout < t.BackoffMax; i++ {
+		lockout *= 2
+	}
+	if lockout > t.BackoffMax {
+		lockout = t.BackoffMax
+	}
+
+	return lockout
+}
+
+// LoadConfig builds the token configuration from environment variables.
+// The keys are loaded with LoadKeySet; JWT_ISSUER, JWT_AUDIENCE, JWT_CLOCK_SKEW,
+// JWT_ACCESS_TOKEN_TTL, JWT_REFRESH_TOKEN_TTL, EMAIL_VERIFICATION_TTL,
+// REQUIRE_EMAIL_VERIFICATION, EMAIL_VERIFICATION_URL, MAGIC_LINK_TTL, MAGIC_LINK_URL,
+// MFA_CHALLENGE_TTL, REQUIRE_ADMIN_MFA, TOTP_ISSUER and the LOGIN_* throttling settings
+// are optional.
+// It returns an error if no signing key is configured.
+func LoadConfig() (*Config, error) {
+	keys, err := LoadKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		Keys:            keys,
+		Issuer:          stringFromEnv("JWT_ISSUER", defaultIssuer),
+		Audience:        stringFromEnv("JWT_AUDIENCE", defaultAudience),
+		Leeway:          durationFromEnv("JWT_CLOCK_SKEW", defaultClockSkew),
+		AccessTokenTTL:  durationFromEnv("JWT_ACCESS_TOKEN_TTL", defaultAccessTokenTTL),
+		RefreshTokenTTL: durationFromEnv("JWT_REFRESH_TOKEN_TTL", defaultRefreshTokenTTL),
+
+		EmailVerificationTTL: durationFromEnv("EMAIL_VERIFICATION_TTL", defaultEmailVerificationTTL),
+		RequireVerifiedEmail: boolFromEnv("REQUIRE_EMAIL_VERIFICATION", false),
+		EmailVerificationURL: os.Getenv("EMAIL_VERIFICATION_URL"),
+
+		MagicLinkTTL: durationFromEnv("MAGIC_LINK_TTL", defaultMagicLinkTTL),
+		MagicLinkURL: os.Getenv("MAGIC_LINK_URL"),
+
+		MFAChallengeTTL: durationFromEnv("MFA_CHALLENGE_TTL", defaultMFAChallengeTTL),
+		RequireAdminMFA: boolFromEnv("REQUIRE_ADMIN_MFA", false),
+		TOTPIssuer:      stringFromEnv("TOTP_ISSUER", stringFromEnv("JWT_ISSUER", defaultIssuer)),
+
+		LoginThrottle: LoginThrottle{
+			MaxAccountFailures: intFromEnv("LOGIN_MAX_ACCOUNT_FAILURES", defaultLoginMaxAccountFailures),
+			MaxIPFailures:      intFromEnv("LOGIN_MAX_IP_FAILURES", defaultLoginMaxIPFailures),
+			BackoffBase:        durationFromEnv("LOGIN_BACKOFF_BASE", defaultLoginBackoffBase),
+			BackoffMax:         durationFromEnv("LOGIN_BACKOFF_MAX", defaultLoginBackoffMax),
+			FailureWindow:      durationFromEnv("LOGIN_FAILURE_WINDOW", defaultLoginFailureWindow),
+		},
+	}, nil
+}
+
+// parser returns a JWT parser that enforces the configured algorithms, issuer,
+// audience and leeway, and requires the exp claim.
+func (cfg *Config) parser() *jwt.Parser {
+	return jwt.NewParser(
+		jwt.WithValidMethods(cfg.Keys.methods()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+}
+
+// stringFromEnv reads a string from an environment variable,
+// falling back to the default if it is unset.
+func stringFromEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// boolFromEnv parses a boolean from an environment variable,
+// falling back to the default if it is unset or invalid.
+func boolFromEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s %q, using default of %t", key, value, fallback)
+		return fallback
+	}
+
+	return b
+}
+
+// intFromEnv parses a positive integer from an environment variable,
+// falling back to the default if it is unset or invalid.
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Warning: invalid %s %q, using default of %d", key, value, fallback)
+		return fallback
+	}
+
+	return n
+}
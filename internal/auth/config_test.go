@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleLockout(t *testing.T) {
+	throttle := LoginThrottle{BackoffBase: 30 * time.Second, BackoffMax: 15 * time.Minute}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "no failures", failures: 0, want: 0},
+		{name: "below the limit", failures: 4, want: 0},
+		{name: "limit reached", failures: 5, want: 30 * time.Second},
+		{name: "one more failure doubles", failures: 6, want: time.Minute},
+		{name: "keeps doubling", failures: 9, want: 8 * time.Minute},
+		{name: "capped", failures: 10, want: 15 * time.Minute},
+		{name: "stays capped", failures: 100, want: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := throttle.Lockout(tt.failures, 5); got != tt.want {
+				t.Errorf("Lockout(%d, 5) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// googleCertsURL publishes the X.509 certificates whose keys sign Firebase ID tokens.
+const googleCertsURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+// firebaseIssuerPrefix is followed by the project ID in the iss claim of Firebase ID tokens.
+const firebaseIssuerPrefix = "https://securetoken.google.com/"
+
+// defaultKeyCacheTTL is how long Google's keys are cached when the response has no max-age.
+const defaultKeyCacheTTL = time.Hour
+
+// FirebaseKeySource provides the public keys that Firebase ID tokens are signed with, by key ID.
+type FirebaseKeySource interface {
+	PublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error)
+}
+
+// StaticKeySource is a FirebaseKeySource with a fixed set of keys, for tests and offline use.
+type StaticKeySource map[string]*rsa.PublicKey
+
+// PublicKeys returns the fixed set of keys.
+func (s StaticKeySource) PublicKeys(context.Context) (map[string]*rsa.PublicKey, error) {
+	return s, nil
+}
+
+// googleKeySource fetches Google's public certificates and caches them for as long as
+// the response's Cache-Control max-age allows.
+type googleKeySource struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+}
+
+// NewGoogleKeySource creates a FirebaseKeySource that fetches the keys from Google.
+// If client is nil, http.DefaultClient is used.
+func NewGoogleKeySource(client *http.Client) FirebaseKeySource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &googleKeySource{url: googleCertsURL, client: client}
+}
+
+// PublicKeys returns the cached keys, fetching them again once the cache has expired.
+func (s *googleKeySource) PublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keys != nil && time.Now().Before(s.expiresAt) {
+		return s.keys, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Firebase public keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Firebase public keys: unexpected status %s", resp.Status)
+	}
+
+	var certs map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&certs); err != nil {
+		return nil, fmt.Errorf("failed to decode Firebase public keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(certs))
+	for kid, certPEM := range certs {
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in Firebase certificate %q", kid)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Firebase certificate %q: %w", kid, err)
+		}
+
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unexpected key type %T in Firebase certificate %q", cert.PublicKey, kid)
+		}
+		keys[kid] = key
+	}
+
+	s.keys = keys
+	s.expiresAt = time.Now().Add(maxAge(resp.Header.Get("Cache-Control")))
+	return keys, nil
+}
+
+// maxAge returns the max-age directive of a Cache-Control header, or defaultKeyCacheTTL.
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age="); ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return defaultKeyCacheTTL
+}
+
+// FirebaseToken holds the verified contents of a Firebase ID token.
+type FirebaseToken struct {
+	// UID is the Firebase user ID (the sub claim).
+	UID string
+
+	// Email and EmailVerified are the user's email address and whether Firebase has verified it.
+	Email         string
+	EmailVerified bool
+
+	// Name is the user's display name, if Firebase has one.
+	Name string
+
+	// Role is the role from the token's custom claims, or empty if it has none. It is the
+	// role of the user created on first sign-in; after that, the stored role applies.
+	Role role.Role
+
+	// AuthMethods is ["mfa"] if the user signed in with a second factor.
+	AuthMethods []string
+
+	// IssuedAt and ExpiresAt are the token's iat and exp claims.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// firebaseClaims defines the claims of a Firebase ID token that are used by the API.
+// Custom claims set with the Admin SDK appear at the top level of the payload.
+type firebaseClaims struct {
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Name          string    `json:"name"`
+	AuthTime      int64     `json:"auth_time"`
+	Role          role.Role `json:"role"`
+	Firebase      struct {
+		SignInSecondFactor string `json:"sign_in_second_factor"`
+	} `json:"firebase"`
+	jwt.RegisteredClaims
+}
+
+// FirebaseVerifier verifies Firebase ID tokens issued for a Firebase project.
+type FirebaseVerifier struct {
+	projectID string
+	keys      FirebaseKeySource
+	leeway    time.Duration
+}
+
+// NewFirebaseVerifier creates a verifier for ID tokens of the given project, checking
+// signatures against the keys from the key source and tolerating the given clock skew.
+func NewFirebaseVerifier(projectID string, keys FirebaseKeySource, leeway time.Duration) *FirebaseVerifier {
+	return &FirebaseVerifier{projectID: projectID, keys: keys, leeway: leeway}
+}
+
+// LoadFirebaseVerifier creates a verifier using Google's keys if FIREBASE_AUTH_ENABLED is
+// set, for the project in FIREBASE_PROJECT_ID. It returns nil if Firebase Authentication
+// is not enabled, and an error if it is enabled without a project ID.
+func LoadFirebaseVerifier(leeway time.Duration) (*FirebaseVerifier, error) {
+	if !boolFromEnv("FIREBASE_AUTH_ENABLED", false) {
+		return nil, nil
+	}
+
+	projectID := os.Getenv("FIREBASE_PROJECT_ID")
+	if projectID == "" {
+		return nil, errors.New("FIREBASE_PROJECT_ID must be set when FIREBASE_AUTH_ENABLED is true")
+	}
+
+	return NewFirebaseVerifier(projectID, NewGoogleKeySource(nil), leeway), nil
+}
+
+// Verify checks a Firebase ID token's RS256 signature, issuer, audience, expiry, issue
+// time, authentication time and subject, and returns its contents.
+func (v *FirebaseVerifier) Verify(ctx context.Context, tokenString string) (*FirebaseToken, error) {
+	keys, err := v.keys.PublicKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &firebaseClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(firebaseIssuerPrefix+v.projectID),
+		jwt.WithAudience(v.projectID),
+		jwt.WithLeeway(v.leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	_, err = parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown Firebase signing key %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" || len(claims.Subject) > 128 {
+		return nil, errors.New("firebase ID token has an invalid subject")
+	}
+	if claims.IssuedAt == nil {
+		return nil, errors.New("firebase ID token has no iat")
+	}
+	// The user must have signed in before the token was issued; the parser has already
+	// checked that the token was not issued in the future.
+	if claims.AuthTime <= 0 || time.Unix(claims.AuthTime, 0).After(claims.IssuedAt.Add(v.leeway)) {
+		return nil, errors.New("firebase ID token has an invalid auth_time")
+	}
+
+	token := &FirebaseToken{
+		UID:           claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		IssuedAt:      claims.IssuedAt.Time,
+		ExpiresAt:     claims.ExpiresAt.Time,
+	}
+	if claims.Role.IsValid() {
+		token.Role = claims.Role
+	}
+	if claims.Firebase.SignInSecondFactor != "" {
+		token.AuthMethods = []string{MethodMFA}
+	}
+
+	return token, nil
+}
+
+// isFirebaseToken reports whether a token was issued by Firebase Authentication, by
+// looking at its unverified iss claim. The token must still be verified.
+func isFirebaseToken(tokenString string) bool {
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(claims.Issuer, firebaseIssuerPrefix)
+}
+
+// FirebaseUserResolver maps a verified Firebase ID token to the user it belongs to.
+type FirebaseUserResolver interface {
+	ResolveFirebaseUser(ctx context.Context, token *FirebaseToken) (*model.User, error)
+}
+
+// FirebaseAuth enables Firebase ID tokens as an alternative to the API's own access tokens
+// in the AuthMiddleware.
+type FirebaseAuth struct {
+	// Verifier verifies the ID tokens.
+	Verifier *FirebaseVerifier
+
+	// Users maps verified tokens to users.
+	Users FirebaseUserResolver
+}
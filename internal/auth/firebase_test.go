@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+const testFirebaseProject = "test-project"
+
+// testFirebaseKey signs the Firebase ID tokens of the tests. It is generated once because
+// RSA key generation is slow.
+var testFirebaseKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// firebaseTestClaims returns the claims of a valid Firebase ID token for the test project.
+func firebaseTestClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            firebaseIssuerPrefix + testFirebaseProject,
+		"aud":            testFirebaseProject,
+		"sub":            "firebase-uid",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"auth_time":      now.Unix(),
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+// signFirebaseToken signs claims with key, as Firebase would with the key named kid.
+func signFirebaseToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// newTestFirebaseVerifier returns a verifier that trusts testFirebaseKey as key "key-1".
+func newTestFirebaseVerifier() *FirebaseVerifier {
+	keys := StaticKeySource{"key-1": &testFirebaseKey.PublicKey}
+	return NewFirebaseVerifier(testFirebaseProject, keys, 30*time.Second)
+}
+
+func TestFirebaseVerifierVerify(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		kid     string
+		modify  func(jwt.MapClaims)
+		wantErr bool
+	}{
+		{name: "valid token", key: testFirebaseKey, kid: "key-1"},
+		{name: "unknown key ID", key: testFirebaseKey, kid: "key-2", wantErr: true},
+		{name: "signed with another key", key: otherKey, kid: "key-1", wantErr: true},
+		{name: "wrong audience", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["aud"] = "other-project" }, wantErr: true},
+		{name: "wrong issuer", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["iss"] = "https://securetoken.google.com/other-project" }, wantErr: true},
+		{name: "expired", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: true},
+		{name: "missing subject", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+		{name: "auth_time in the future", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["auth_time"] = time.Now().Add(time.Hour).Unix() }, wantErr: true},
+		{name: "auth_time before iat", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["auth_time"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "auth_time after iat within leeway", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["auth_time"] = time.Now().Add(10 * time.Second).Unix() }},
+		{name: "auth_time after iat", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) {
+			c["iat"] = time.Now().Add(-time.Hour).Unix()
+			c["auth_time"] = time.Now().Add(-time.Minute).Unix()
+		}, wantErr: true},
+		{name: "missing auth_time", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { delete(c, "auth_time") }, wantErr: true},
+		{name: "zero auth_time", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { c["auth_time"] = 0 }, wantErr: true},
+		{name: "missing iat", key: testFirebaseKey, kid: "key-1", modify: func(c jwt.MapClaims) { delete(c, "iat") }, wantErr: true},
+	}
+
+	verifier := newTestFirebaseVerifier()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := firebaseTestClaims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			token, err := verifier.Verify(context.Background(), signFirebaseToken(t, tt.key, tt.kid, claims))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token.UID != "firebase-uid" || token.Email != "user@example.com" || !token.EmailVerified {
+				t.Errorf("unexpected token contents: %+v", token)
+			}
+		})
+	}
+}
+
+func TestFirebaseVerifierCustomClaims(t *testing.T) {
+	claims := firebaseTestClaims()
+	claims["role"] = string(role.Admin)
+	claims["firebase"] = map[string]interface{}{"sign_in_second_factor": "phone"}
+
+	token, err := newTestFirebaseVerifier().Verify(context.Background(), signFirebaseToken(t, testFirebaseKey, "key-1", claims))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if token.Role != role.Admin {
+		t.Errorf("Role = %q, want %q", token.Role, role.Admin)
+	}
+	if len(token.AuthMethods) != 1 || token.AuthMethods[0] != MethodMFA {
+		t.Errorf("AuthMethods = %v, want [%s]", token.AuthMethods, MethodMFA)
+	}
+}
+
+// stubFirebaseUsers resolves every Firebase token to the same user.
+type stubFirebaseUsers struct {
+	user *model.User
+}
+
+func (s stubFirebaseUsers) ResolveFirebaseUser(context.Context, *FirebaseToken) (*model.User, error) {
+	return s.user, nil
+}
+
+// stubRevocations reports every token issued before revokedBefore as revoked.
+type stubRevocations struct {
+	revokedBefore time.Time
+}
+
+func (s stubRevocations) IsRevoked(_ context.Context, _, _ string, issuedAt time.Time) (bool, error) {
+	return issuedAt.Before(s.revokedBefore), nil
+}
+
+func TestAuthMiddlewareFirebaseTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := &model.User{ID: "user-1", Email: "user@example.com", Role: role.User, Verified: true}
+	unverified := &model.User{ID: "user-2", Email: "user@example.com", Role: role.User}
+
+	tests := []struct {
+		name          string
+		user          *model.User
+		requireVerify bool
+		revocations   RevocationStore
+		modify        func(jwt.MapClaims)
+		wantStatus    int
+		wantRole      role.Role
+	}{
+		{name: "verified user", user: verified, wantStatus: http.StatusOK, wantRole: role.User},
+		// The stored role applies, so that a user demoted through the API stays demoted.
+		{name: "custom claim does not override the stored role", user: verified, modify: func(c jwt.MapClaims) { c["role"] = string(role.Admin) }, wantStatus: http.StatusOK, wantRole: role.User},
+		{name: "unverified user without requirement", user: unverified, wantStatus: http.StatusOK, wantRole: role.User},
+		{name: "unverified user with requirement", user: unverified, requireVerify: true, wantStatus: http.StatusForbidden},
+		{name: "verified user with requirement", user: verified, requireVerify: true, wantStatus: http.StatusOK, wantRole: role.User},
+		{name: "revoked sessions", user: verified, revocations: stubRevocations{revokedBefore: time.Now().Add(time.Minute)}, wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", user: verified, modify: func(c jwt.MapClaims) { c["aud"] = "other-project" }, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Keys:                 keys,
+				Issuer:               "test-issuer",
+				Audience:             "test-audience",
+				RequireVerifiedEmail: tt.requireVerify,
+				Firebase: &FirebaseAuth{
+					Verifier: newTestFirebaseVerifier(),
+					Users:    stubFirebaseUsers{user: tt.user},
+				},
+			}
+
+			var gotUserID string
+			var gotRole role.Role
+			r := gin.New()
+			r.GET("/", AuthMiddleware(cfg, tt.revocations), func(c *gin.Context) {
+				gotUserID = c.GetString("userID")
+				gotRole = CurrentRole(c)
+				c.Status(http.StatusOK)
+			})
+
+			claims := firebaseTestClaims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+signFirebaseToken(t, testFirebaseKey, "key-1", claims))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if gotUserID != tt.user.ID {
+				t.Errorf("userID = %q, want %q", gotUserID, tt.user.ID)
+			}
+			if gotRole != tt.wantRole {
+				t.Errorf("userRole = %q, want %q", gotRole, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is the JSON Web Key representation of a public verification key (RFC 7517).
+// Only the members needed for RSA and Ed25519 keys are included.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are the modulus and exponent of an RSA key.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are the curve name and public key of an Ed25519 (OKP) key.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served by the /.well-known/jwks.json endpoint.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// verificationKey is a key that tokens may be verified with, bound to a single algorithm.
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// KeySet holds the key used to sign new tokens and every key that tokens may still be
+// verified with. Keeping retired keys in the verification set allows keys to be rotated
+// without invalidating tokens that were signed before the rotation.
+type KeySet struct {
+	signingKeyID  string
+	signingMethod jwt.SigningMethod
+	signingKey    interface{}
+
+	verificationKeys map[string]verificationKey
+	publicKeys       []JWK
+}
+
+// NewKeySet creates a key set that signs tokens with the given RSA or Ed25519 private key.
+// Additional public keys, such as keys being rotated out, are accepted for verification.
+// Key IDs are the RFC 7638 thumbprints of the public keys.
+func NewKeySet(signingKey crypto.Signer, additionalKeys ...crypto.PublicKey) (*KeySet, error) {
+	ks := &KeySet{verificationKeys: make(map[string]verificationKey)}
+
+	kid, method, err := ks.addPublicKey(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	ks.signingKeyID = kid
+	ks.signingMethod = method
+	ks.signingKey = signingKey
+
+	for _, key := range additionalKeys {
+		if _, _, err := ks.addPublicKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+// minHMACSecretLength is the minimum length of an HS256 secret, matching the hash output size.
+const minHMACSecretLength = 32
+
+// NewHMACKeySet creates a key set that signs and verifies tokens with a shared HS256 secret.
+// Such a key set has no public keys, so downstream services cannot verify its tokens.
+func NewHMACKeySet(secret []byte) (*KeySet, error) {
+	if len(secret) < minHMACSecretLength {
+		return nil, fmt.Errorf("HMAC secret must be at least %d bytes long", minHMACSecretLength)
+	}
+
+	return &KeySet{
+		signingMethod: jwt.SigningMethodHS256,
+		signingKey:    secret,
+		verificationKeys: map[string]verificationKey{
+			"": {method: jwt.SigningMethodHS256, key: secret},
+		},
+	}, nil
+}
+
+// LoadKeySet builds the application's key set from environment variables.
+// JWT_SIGNING_KEY_FILE is a PEM-encoded RSA or Ed25519 private key used for signing, and
+// JWT_VERIFICATION_KEY_FILES is an optional comma-separated list of PEM-encoded public keys
+// that are still accepted for verification. If no signing key file is configured, it falls
+// back to HS256 with JWT_SECRET_KEY. It returns an error if neither is configured.
+func LoadKeySet() (*KeySet, error) {
+	signingKeyPath := os.Getenv("JWT_SIGNING_KEY_FILE")
+	if signingKeyPath == "" {
+		secretKey := os.Getenv("JWT_SECRET_KEY")
+		if secretKey == "" {
+			return nil, errors.New("either JWT_SIGNING_KEY_FILE or JWT_SECRET_KEY must be set")
+		}
+
+		log.Println("Warning: JWT_SIGNING_KEY_FILE not set, signing tokens with HS256 and JWT_SECRET_KEY")
+		return NewHMACKeySet([]byte(secretKey))
+	}
+
+	signingKey, err := readPrivateKey(signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var additionalKeys []crypto.PublicKey
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		key, err := readPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		additionalKeys = append(additionalKeys, key)
+	}
+
+	return NewKeySet(signingKey, additionalKeys...)
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set.
+func (ks *KeySet) JWKS() JWKS {
+	keys := make([]JWK, len(ks.publicKeys))
+	copy(keys, ks.publicKeys)
+	return JWKS{Keys: keys}
+}
+
+// methods returns the names of the algorithms that tokens may be signed with.
+func (ks *KeySet) methods() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range ks.verificationKeys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+
+	return algs
+}
+
+// sign signs the claims with the current signing key, setting the kid header.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signingMethod, claims)
+	if ks.signingKeyID != "" {
+		token.Header["kid"] = ks.signingKeyID
+	}
+
+	return token.SignedString(ks.signingKey)
+}
+
+// keyfunc selects the verification key for a token from its kid header, and rejects
+// tokens whose algorithm does not match the one the key is bound to.
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := ks.verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+
+	return key.key, nil
+}
+
+// addPublicKey registers a public key for verification and publishes it in the JWKS.
+// It returns the key's ID and the signing method it is bound to.
+func (ks *KeySet) addPublicKey(key crypto.PublicKey) (string, jwt.SigningMethod, error) {
+	var jwk JWK
+	var method jwt.SigningMethod
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+		jwk = JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+		jwk = JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(k),
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported key type %T: only RSA and Ed25519 keys are supported", key)
+	}
+
+	jwk.KeyID = thumbprint(jwk)
+	jwk.Use = "sig"
+	jwk.Algorithm = method.Alg()
+
+	if _, exists := ks.verificationKeys[jwk.KeyID]; !exists {
+		ks.verificationKeys[jwk.KeyID] = verificationKey{method: method, key: key}
+		ks.publicKeys = append(ks.publicKeys, jwk)
+	}
+
+	return jwk.KeyID, method, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of a public key, used as its key ID.
+// The thumbprint is the SHA-256 hash of the required members in lexicographic order.
+func thumbprint(jwk JWK) string {
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	// Marshalling a struct of strings cannot fail.
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// readPrivateKey reads a PEM-encoded PKCS#8 or PKCS#1 private key from a file.
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T in %s", key, path)
+		}
+		return signer, nil
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// readPublicKey reads a PEM-encoded PKIX public key from a file.
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// readPEM reads the first PEM block from a file.
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// Authentication method references (RFC 8176) recorded in the amr claim of access tokens.
+const (
+	// MethodOTP means the user entered a one-time password or a recovery code.
+	MethodOTP = "otp"
+	// MethodMFA means the user authenticated with more than one factor.
+	MethodMFA = "mfa"
+)
+
+// mfaChallengeAudienceSuffix is appended to the configured audience for MFA challenge
+// tokens, so that a challenge token is never accepted by the AuthMiddleware as an access token.
+const mfaChallengeAudienceSuffix = "/mfa-challenge"
+
+// GenerateMFAChallengeToken creates a short-lived token proving that the user has passed
+// the first login step. It is exchanged for an access token together with a second factor.
+func GenerateMFAChallengeToken(cfg *Config, userID string) (string, error) {
+	now := time.Now()
+
+	tokenID, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &jwt.RegisteredClaims{
+		ID:        tokenID,
+		Subject:   userID,
+		ExpiresAt: jwt.NewNumericDate(now.Add(cfg.MFAChallengeTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    cfg.Issuer,
+		Audience:  jwt.ClaimStrings{cfg.Audience + mfaChallengeAudienceSuffix},
+	}
+
+	return cfg.Keys.sign(claims)
+}
+
+// ParseMFAChallengeToken verifies an MFA challenge token and returns the ID of the user it was issued to.
+func ParseMFAChallengeToken(cfg *Config, tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(cfg.Keys.methods()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience+mfaChallengeAudienceSuffix),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+	)
+
+	if _, err := parser.ParseWithClaims(tokenString, claims, cfg.Keys.keyfunc); err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// HasMFA reports whether the access token of the request (verified by AuthMiddleware)
+// was issued after the user authenticated with a second factor.
+func HasMFA(c *gin.Context) bool {
+	methods, _ := c.Get("authMethods")
+	list, _ := methods.([]string)
+	for _, method := range list {
+		if method == MethodMFA {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MFAPolicyMiddleware creates a gin middleware that enforces cfg.RequireAdminMFA: users
+// whose role includes the admin role are refused unless their token was issued after
+// MFA. Routes used to enroll in MFA must not use it, so that admins can set MFA up.
+// API keys have no second factor and are exempt; creating one is an admin route itself.
+// This middleware should be used *after* the AuthMiddleware.
+func MFAPolicyMiddleware(cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.RequireAdminMFA || HasMFA(c) || IsAPIKey(c) {
+			c.Next()
+			return
+		}
+
+		userRole, apiErr := roleFromContext(c)
+		if apiErr != nil {
+			apierror.Abort(c, apiErr)
+			return
+		}
+
+		if userRole.Includes(role.Admin) {
+			err := apierror.NewForbiddenError("Multi-factor authentication is required for this account").WithCode(apierror.CodeMFARequired)
+			apierror.Abort(c, err)
+			return
+		}
+
+		c.Next()
+	}
+}
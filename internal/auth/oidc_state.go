@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCStateTTL is how long a user has to complete a login at an OIDC provider.
+const OIDCStateTTL = 10 * time.Minute
+
+// oidcStateAudienceSuffix is appended to the configured audience for OIDC state tokens,
+// so that a state token is never accepted by the AuthMiddleware as an access token.
+const oidcStateAudienceSuffix = "/oidc-state"
+
+// OIDCState holds the values generated at the start of an OIDC login that must be
+// checked when the provider redirects back.
+type OIDCState struct {
+	// Provider is the name of the provider the login was started with.
+	Provider string `json:"provider"`
+	// State is echoed back by the provider in the callback, binding it to this browser.
+	State string `json:"state"`
+	// Nonce must appear in the ID token, binding it to this login.
+	Nonce string `json:"nonce"`
+	// Verifier is the PKCE code verifier, sent with the authorization code.
+	Verifier string `json:"verifier"`
+}
+
+// oidcStateClaims defines the claims of an OIDC state token.
+type oidcStateClaims struct {
+	OIDCState
+	jwt.RegisteredClaims
+}
+
+// GenerateOIDCStateToken signs the state of an OIDC login, so that it can be kept in a
+// cookie until the provider redirects back. It expires after OIDCStateTTL.
+func GenerateOIDCStateToken(cfg *Config, state OIDCState) (string, error) {
+	now := time.Now()
+
+	claims := &oidcStateClaims{
+		OIDCState: state,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDCStateTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.Audience + oidcStateAudienceSuffix},
+		},
+	}
+
+	return cfg.Keys.sign(claims)
+}
+
+// ParseOIDCStateToken verifies an OIDC state token and returns the state it holds.
+func ParseOIDCStateToken(cfg *Config, tokenString string) (*OIDCState, error) {
+	claims := &oidcStateClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(cfg.Keys.methods()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience+oidcStateAudienceSuffix),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+	)
+
+	if _, err := parser.ParseWithClaims(tokenString, claims, cfg.Keys.keyfunc); err != nil {
+		return nil, err
+	}
+
+	return &claims.OIDCState, nil
+}
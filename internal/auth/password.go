@@ -0,0 +1,35 @@
+package auth
+
+import "golang.org/x/crypto/bcrypt"
+
+// ErrPasswordTooLong is returned by HashPassword for passwords longer than the 72 bytes
+// that bcrypt accepts.
+var ErrPasswordTooLong = bcrypt.ErrPasswordTooLong
+
+// dummyPasswordHash is a valid bcrypt hash that is compared against when a login
+// is attempted for an unknown account. Performing the comparison anyway keeps the
+// response time similar to that of a known account with a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("go-firebase-api-dummy-password"), bcrypt.DefaultCost)
+
+// HashPassword returns a salted bcrypt hash of the given plaintext password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword reports whether the plaintext password matches the stored bcrypt hash.
+// The comparison is performed in constant time by the bcrypt package.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// CheckDummyPassword performs a throwaway password comparison. It is used when no
+// user matches the supplied credentials so that the failure takes as long as a
+// genuine password mismatch and does not reveal whether the account exists.
+func CheckDummyPassword(password string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
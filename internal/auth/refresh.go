@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+)
+
+// GenerateRandomToken returns a URL-safe string encoding n cryptographically random bytes.
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateRefreshToken creates a new opaque refresh token.
+// It returns the plaintext token for the client and its hash for storage.
+func GenerateRefreshToken() (token, hash string, err error) {
+	token, err = GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of an opaque token.
+// Tokens are high-entropy random values, so a fast unsalted hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// durationFromEnv parses a duration from an environment variable,
+// falling back to the default if it is unset or invalid.
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid %s %q, using default of %s", key, value, fallback)
+		return fallback
+	}
+
+	return d
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, base32-encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 6238 Appendix B lists 8-digit codes; 6-digit codes are their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		step := tt.unix / int64(totpPeriod.Seconds())
+		if got := totpCode(key, step); got != tt.want {
+			t.Errorf("totpCode at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: "050471", wantStep: 37037037, wantOK: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "050471", wantStep: 37037037, wantOK: true},
+		{name: "previous step within skew", secret: rfc6238Secret, code: "081804", wantStep: 37037036, wantOK: true},
+		{name: "wrong code", secret: rfc6238Secret, code: "123456"},
+		{name: "code of another time", secret: rfc6238Secret, code: "287082"},
+		{name: "wrong length", secret: rfc6238Secret, code: "50471"},
+		{name: "invalid secret", secret: "not base32!", code: "050471"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP = %d, %t, want %d, %t", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -2,7 +2,8 @@ package config
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
 	"os"
 
 	"cloud.google.com/go/firestore"
@@ -11,32 +12,56 @@ import (
 )
 
 // InitializeFirebase sets up the connection to Google Firestore and returns a client instance.
-// It relies on the FIREBASE_SERVICE_ACCOUNT_KEY_PATH environment variable for the credentials file path.
-// The application will exit with a fatal error if initialization fails at any step.
-func InitializeFirebase() *firestore.Client {
+//
+// When the FIRESTORE_EMULATOR_HOST environment variable is set, the client connects to the
+// Firestore emulator at that address without credentials, in the project named by
+// FIREBASE_PROJECT_ID. Otherwise it relies on FIREBASE_SERVICE_ACCOUNT_KEY_PATH for the
+// credentials file path, and FIREBASE_PROJECT_ID, if set, overrides the project of the key.
+// It returns an error if the configuration is incomplete or initialization fails.
+func InitializeFirebase() (*firestore.Client, error) {
 	ctx := context.Background()
+	projectID := os.Getenv("FIREBASE_PROJECT_ID")
+
+	// The Firestore client connects to the emulator by itself when the variable is set.
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") != "" {
+		if projectID == "" {
+			return nil, errors.New("FIREBASE_PROJECT_ID environment variable must be set to use the Firestore emulator")
+		}
+
+		client, err := firestore.NewClient(ctx, projectID)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing Firestore emulator client: %w", err)
+		}
+		return client, nil
+	}
 
 	// Get the service account key file path from an environment variable.
 	serviceAccountKeyPath := os.Getenv("FIREBASE_SERVICE_ACCOUNT_KEY_PATH")
 
 	// Ensure the environment variable is set.
 	if serviceAccountKeyPath == "" {
-		log.Fatalf("FIREBASE_SERVICE_ACCOUNT_KEY_PATH environment variable not set.")
+		return nil, errors.New("FIREBASE_SERVICE_ACCOUNT_KEY_PATH environment variable not set; set FIRESTORE_EMULATOR_HOST instead to use the emulator")
+	}
+
+	// An empty project ID makes Firebase take it from the credentials file.
+	var appConfig *firebase.Config
+	if projectID != "" {
+		appConfig = &firebase.Config{ProjectID: projectID}
 	}
 
 	// Create a client option with the credentials file.
 	opt := option.WithCredentialsFile(serviceAccountKeyPath)
 	// Initialize the Firebase app.
-	app, err := firebase.NewApp(ctx, nil, opt)
+	app, err := firebase.NewApp(ctx, appConfig, opt)
 	if err != nil {
-		log.Fatalf("error initializing app: %v\n", err)
+		return nil, fmt.Errorf("error initializing app: %w", err)
 	}
 
 	// Get a Firestore client from the initialized app.
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		log.Fatalf("error initializing Firestore client: %v\n", err)
+		return nil, fmt.Errorf("error initializing Firestore client: %w", err)
 	}
 
-	return client
+	return client, nil
 }
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/service"
+)
+
+// APIKeyHandler handles HTTP requests for managing API keys.
+type APIKeyHandler struct {
+	apiKeyService service.APIKeyService
+	validate      *Validator
+}
+
+// NewAPIKeyHandler creates a new instance of APIKeyHandler.
+func NewAPIKeyHandler(svc service.APIKeyService, val *Validator) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: svc,
+		validate:      val,
+	}
+}
+
+// CreateAPIKey handles the POST /admin/api-keys endpoint.
+// It creates a named, scoped, expiring API key and returns the plaintext key, which is
+// never shown again.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req model.APIKeyCreate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	// Validate the request struct based on the defined tags.
+	if err := h.validate.Struct(req); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	// The acting admin's ID and role are set by AuthMiddleware.
+	key, err := h.apiKeyService.CreateAPIKey(c.Request.Context(), c.GetString("userID"), auth.CurrentRole(c), req)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListAPIKeys handles the GET /admin/api-keys endpoint.
+// It returns every API key with its last use, without the keys themselves.
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyService.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey handles the DELETE /admin/api-keys/:id endpoint.
+// The key stays listed, with the time it was revoked and by whom.
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	key, err := h.apiKeyService.RevokeAPIKey(c.Request.Context(), c.GetString("userID"), c.Param("id"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
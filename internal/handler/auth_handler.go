@@ -1,54 +1,266 @@
 package handler
 
 import (
-	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
 	"github.com/hermantrym/go-firebase-api/internal/service"
 	"net/http"
 )
 
 // AuthHandler handles HTTP requests related to authentication.
 type AuthHandler struct {
-	userService service.UserService
+	authService service.AuthService
+	keys        *auth.KeySet
 }
 
 // NewAuthHandler creates a new instance of AuthHandler.
-func NewAuthHandler(svc service.UserService) *AuthHandler {
-	return &AuthHandler{userService: svc}
+// The key set is used to publish the public verification keys.
+func NewAuthHandler(svc service.AuthService, keys *auth.KeySet) *AuthHandler {
+	return &AuthHandler{
+		authService: svc,
+		keys:        keys,
+	}
 }
 
 // LoginRequest defines the expected JSON request body for the login endpoint.
 type LoginRequest struct {
 	// Email is the user's email address, required for login.
-	Email string `json: "email" binding:"required,email"`
+	Email string `json:"email" binding:"required,email"`
+	// Password is the user's plaintext password, required for login.
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest defines the expected JSON request body for the token refresh endpoint.
+type RefreshRequest struct {
+	// RefreshToken is the refresh token returned by the last login or refresh.
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest defines the optional JSON request body for the logout endpoint.
+type LogoutRequest struct {
+	// RefreshToken, if supplied, is revoked together with the access token.
+	RefreshToken string `json:"refresh_token"`
+}
+
+// VerifyEmailRequest defines the expected JSON request body for the email verification endpoint.
+type VerifyEmailRequest struct {
+	// Token is the verification token from the email.
+	Token string `json:"token" binding:"required"`
+}
+
+// ResendVerificationRequest defines the expected JSON request body for resending a verification email.
+type ResendVerificationRequest struct {
+	// Email is the address to send the verification email to.
+	Email string `json:"email" binding:"required,email"`
+}
+
+// MagicLinkRequest defines the expected JSON request body for requesting a login link.
+type MagicLinkRequest struct {
+	// Email is the address to send the login link to.
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ConsumeMagicLinkRequest defines the expected JSON request body for logging in with a login link.
+type ConsumeMagicLinkRequest struct {
+	// Token is the token from the login link.
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyMFARequest defines the expected JSON request body for the second login step.
+type VerifyMFARequest struct {
+	// MFAToken is the challenge token returned by the first login step.
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a TOTP code from the user's authenticator app, or one of their recovery codes.
+	Code string `json:"code" binding:"required"`
 }
 
 // Login handles the user login request. It validates the request body,
-// calls the user service to generate a JWT, and returns the token upon success.
+// calls the auth service to verify the credentials and issue tokens,
+// and returns the token pair upon success.
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
 	// Bind and validate the incoming JSON payload.
 	if err := c.ShouldBindJSON(&req); err != nil {
-		apiErr := apierror.NewBadRequestError("Invalid request body: email is required and must be valid")
-		c.JSON(apiErr.Code, apiErr)
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: a valid email and a password are required").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	// Call the service to perform the login logic and generate the tokens.
+	tokens, challenge, err := h.authService.LoginUser(c.Request.Context(), req.Email, req.Password, c.ClientIP())
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	// Users with MFA enabled must complete the login with POST /auth/mfa/verify.
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	// Return the tokens in the response.
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh handles the POST /auth/refresh endpoint. It exchanges a refresh token
+// for a new access token and a new refresh token, invalidating the old one.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: refresh_token is required").WithCode(apierror.CodeInvalidJSON))
 		return
 	}
 
-	// Call the service to perform the login logic and generate a token.
-	token, err := h.userService.LoginUser(c.Request.Context(), req.Email)
+	tokens, err := h.authService.RefreshToken(c.Request.Context(), req.RefreshToken)
 	if err != nil {
-		var apiErr *apierror.APIError
-		// Check if the error is a custom APIError (e.g., NotFoundError) for a specific response.
-		if errors.As(err, &apiErr) {
-			c.JSON(apiErr.Code, apiErr)
-		} else {
-			// Fallback for unexpected errors.
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout handles the POST /logout endpoint. It revokes the access token used for the
+// request and, if supplied in the body, the refresh token issued with it.
+// This handler must be used *after* the AuthMiddleware.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	// The body is optional, so only reject it if it is present and malformed.
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+			return
 		}
+	}
+
+	session := model.Session{
+		TokenID:   c.GetString("tokenID"),
+		UserID:    c.GetString("userID"),
+		ExpiresAt: c.GetTime("tokenExpiresAt"),
+	}
+
+	if err := h.authService.Logout(c.Request.Context(), session, req.RefreshToken); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeUserSessions handles the DELETE /admin/users/:id/sessions endpoint.
+// It allows an administrator to revoke every access and refresh token issued to a user.
+func (h *AuthHandler) RevokeUserSessions(c *gin.Context) {
+	if err := h.authService.RevokeUserSessions(c.Request.Context(), c.Param("id")); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// JWKS handles the GET /.well-known/jwks.json endpoint. It publishes the public keys
+// that access tokens can be verified with, so that other services can validate
+// tokens without access to the signing key.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	// Allow downstream services to cache the key set, while still picking up rotations quickly.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
+
+// VerifyEmail handles the POST /auth/verify-email endpoint.
+// It consumes a verification token and returns the user with their address verified.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: token is required").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	user, err := h.authService.VerifyEmail(c.Request.Context(), req.Token)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ResendVerificationEmail handles the POST /auth/verify-email/resend endpoint.
+// It always responds with 202 Accepted, whether or not the address is registered,
+// so that the endpoint cannot be used to discover accounts.
+func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: email is required and must be valid").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	if err := h.authService.ResendVerificationEmail(c.Request.Context(), req.Email); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an unverified account, a verification email has been sent"})
+}
+
+// RequestMagicLink handles the POST /auth/magic-link endpoint.
+// It always responds with 202 Accepted, whether or not the address is registered,
+// so that the endpoint cannot be used to discover accounts.
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: email is required and must be valid").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	if err := h.authService.RequestMagicLink(c.Request.Context(), req.Email); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an account, a login link has been sent"})
+}
+
+// ConsumeMagicLink handles the POST /auth/magic-link/consume endpoint.
+// It exchanges a login link token for an access token and a refresh token.
+func (h *AuthHandler) ConsumeMagicLink(c *gin.Context) {
+	var req ConsumeMagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: token is required").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	tokens, challenge, err := h.authService.ConsumeMagicLink(c.Request.Context(), req.Token)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// VerifyMFA handles the POST /auth/mfa/verify endpoint.
+// It completes a login for a user with MFA enabled, exchanging the challenge token and a
+// TOTP or recovery code for an access token and a refresh token.
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: mfa_token and code are required").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	tokens, err := h.authService.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		respondWithError(c, err)
 		return
 	}
 
-	// Return the token in the response.
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, tokens)
 }
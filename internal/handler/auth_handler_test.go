@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/service"
+)
+
+// stubAuthService returns the configured tokens, challenge and error from every login
+// method, and records the arguments it was called with. Its other methods are not used.
+type stubAuthService struct {
+	service.AuthService
+	tokens    *model.TokenPair
+	challenge *model.MFAChallenge
+	err       error
+
+	email        string
+	password     string
+	clientIP     string
+	refreshToken string
+	session      model.Session
+	revokedUsers []string
+}
+
+func (s *stubAuthService) LoginUser(_ context.Context, email, password, clientIP string) (*model.TokenPair, *model.MFAChallenge, error) {
+	s.email, s.password, s.clientIP = email, password, clientIP
+	return s.tokens, s.challenge, s.err
+}
+
+func (s *stubAuthService) VerifyMFA(_ context.Context, _, code, clientIP string) (*model.TokenPair, error) {
+	s.password, s.clientIP = code, clientIP
+	return s.tokens, s.err
+}
+
+func (s *stubAuthService) RefreshToken(_ context.Context, refreshToken string) (*model.TokenPair, error) {
+	s.refreshToken = refreshToken
+	return s.tokens, s.err
+}
+
+func (s *stubAuthService) Logout(_ context.Context, session model.Session, refreshToken string) error {
+	s.session, s.refreshToken = session, refreshToken
+	return s.err
+}
+
+func (s *stubAuthService) RevokeUserSessions(_ context.Context, userID string) error {
+	s.revokedUsers = append(s.revokedUsers, userID)
+	return s.err
+}
+
+func (s *stubAuthService) SendVerificationEmail(context.Context, *model.User) error {
+	return nil
+}
+
+func (s *stubAuthService) ResendVerificationEmail(_ context.Context, email string) error {
+	s.email = email
+	return s.err
+}
+
+func (s *stubAuthService) RequestMagicLink(_ context.Context, email string) error {
+	s.email = email
+	return s.err
+}
+
+func TestAuthHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeySet(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := &model.TokenPair{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", ExpiresIn: 900}
+	challenge := &model.MFAChallenge{MFARequired: true, MFAToken: "challenge", ExpiresIn: 300}
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		stub       stubAuthService
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantBody   []string
+		check      func(t *testing.T, stub *stubAuthService)
+	}{
+		{
+			name: "login", stub: stubAuthService{tokens: tokens},
+			method: http.MethodPost, path: "/auth/login", body: `{"email":"ada@example.com","password":"correct horse"}`,
+			wantStatus: http.StatusOK, wantBody: []string{`"access_token":"access"`, `"refresh_token":"refresh"`},
+			check: func(t *testing.T, stub *stubAuthService) {
+				if stub.email != "ada@example.com" || stub.password != "correct horse" || stub.clientIP != "192.0.2.1" {
+					t.Errorf("LoginUser called with %q, %q, %q", stub.email, stub.password, stub.clientIP)
+				}
+			},
+		},
+		{
+			name: "login with MFA", stub: stubAuthService{challenge: challenge},
+			method: http.MethodPost, path: "/auth/login", body: `{"email":"ada@example.com","password":"correct horse"}`,
+			wantStatus: http.StatusOK, wantBody: []string{`"mfa_required":true`, `"mfa_token":"challenge"`},
+		},
+		{
+			name:   "login without password",
+			method: http.MethodPost, path: "/auth/login", body: `{"email":"ada@example.com"}`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidJSON,
+		},
+		{
+			name: "login with wrong credentials", stub: stubAuthService{err: apierror.NewUnauthorizedError("Invalid email or password").WithCode(apierror.CodeInvalidCredentials)},
+			method: http.MethodPost, path: "/auth/login", body: `{"email":"ada@example.com","password":"wrong"}`,
+			wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidCredentials,
+		},
+		{
+			name: "login locked out", stub: stubAuthService{err: apierror.NewTooManyRequestsError("Too many failed login attempts", time.Minute).WithCode(apierror.CodeTooManyLoginAttempts)},
+			method: http.MethodPost, path: "/auth/login", body: `{"email":"ada@example.com","password":"wrong"}`,
+			wantStatus: http.StatusTooManyRequests, wantCode: apierror.CodeTooManyLoginAttempts,
+		},
+		{
+			name: "MFA verification", stub: stubAuthService{tokens: tokens},
+			method: http.MethodPost, path: "/auth/mfa/verify", body: `{"mfa_token":"challenge","code":"123456"}`,
+			wantStatus: http.StatusOK, wantBody: []string{`"access_token":"access"`},
+		},
+		{
+			name:   "MFA verification without code",
+			method: http.MethodPost, path: "/auth/mfa/verify", body: `{"mfa_token":"challenge"}`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidJSON,
+		},
+		{
+			name: "refresh", stub: stubAuthService{tokens: tokens},
+			method: http.MethodPost, path: "/auth/refresh", body: `{"refresh_token":"old"}`,
+			wantStatus: http.StatusOK, wantBody: []string{`"refresh_token":"refresh"`},
+			check: func(t *testing.T, stub *stubAuthService) {
+				if stub.refreshToken != "old" {
+					t.Errorf("RefreshToken called with %q", stub.refreshToken)
+				}
+			},
+		},
+		{
+			name:   "refresh without token",
+			method: http.MethodPost, path: "/auth/refresh", body: `{}`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidJSON,
+		},
+		{
+			name: "refresh with a reused token", stub: stubAuthService{err: apierror.NewUnauthorizedError("Invalid or expired refresh token").WithCode(apierror.CodeInvalidToken)},
+			method: http.MethodPost, path: "/auth/refresh", body: `{"refresh_token":"old"}`,
+			wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidToken,
+		},
+		{
+			name:   "logout without body",
+			method: http.MethodPost, path: "/logout",
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, stub *stubAuthService) {
+				want := model.Session{TokenID: "token-1", UserID: "user-1", ExpiresAt: expiresAt}
+				if stub.session != want || stub.refreshToken != "" {
+					t.Errorf("Logout called with %+v, %q, want %+v", stub.session, stub.refreshToken, want)
+				}
+			},
+		},
+		{
+			name:   "logout with refresh token",
+			method: http.MethodPost, path: "/logout", body: `{"refresh_token":"refresh"}`,
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, stub *stubAuthService) {
+				if stub.refreshToken != "refresh" {
+					t.Errorf("Logout called with refresh token %q", stub.refreshToken)
+				}
+			},
+		},
+		{
+			name:   "logout with malformed body",
+			method: http.MethodPost, path: "/logout", body: `{`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidJSON,
+		},
+		{
+			name:   "revoke sessions",
+			method: http.MethodDelete, path: "/admin/users/user-2/sessions",
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, stub *stubAuthService) {
+				if len(stub.revokedUsers) != 1 || stub.revokedUsers[0] != "user-2" {
+					t.Errorf("revoked users = %v, want [user-2]", stub.revokedUsers)
+				}
+			},
+		},
+		{
+			name:   "resend verification",
+			method: http.MethodPost, path: "/auth/verify-email/resend", body: `{"email":"ada@example.com"}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:   "resend verification with invalid email",
+			method: http.MethodPost, path: "/auth/verify-email/resend", body: `{"email":"not-an-email"}`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidJSON,
+		},
+		{
+			name:   "request magic link",
+			method: http.MethodPost, path: "/auth/magic-link", body: `{"email":"ada@example.com"}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:   "JWKS",
+			method: http.MethodGet, path: "/.well-known/jwks.json",
+			wantStatus: http.StatusOK, wantBody: []string{`"keys":[{`, `"kty":"OKP"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := tt.stub
+			h := NewAuthHandler(&stub, keys)
+
+			// Stands in for AuthMiddleware on the authenticated routes.
+			session := func(c *gin.Context) {
+				c.Set("userID", "user-1")
+				c.Set("tokenID", "token-1")
+				c.Set("tokenExpiresAt", expiresAt)
+			}
+
+			r := gin.New()
+			r.POST("/auth/login", h.Login)
+			r.POST("/auth/mfa/verify", h.VerifyMFA)
+			r.POST("/auth/refresh", h.Refresh)
+			r.POST("/auth/verify-email/resend", h.ResendVerificationEmail)
+			r.POST("/auth/magic-link", h.RequestMagicLink)
+			r.GET("/.well-known/jwks.json", h.JWKS)
+			r.POST("/logout", session, h.Logout)
+			r.DELETE("/admin/users/:id/sessions", session, h.RevokeUserSessions)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				assertProblemCode(t, w, tt.wantCode)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body %s does not contain %s", w.Body.String(), want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, &stub)
+			}
+		})
+	}
+}
+
+// assertProblemCode fails the test if the response is not a problem with the given code.
+func assertProblemCode(t *testing.T, w *httptest.ResponseRecorder, want string) {
+	t.Helper()
+
+	var problem struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("invalid problem body %q: %v", w.Body.String(), err)
+	}
+	if problem.Code != want {
+		t.Errorf("code = %q, want %q", problem.Code, want)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/service"
+)
+
+// MFAHandler handles HTTP requests for managing the authenticated user's two-factor authentication.
+type MFAHandler struct {
+	mfaService service.MFAService
+}
+
+// NewMFAHandler creates a new instance of MFAHandler.
+func NewMFAHandler(svc service.MFAService) *MFAHandler {
+	return &MFAHandler{mfaService: svc}
+}
+
+// MFACodeRequest defines the expected JSON request body for endpoints that need a verification code.
+type MFACodeRequest struct {
+	// Code is a TOTP code from the user's authenticator app, or (where accepted) a recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+// EnrollTOTP handles the POST /auth/mfa/totp endpoint.
+// It returns a new TOTP secret and its otpauth:// URI for the user to add to an authenticator app.
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	enrollment, err := h.mfaService.EnrollTOTP(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP handles the POST /auth/mfa/totp/confirm endpoint.
+// It enables MFA if the code matches the pending secret and returns the recovery codes.
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: code is required").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmTOTP(c.Request.Context(), c.GetString("userID"), req.Code, c.ClientIP())
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// DisableMFA handles the DELETE /auth/mfa endpoint.
+// It turns MFA off after checking a current TOTP code or a recovery code.
+func (h *MFAHandler) DisableMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid request body: code is required").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	if err := h.mfaService.DisableMFA(c.Request.Context(), c.GetString("userID"), req.Code, c.ClientIP()); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/service"
+)
+
+// oidcStateCookie is the cookie that holds the state token between the start of an OIDC
+// login and the provider's redirect back.
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler handles HTTP requests for logging in through OpenID Connect providers.
+type OIDCHandler struct {
+	oidcService service.OIDCService
+}
+
+// NewOIDCHandler creates a new instance of OIDCHandler.
+func NewOIDCHandler(svc service.OIDCService) *OIDCHandler {
+	return &OIDCHandler{oidcService: svc}
+}
+
+// Start handles the GET /auth/:provider/start endpoint.
+// It stores the login state in a cookie and redirects the browser to the provider.
+func (h *OIDCHandler) Start(c *gin.Context) {
+	provider := c.Param("provider")
+
+	login, err := h.oidcService.StartLogin(c.Request.Context(), provider)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	// The cookie is only sent back to this provider's callback. SameSite=Lax allows it on
+	// the top-level redirect from the provider.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, login.StateToken, int(auth.OIDCStateTTL.Seconds()), "/auth/"+provider, "", true, true)
+	c.Redirect(http.StatusFound, login.AuthURL)
+}
+
+// Callback handles the GET /auth/:provider/callback endpoint.
+// It completes the login and returns a token pair, or an MFA challenge for users with MFA enabled.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+
+	// The state cookie is single-use, whatever the outcome.
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/"+provider, "", true, true)
+
+	if c.Query("error") != "" {
+		respondWithError(c, apierror.NewUnauthorizedError("Login was cancelled or rejected by the provider"))
+		return
+	}
+
+	tokens, challenge, err := h.oidcService.CompleteLogin(c.Request.Context(), provider, c.Query("code"), c.Query("state"), stateToken)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
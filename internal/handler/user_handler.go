@@ -1,9 +1,8 @@
 package handler
 
 import (
-	"errors"
-	"github.com/go-playground/validator/v10"
 	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -14,11 +13,11 @@ import (
 // UserHandler handles HTTP requests related to users.
 type UserHandler struct {
 	userService service.UserService
-	validate    *validator.Validate
+	validate    *Validator
 }
 
 // NewUserHandler creates a new instance of UserHandler.
-func NewUserHandler(svc service.UserService, val *validator.Validate) *UserHandler {
+func NewUserHandler(svc service.UserService, val *Validator) *UserHandler {
 	return &UserHandler{
 		userService: svc,
 		validate:    val,
@@ -33,28 +32,20 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 
 	// Bind the incoming JSON payload to the user struct.
 	if err := c.ShouldBindJSON(&user); err != nil {
-		apiErr := apierror.NewBadRequestError("Invalid JSON format")
-		c.JSON(apiErr.Code, apiErr)
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
 		return
 	}
 
 	// Validate the user struct based on the defined tags.
 	if err := h.validate.Struct(user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"errors": formatValidationErrors(err)})
+		respondWithError(c, h.validate.validationError(c, err))
 		return
 	}
 
 	// Call the service to register the user.
 	createdUser, err := h.userService.RegisterUser(c.Request.Context(), user)
 	if err != nil {
-		var apiErr *apierror.APIError
-		// Check if the error is a custom APIError for specific HTTP responses.
-		if errors.As(err, &apiErr) {
-			c.JSON(apiErr.Code, apiErr)
-		} else {
-			// Fallback for unexpected errors.
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
-		}
+		respondWithError(c, err)
 		return
 	}
 
@@ -63,30 +54,26 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 
 // AdminCreateUser handles the POST /admin/users endpoint.
 // This allows an administrator to create a new user, potentially with a specific role.
-// It validates the incoming user data before creation.
+// It validates the incoming user data before creation. Roles other than "user" require
+// the roles:assign permission.
 func (h *UserHandler) AdminCreateUser(c *gin.Context) {
 	var user model.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		apiErr := apierror.NewBadRequestError("Invalid JSON format")
-		c.JSON(apiErr.Code, apiErr)
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
 		return
 	}
 
 	// Validate the user struct based on the defined tags.
 	if err := h.validate.Struct(user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"errors": formatValidationErrors(err)})
+		respondWithError(c, h.validate.validationError(c, err))
 		return
 	}
 
-	// Call the service to register the user.
-	createdUser, err := h.userService.AdminRegisterUser(c.Request.Context(), user)
+	// The acting admin's ID and role are set by AuthMiddleware. The role decides which
+	// roles they may assign, and the ID is recorded in the audit log.
+	createdUser, err := h.userService.AdminRegisterUser(c.Request.Context(), c.GetString("userID"), auth.CurrentRole(c), user)
 	if err != nil {
-		var apiErr *apierror.APIError
-		if errors.As(err, &apiErr) {
-			c.JSON(apiErr.Code, apiErr)
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
-		}
+		respondWithError(c, err)
 		return
 	}
 
@@ -95,21 +82,12 @@ func (h *UserHandler) AdminCreateUser(c *gin.Context) {
 
 // GetUser handles the GET /users/:id endpoint.
 // It retrieves a user by the ID provided in the URL path.
+// Access is restricted to the owner or a permitted role by SelfOrPermissionMiddleware on the route.
 func (h *UserHandler) GetUser(c *gin.Context) {
 	userID := c.Param("id")
 	user, err := h.userService.FindUserByID(c.Request.Context(), userID)
-
 	if err != nil {
-		var apiErr *apierror.APIError
-		// Check if the error is a custom APIError (e.g., NotFoundError).
-		if errors.As(err, &apiErr) {
-			c.JSON(apiErr.Code, apiErr)
-		} else {
-			// Fallback for unexpected errors.
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "An unexpected error occurred",
-			})
-		}
+		respondWithError(c, err)
 		return
 	}
 
@@ -117,31 +95,210 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 }
 
 // GetAllUsers handles the GET /admin/users endpoint.
-// It retrieves a list of all users in the system.
+// It retrieves one page of users, supporting the limit, cursor, role, email and sort
+// query parameters, and returns them with the cursor for the next page.
 func (h *UserHandler) GetAllUsers(c *gin.Context) {
-	users, err := h.userService.FindAllUsers(c.Request.Context())
+	var query model.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid query parameters"))
+		return
+	}
+
+	// Validate the query struct based on the defined tags.
+	if err := h.validate.Struct(query); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	page, err := h.userService.FindAllUsers(c.Request.Context(), query)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// UpdateUser handles the PUT /users/:id endpoint.
+// It allows the account owner to replace their profile: name and email, which are required,
+// and display name, avatar URL, phone, locale, timezone and metadata, which are cleared when
+// omitted. Metadata holds at most 50 keys of 1 to 64 letters, digits, '_' or '-', with string
+// values of at most 1024 bytes, numbers, booleans or null. Any role in the body is ignored.
+// Access is restricted to the owner or a permitted role by SelfOrPermissionMiddleware on the route.
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	var update model.UserUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	// Validate the update struct based on the defined tags.
+	if err := h.validate.Struct(update); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	user, err := h.userService.UpdateUser(c.Request.Context(), c.Param("id"), update)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminUpdateUser handles the PUT /admin/users/:id endpoint.
+// It allows an administrator to replace a user's profile, with the same fields and metadata
+// validation as UpdateUser. A role that differs from the user's current one is rejected.
+func (h *UserHandler) AdminUpdateUser(c *gin.Context) {
+	var update model.UserUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	// Validate the update struct based on the defined tags.
+	if err := h.validate.Struct(update); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	user, err := h.userService.AdminUpdateUser(c.Request.Context(), c.Param("id"), update)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// PatchUser handles the PATCH /users/:id endpoint.
+// It allows the account owner to update only the supplied fields. Any role in the body is ignored.
+// Access is restricted to the owner or a permitted role by SelfOrPermissionMiddleware on the route.
+func (h *UserHandler) PatchUser(c *gin.Context) {
+	var patch model.UserPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	// Validate only the fields that were supplied.
+	if err := h.validate.Struct(patch); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	user, err := h.userService.PatchUser(c.Request.Context(), c.Param("id"), patch)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminPatchUser handles the PATCH /admin/users/:id endpoint.
+// It allows an administrator to update only the supplied fields of any user.
+func (h *UserHandler) AdminPatchUser(c *gin.Context) {
+	var patch model.UserPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+		return
+	}
+
+	// Validate only the fields that were supplied.
+	if err := h.validate.Struct(patch); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	user, err := h.userService.AdminPatchUser(c.Request.Context(), c.Param("id"), patch)
 	if err != nil {
-		var apiErr *apierror.APIError
-		if errors.As(err, &apiErr) {
-			c.JSON(apiErr.Code, apiErr)
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
-		}
+		respondWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser handles the DELETE /users/:id endpoint.
+// It allows the account owner to delete their own account, which is purged after the
+// retention period unless an administrator restores it.
+// Access is restricted to the owner or a permitted role by SelfOrPermissionMiddleware on the route.
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	if err := h.userService.DeleteUser(c.Request.Context(), c.Param("id")); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
-// formatValidationErrors transforms validation errors from the validator library
-// into a more readable map[string]string format for client consumption.
-func formatValidationErrors(err error) map[string]string {
-	errorsMap := make(map[string]string)
+// AdminDeleteUser handles the DELETE /admin/users/:id endpoint.
+// It allows an administrator to delete any user.
+func (h *UserHandler) AdminDeleteUser(c *gin.Context) {
+	if err := h.userService.DeleteUser(c.Request.Context(), c.Param("id")); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
 
-	// Type assert the error to access the slice of validation errors.
-	for _, fieldErr := range err.(validator.ValidationErrors) {
-		errorsMap[fieldErr.Field()] = "Field validation for '" + fieldErr.Field() + "' failed on the '" + fieldErr.Tag() + "' tag"
+// ChangeUserRole handles the PUT /admin/users/:id/role endpoint.
+// It allows an administrator to promote or demote a user. The change is audited
+// and the user's outstanding tokens are revoked.
+func (h *UserHandler) ChangeUserRole(c *gin.Context) {
+	var update model.RoleUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		respondWithError(c, apierror.NewBadRequestError("Invalid JSON format").WithCode(apierror.CodeInvalidJSON))
+		return
 	}
 
-	return errorsMap
+	// Validate the update struct based on the defined tags.
+	if err := h.validate.Struct(update); err != nil {
+		respondWithError(c, h.validate.validationError(c, err))
+		return
+	}
+
+	// The acting admin's ID is set by AuthMiddleware and recorded in the audit log.
+	user, err := h.userService.ChangeUserRole(c.Request.Context(), c.GetString("userID"), c.Param("id"), update.Role)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// SuspendUser handles the POST /admin/users/:id/suspend endpoint.
+// It allows an administrator to block an active user until they are restored. The change
+// is audited and the user's outstanding tokens are revoked.
+func (h *UserHandler) SuspendUser(c *gin.Context) {
+	user, err := h.userService.SuspendUser(c.Request.Context(), c.GetString("userID"), c.Param("id"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// RestoreUser handles the POST /admin/users/:id/restore endpoint.
+// It allows an administrator to reactivate a suspended user, or a deleted user who has
+// not been purged yet. The change is audited.
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	user, err := h.userService.RestoreUser(c.Request.Context(), c.GetString("userID"), c.Param("id"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// respondWithError writes an error response in the API's problem details format
+// (see apierror.Respond).
+func respondWithError(c *gin.Context, err error) {
+	apierror.Respond(c, err)
 }
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+	"github.com/hermantrym/go-firebase-api/internal/service"
+)
+
+func TestUserHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Paths and bodies refer to the seeded users as {admin} and {user}.
+	tests := []struct {
+		name       string
+		actor      string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantFields map[string]interface{}
+	}{
+		{
+			name: "register", method: http.MethodPost, path: "/users",
+			body:       `{"name":"Ada","email":"Ada@Example.com","password":"correct horse","role":"admin"}`,
+			wantStatus: http.StatusCreated,
+			wantFields: map[string]interface{}{"email": "ada@example.com", "role": "user", "verified": false, "status": "active", "password": nil},
+		},
+		{
+			name: "register with malformed JSON", method: http.MethodPost, path: "/users",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidJSON,
+		},
+		{
+			name: "register with invalid fields", method: http.MethodPost, path: "/users",
+			body:       `{"name":"A","email":"not-an-email","password":"short"}`,
+			wantStatus: http.StatusUnprocessableEntity, wantCode: apierror.CodeValidationFailed,
+		},
+		{
+			name: "register with a taken email", method: http.MethodPost, path: "/users",
+			body:       `{"name":"Other","email":"user@example.com","password":"correct horse"}`,
+			wantStatus: http.StatusConflict, wantCode: apierror.CodeEmailTaken,
+		},
+		{
+			name: "admin creates an admin", actor: "admin", method: http.MethodPost, path: "/admin/users",
+			body:       `{"name":"Grace","email":"grace@example.com","password":"correct horse","role":"admin"}`,
+			wantStatus: http.StatusCreated,
+			wantFields: map[string]interface{}{"role": "admin", "verified": true},
+		},
+		{
+			name: "get user", actor: "user", method: http.MethodGet, path: "/users/{user}",
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{"id": "{user}", "email": "user@example.com"},
+		},
+		{
+			name: "get missing user", actor: "admin", method: http.MethodGet, path: "/users/missing",
+			wantStatus: http.StatusNotFound, wantCode: apierror.CodeUserNotFound,
+		},
+		{
+			name: "list users", actor: "admin", method: http.MethodGet, path: "/admin/users?limit=1&sort=email",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "list users with a limit too large", actor: "admin", method: http.MethodGet, path: "/admin/users?limit=101",
+			wantStatus: http.StatusUnprocessableEntity, wantCode: apierror.CodeValidationFailed,
+		},
+		{
+			name: "list users with an invalid cursor", actor: "admin", method: http.MethodGet, path: "/admin/users?cursor=invalid",
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidCursor,
+		},
+		{
+			name: "list users with an invalid role", actor: "admin", method: http.MethodGet, path: "/admin/users?role=superuser",
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidRole,
+		},
+		{
+			name: "update own profile", actor: "user", method: http.MethodPut, path: "/users/{user}",
+			body:       `{"name":"Grace","email":"user@example.com","role":"admin","locale":"en-GB"}`,
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{"name": "Grace", "role": "user", "locale": "en-GB"},
+		},
+		{
+			name: "admin update changing the role", actor: "admin", method: http.MethodPut, path: "/admin/users/{user}",
+			body:       `{"name":"Grace","email":"user@example.com","role":"admin"}`,
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeRoleChangeNotAllowed,
+		},
+		{
+			name: "patch own profile", actor: "user", method: http.MethodPatch, path: "/users/{user}",
+			body:       `{"display_name":"Gracie","metadata":{"plan":"pro"}}`,
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{"name": "User", "display_name": "Gracie"},
+		},
+		{
+			name: "patch with invalid metadata", actor: "user", method: http.MethodPatch, path: "/users/{user}",
+			body:       `{"metadata":{"nested":{"a":"b"}}}`,
+			wantStatus: http.StatusUnprocessableEntity, wantCode: apierror.CodeValidationFailed,
+		},
+		{
+			name: "delete own account", actor: "user", method: http.MethodDelete, path: "/users/{user}",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "change role", actor: "admin", method: http.MethodPut, path: "/admin/users/{user}/role",
+			body:       `{"role":"admin"}`,
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{"role": "admin"},
+		},
+		{
+			name: "demote the last admin", actor: "admin", method: http.MethodPut, path: "/admin/users/{admin}/role",
+			body:       `{"role":"user"}`,
+			wantStatus: http.StatusConflict, wantCode: apierror.CodeLastAdmin,
+		},
+		{
+			name: "suspend", actor: "admin", method: http.MethodPost, path: "/admin/users/{user}/suspend",
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{"status": "suspended"},
+		},
+		{
+			name: "suspend oneself", actor: "admin", method: http.MethodPost, path: "/admin/users/{admin}/suspend",
+			wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidStatusTransition,
+		},
+		{
+			name: "restore an active user", actor: "admin", method: http.MethodPost, path: "/admin/users/{user}/restore",
+			wantStatus: http.StatusConflict, wantCode: apierror.CodeInvalidStatusTransition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := repository.NewMemoryUserRepository()
+			admin, err := repo.CreateUser(ctx, model.User{Name: "Admin", Email: "admin@example.com", Role: role.Admin})
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, err := repo.CreateUser(ctx, model.User{Name: "User", Email: "user@example.com", Role: role.User})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actors := map[string]*model.User{"admin": admin, "user": user}
+			expand := strings.NewReplacer("{admin}", admin.ID, "{user}", user.ID).Replace
+
+			h := NewUserHandler(service.NewUserService(repo, &stubAuthService{}), NewValidator())
+
+			// Stands in for AuthMiddleware; authorization is covered by the auth package.
+			authenticate := func(c *gin.Context) {
+				if actor, ok := actors[tt.actor]; ok {
+					c.Set("userID", actor.ID)
+					c.Set("userRole", actor.Role)
+				}
+			}
+
+			r := gin.New()
+			r.POST("/users", h.CreateUser)
+			r.GET("/users/:id", authenticate, h.GetUser)
+			r.PUT("/users/:id", authenticate, h.UpdateUser)
+			r.PATCH("/users/:id", authenticate, h.PatchUser)
+			r.DELETE("/users/:id", authenticate, h.DeleteUser)
+			r.GET("/admin/users", authenticate, h.GetAllUsers)
+			r.POST("/admin/users", authenticate, h.AdminCreateUser)
+			r.PUT("/admin/users/:id", authenticate, h.AdminUpdateUser)
+			r.PUT("/admin/users/:id/role", authenticate, h.ChangeUserRole)
+			r.POST("/admin/users/:id/suspend", authenticate, h.SuspendUser)
+			r.POST("/admin/users/:id/restore", authenticate, h.RestoreUser)
+
+			req := httptest.NewRequest(tt.method, expand(tt.path), strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				assertProblemCode(t, w, tt.wantCode)
+			}
+			if len(tt.wantFields) == 0 {
+				return
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+			}
+			for field, want := range tt.wantFields {
+				if s, ok := want.(string); ok {
+					want = expand(s)
+				}
+				if got := body[field]; got != want {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/id"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	id_translations "github.com/go-playground/validator/v10/translations/id"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"golang.org/x/text/language"
+)
+
+// customMessages overrides the validator's default message for some tags, per locale, and
+// adds messages for the project's own tags. {0} is the field's name, {1} the tag's parameter.
+var customMessages = map[string]map[string]string{
+	"en": {
+		"required": "{0} is required",
+		"email":    "{0} must be a valid email address",
+		"maxbytes": "{0} must be at most {1} bytes long",
+
+		"metadatakey":   "{0} must have a key of 1 to 64 letters, digits, '_' or '-'",
+		"metadatavalue": "{0} must be a string of at most 1024 bytes, a number, a boolean or null",
+	},
+	"id": {
+		"required": "{0} wajib diisi",
+		"email":    "{0} harus berupa alamat email yang valid",
+		"maxbytes": "{0} tidak boleh lebih dari {1} byte",
+
+		"metadatakey":   "kunci {0} harus terdiri dari 1 sampai 64 huruf, angka, '_' atau '-'",
+		"metadatavalue": "{0} harus berupa teks paling banyak 1024 byte, angka, boolean atau null",
+	},
+}
+
+// Validator validates request bodies and query parameters, and translates validation
+// failures into the language the client asks for in its Accept-Language header.
+// Fields are reported by their JSON (or query parameter) name, as the client sent them.
+type Validator struct {
+	validate   *validator.Validate
+	translator *ut.UniversalTranslator
+}
+
+// NewValidator creates the validator used by the handlers, with English and Indonesian
+// messages, and the project's custom validation tags registered:
+//
+//   - maxbytes=N: the string is at most N bytes long. Unlike max, which counts
+//     characters, it matches limits on the encoded size, such as bcrypt's 72 bytes.
+//   - metadatakey: a metadata key of 1 to 64 letters, digits, '_' or '-'.
+//   - metadatavalue: a metadata value that is a string of at most 1024 bytes, a number
+//     or a boolean. Nested objects and arrays are not allowed.
+//
+// It panics if the translations cannot be registered, which is a programming error.
+func NewValidator() *Validator {
+	validate := validator.New()
+
+	// Registration only fails for an empty tag or a nil function.
+	_ = validate.RegisterValidation("maxbytes", validateMaxBytes)
+	_ = validate.RegisterValidation("metadatakey", validateMetadataKey)
+	_ = validate.RegisterValidation("metadatavalue", validateMetadataValue)
+
+	// Report fields by the name clients use for them rather than the Go field name.
+	validate.RegisterTagNameFunc(fieldName)
+
+	// English is the fallback for clients that accept none of the supported languages.
+	english := en.New()
+	translator := ut.New(english, english, id.New())
+
+	defaults := map[string]func(*validator.Validate, ut.Translator) error{
+		"en": en_translations.RegisterDefaultTranslations,
+		"id": id_translations.RegisterDefaultTranslations,
+	}
+	for locale, registerDefaults := range defaults {
+		trans, _ := translator.GetTranslator(locale)
+		if err := registerDefaults(validate, trans); err != nil {
+			panic("handler: failed to register " + locale + " validation messages: " + err.Error())
+		}
+		for tag, message := range customMessages[locale] {
+			if err := validate.RegisterTranslation(tag, trans, registerMessage(tag, message), translateMessage); err != nil {
+				panic("handler: failed to register " + locale + " message for " + tag + ": " + err.Error())
+			}
+		}
+	}
+
+	return &Validator{validate: validate, translator: translator}
+}
+
+// Struct validates a struct based on its validate tags.
+func (v *Validator) Struct(s interface{}) error {
+	return v.validate.Struct(s)
+}
+
+// validationError converts an error returned by Struct into the error response.
+// Validation failures become a 422 with one message per field, in the client's language.
+// Any other error means that the validator was misused (e.g. given a nil pointer), so
+// it is logged and reported as an internal error rather than exposed to the client.
+func (v *Validator) validationError(c *gin.Context, err error) *apierror.APIError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		log.Printf("Error validating request: %v", err)
+		return apierror.NewInternalServerError("")
+	}
+
+	trans := v.translatorFor(c.GetHeader("Accept-Language"))
+	c.Header("Content-Language", trans.Locale())
+
+	details := make([]apierror.FieldError, 0, len(validationErrs))
+	for _, fieldErr := range validationErrs {
+		details = append(details, apierror.FieldError{
+			Field:   fieldPath(fieldErr),
+			Code:    fieldErr.Tag(),
+			Message: fieldErr.Translate(trans),
+		})
+	}
+
+	return apierror.NewValidationError(details)
+}
+
+// translatorFor returns the translator for the most preferred supported language in
+// an Accept-Language header, or the English fallback.
+func (v *Validator) translatorFor(acceptLanguage string) ut.Translator {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err == nil {
+		// Tags are ordered by preference; only the base language matters for the messages.
+		for _, tag := range tags {
+			base, _ := tag.Base()
+			if trans, found := v.translator.GetTranslator(base.String()); found {
+				return trans
+			}
+		}
+	}
+
+	return v.translator.GetFallback()
+}
+
+// fieldName returns the name a struct field is sent as: its JSON name, or its query
+// parameter name for query structs, or the Go name if it has neither.
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+
+	return field.Name
+}
+
+// fieldPath returns the path of the field relative to the validated struct, e.g. "email"
+// or "metadata[plan]", without the struct's own name.
+func fieldPath(fieldErr validator.FieldError) string {
+	_, path, found := strings.Cut(fieldErr.Namespace(), ".")
+	if !found {
+		return fieldErr.Field()
+	}
+
+	return path
+}
+
+// registerMessage returns a function that adds a message for tag to a translator,
+// replacing the default one if there is any.
+func registerMessage(tag, message string) validator.RegisterTranslationsFunc {
+	return func(trans ut.Translator) error {
+		return trans.Add(tag, message, true)
+	}
+}
+
+// translateMessage renders the message registered for a failed tag.
+func translateMessage(trans ut.Translator, fieldErr validator.FieldError) string {
+	message, err := trans.T(fieldErr.Tag(), fieldErr.Field(), fieldErr.Param())
+	if err != nil {
+		return fieldErr.Error()
+	}
+
+	return message
+}
+
+// validateMaxBytes implements the maxbytes tag.
+func validateMaxBytes(fl validator.FieldLevel) bool {
+	limit, err := strconv.Atoi(fl.Param())
+	if err != nil {
+		panic("handler: maxbytes requires an integer parameter, got " + fl.Param())
+	}
+
+	return len(fl.Field().String()) <= limit
+}
+
+// metadataKeyPattern is the pattern of keys accepted by the metadatakey tag.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// maxMetadataValueBytes bounds the length of string values accepted by the metadatavalue tag.
+const maxMetadataValueBytes = 1024
+
+// validateMetadataKey implements the metadatakey tag.
+func validateMetadataKey(fl validator.FieldLevel) bool {
+	return metadataKeyPattern.MatchString(fl.Field().String())
+}
+
+// validateMetadataValue implements the metadatavalue tag. Values decoded from JSON are
+// strings, float64 numbers or booleans, or maps and slices, which are rejected.
+func validateMetadataValue(fl validator.FieldLevel) bool {
+	field := fl.Field()
+	switch field.Kind() {
+	case reflect.String:
+		return len(field.String()) <= maxMetadataValueBytes
+	case reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+)
+
+func TestValidationError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	validate := NewValidator()
+
+	invalidUser := model.User{Name: "A", Email: "not-an-email"}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		err            error
+		wantStatus     int
+		wantLanguage   string
+		wantMessages   map[string]string
+	}{
+		{
+			name:         "English by default",
+			err:          validate.Struct(invalidUser),
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantLanguage: "en",
+			wantMessages: map[string]string{
+				"name":     "name must be at least 2 characters in length",
+				"email":    "email must be a valid email address",
+				"password": "password is required",
+			},
+		},
+		{
+			name:           "Indonesian when preferred",
+			acceptLanguage: "fr-CH, id;q=0.9, en;q=0.8",
+			err:            validate.Struct(invalidUser),
+			wantStatus:     http.StatusUnprocessableEntity,
+			wantLanguage:   "id",
+			wantMessages: map[string]string{
+				"name":     "panjang minimal name adalah 2 karakter",
+				"email":    "email harus berupa alamat email yang valid",
+				"password": "password wajib diisi",
+			},
+		},
+		{
+			name:           "English fallback for unsupported languages",
+			acceptLanguage: "de-DE",
+			err:            validate.Struct(model.User{Name: "Budi", Email: "budi@example.com", Password: string(make([]byte, 73))}),
+			wantStatus:     http.StatusUnprocessableEntity,
+			wantLanguage:   "en",
+			wantMessages:   map[string]string{"password": "password must be at most 72 bytes long"},
+		},
+		{
+			name: "metadata keys and values",
+			err: validate.Struct(model.User{Name: "Budi", Email: "budi@example.com", Password: "password123", Metadata: map[string]interface{}{
+				"plan":    "pro",
+				"seats":   float64(5),
+				"bad key": true,
+				"nested":  map[string]interface{}{"a": "b"},
+			}}),
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantLanguage: "en",
+			wantMessages: map[string]string{
+				"metadata[bad key]": "metadata[bad key] must have a key of 1 to 64 letters, digits, '_' or '-'",
+				"metadata[nested]":  "metadata[nested] must be a string of at most 1024 bytes, a number, a boolean or null",
+			},
+		},
+		{
+			name:       "non-validation error",
+			err:        validate.Struct(nil),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "unrelated error",
+			err:        errors.New("boom"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("expected the test input to fail validation")
+			}
+
+			r := gin.New()
+			r.POST("/", func(c *gin.Context) { respondWithError(c, validate.validationError(c, tt.err)) })
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantLanguage)
+			}
+
+			var body apierror.APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON body: %v", err)
+			}
+			got := make(map[string]string, len(body.Details))
+			for _, detail := range body.Details {
+				got[detail.Field] = detail.Message
+			}
+			if len(got) != len(tt.wantMessages) {
+				t.Errorf("got field errors %v, want %v", got, tt.wantMessages)
+			}
+			for field, want := range tt.wantMessages {
+				if got[field] != want {
+					t.Errorf("message for %q = %q, want %q", field, got[field], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is an email to be delivered by a Mailer.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages. Implementations can send through an email provider,
+// or, for local runs, write the messages to the log or to files.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer is a Mailer that writes every message to the application log.
+type LogMailer struct{}
+
+// NewLogMailer creates a new instance of LogMailer.
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send writes the message to the application log.
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer is a Mailer that writes every message to its own file in a directory,
+// so that messages can be inspected during local development and tests.
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer creates a new instance of FileMailer writing to dir, creating it if needed.
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	return &FileMailer{dir: dir}, nil
+}
+
+// Send writes the message to a new .eml file named after the current time and recipient.
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s_%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), recipient)
+
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", msg.To, msg.Subject, msg.Body)
+	if err := os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o600); err != nil {
+		return fmt.Errorf("failed to write email file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadMailer creates the Mailer selected by the MAILER environment variable:
+// "log" writes messages to the log, and "file" writes them to the directory named by
+// MAILER_DIR (default "./mail"). Both deliver live login and verification tokens to
+// wherever the output is kept, so MAILER must be set explicitly rather than defaulting
+// to one of them, and choosing "log" is warned about at startup.
+func LoadMailer() (Mailer, error) {
+	switch mailer := os.Getenv("MAILER"); mailer {
+	case "":
+		return nil, errors.New("MAILER is not set: must be \"log\" or \"file\"")
+	case "log":
+		log.Println("Warning: MAILER=log writes emails, including login and verification tokens, to the application log; do not use it in production")
+		return NewLogMailer(), nil
+	case "file":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		return NewFileMailer(dir)
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q: must be \"log\" or \"file\"", mailer)
+	}
+}
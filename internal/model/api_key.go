@@ -0,0 +1,70 @@
+package model
+
+import (
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// APIKey represents an API key for machine-to-machine clients as persisted in Firestore.
+// Only the SHA-256 hash of the key's secret is stored; the plaintext key is shown once, when it is created.
+type APIKey struct {
+	// ID is the unique identifier of the key, used as the document name. It is also the
+	// public part of the key itself, so that the key can be looked up without a query.
+	ID string `json:"id" firestore:"-"`
+
+	// Name describes what the key is used for, e.g. "nightly export".
+	Name string `json:"name" firestore:"name"`
+
+	// Role is the role requests authenticated with the key act with.
+	Role role.Role `json:"role" firestore:"role"`
+
+	// Scopes, if not empty, restricts the key to these permissions of its role.
+	Scopes []role.Permission `json:"scopes,omitempty" firestore:"scopes"`
+
+	// SecretHash is the hex-encoded SHA-256 hash of the key's secret.
+	// The `json:"-"` tag ensures the hash is never rendered in a JSON response.
+	SecretHash string `json:"-" firestore:"secret_hash"`
+
+	// CreatedBy is the ID of the administrator who created the key.
+	CreatedBy string `json:"created_by" firestore:"created_by"`
+
+	// CreatedAt is the time at which the key was created.
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+
+	// ExpiresAt is the time after which the key can no longer be used.
+	ExpiresAt time.Time `json:"expires_at" firestore:"expires_at"`
+
+	// LastUsedAt is the time at which the key was last used, with a precision of about a minute.
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" firestore:"last_used_at"`
+
+	// RevokedAt is set once the key has been revoked.
+	RevokedAt *time.Time `json:"revoked_at,omitempty" firestore:"revoked_at"`
+
+	// RevokedBy is the ID of the administrator who revoked the key.
+	RevokedBy string `json:"revoked_by,omitempty" firestore:"revoked_by,omitempty"`
+}
+
+// APIKeyCreate represents the request body used to create an API key.
+type APIKeyCreate struct {
+	// Name describes what the key is used for.
+	Name string `json:"name" validate:"required,min=2,max=100"`
+
+	// Role is the role the key acts with. It defaults to "user".
+	Role role.Role `json:"role"`
+
+	// Scopes optionally restricts the key to some of its role's permissions.
+	Scopes []role.Permission `json:"scopes" validate:"omitempty,dive,required"`
+
+	// ExpiresAt is the time after which the key can no longer be used.
+	ExpiresAt time.Time `json:"expires_at" validate:"required"`
+}
+
+// CreatedAPIKey is the response returned when an API key is created. It is the only
+// response that contains the plaintext key.
+type CreatedAPIKey struct {
+	APIKey
+
+	// Key is the plaintext key, sent by clients in the X-API-Key header.
+	Key string `json:"key"`
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// Audit actions recorded in the "audit_logs" collection.
+const (
+	// AuditActionRoleChanged is recorded when a user's role is changed.
+	AuditActionRoleChanged = "user.role_changed"
+	// AuditActionUserCreated is recorded when an administrator creates a user. The new
+	// value is the role the user was created with.
+	AuditActionUserCreated = "user.created"
+	// AuditActionUserSuspended is recorded when an administrator suspends a user.
+	AuditActionUserSuspended = "user.suspended"
+	// AuditActionUserRestored is recorded when an administrator restores a suspended or
+	// deleted user. The old value is the status the user was restored from.
+	AuditActionUserRestored = "user.restored"
+)
+
+// AuditLog represents an entry in the "audit_logs" collection recording an administrative action.
+type AuditLog struct {
+	// ID is the unique identifier of the entry, used as the document name.
+	ID string `json:"id" firestore:"-"`
+
+	// Action names what was done, e.g. "user.role_changed".
+	Action string `json:"action" firestore:"action"`
+
+	// ActorID is the ID of the user who performed the action.
+	ActorID string `json:"actor_id" firestore:"actor_id"`
+
+	// TargetID is the ID of the user the action was performed on.
+	TargetID string `json:"target_id" firestore:"target_id"`
+
+	// OldValue and NewValue record the value before and after the change.
+	OldValue string `json:"old_value" firestore:"old_value"`
+	NewValue string `json:"new_value" firestore:"new_value"`
+
+	// CreatedAt is the time at which the action was performed.
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// RoleChange describes a change of a user's role requested by an administrator.
+type RoleChange struct {
+	// UserID is the ID of the user whose role is changed.
+	UserID string
+
+	// NewRole is the role to assign.
+	NewRole role.Role
+
+	// ActorID is the ID of the administrator making the change.
+	ActorID string
+}
+
+// RoleUpdate represents the request body used to change a user's role.
+type RoleUpdate struct {
+	// Role is the role to assign to the user.
+	Role role.Role `json:"role" validate:"required"`
+}
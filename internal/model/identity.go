@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// Identity links an account at an external identity provider, such as Firebase
+// Authentication or an OIDC provider, to a user, as persisted in Firestore.
+type Identity struct {
+	// Provider names the identity provider, e.g. "firebase" or "google".
+	Provider string `firestore:"provider"`
+
+	// Subject is the user's ID at the provider (the sub claim).
+	Subject string `firestore:"subject"`
+
+	// UserID is the ID of the linked user.
+	UserID string `firestore:"user_id"`
+
+	// Email is the address the provider reported when the identity was linked.
+	Email string `firestore:"email"`
+
+	// CreatedAt is the time at which the identity was linked.
+	CreatedAt time.Time `firestore:"created_at"`
+}
+
+// ExternalAccount describes a user as authenticated by an external identity provider.
+type ExternalAccount struct {
+	// Provider names the identity provider.
+	Provider string
+
+	// Subject is the user's ID at the provider.
+	Subject string
+
+	// Email is the user's email address at the provider, which may be empty.
+	Email string
+
+	// EmailVerified reports whether the provider has verified the email address.
+	EmailVerified bool
+
+	// Name is the user's display name at the provider, which may be empty.
+	Name string
+
+	// Role is the role to create the user with on first sign-in, if the provider assigns
+	// one, e.g. through a Firebase custom claim. It is empty for the default "user" role.
+	Role role.Role
+}
+
+// OIDCLogin is the start of a login at an OpenID Connect provider.
+type OIDCLogin struct {
+	// AuthURL is the provider's login page to redirect the user to.
+	AuthURL string
+
+	// StateToken holds the state, nonce and PKCE verifier, to be kept in a cookie until
+	// the provider redirects back.
+	StateToken string
+}
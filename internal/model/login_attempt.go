@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// LoginAttempts tracks consecutive failed logins for an email address or a client IP,
+// as persisted in Firestore.
+type LoginAttempts struct {
+	// Failures is the number of consecutive failed logins.
+	Failures int `firestore:"failures"`
+
+	// LastFailureAt is the time of the most recent failed login.
+	LastFailureAt time.Time `firestore:"last_failure_at"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// MFA holds a user's TOTP two-factor authentication settings, as persisted in Firestore.
+// The document is created when enrollment starts and enabled once the user confirms a code.
+type MFA struct {
+	// UserID is the ID of the user, used as the document name.
+	UserID string `firestore:"-"`
+
+	// Secret is the base32-encoded TOTP secret shared with the user's authenticator app.
+	Secret string `firestore:"secret"`
+
+	// Enabled is set once the user has confirmed enrollment with a valid code.
+	Enabled bool `firestore:"enabled"`
+
+	// RecoveryCodeHashes are the SHA-256 hashes of the unused recovery codes.
+	RecoveryCodeHashes []string `firestore:"recovery_code_hashes"`
+
+	// LastUsedStep is the TOTP time step of the last accepted code. Codes from this step or
+	// earlier are rejected, so that each code can only be used once.
+	LastUsedStep int64 `firestore:"last_used_step"`
+
+	// CreatedAt is the time at which enrollment started.
+	CreatedAt time.Time `firestore:"created_at"`
+
+	// ConfirmedAt is the time at which enrollment was confirmed.
+	ConfirmedAt time.Time `firestore:"confirmed_at"`
+}
+
+// TOTPEnrollment is returned when a user starts TOTP enrollment.
+type TOTPEnrollment struct {
+	// Secret is the base32-encoded secret, for entering into an authenticator app by hand.
+	Secret string `json:"secret"`
+
+	// URI is the otpauth:// URI to encode in a QR code for authenticator apps to scan.
+	URI string `json:"otpauth_uri"`
+}
+
+// RecoveryCodes is returned once when TOTP enrollment is confirmed. Each code can be used
+// once in place of a TOTP code; only their hashes are stored.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFAChallenge is returned by the first login step for users with MFA enabled, instead
+// of a token pair. The token is exchanged for a token pair together with a TOTP code.
+type MFAChallenge struct {
+	// MFARequired is always true, so that clients can tell a challenge from a token pair.
+	MFARequired bool `json:"mfa_required"`
+
+	// MFAToken is the short-lived challenge token.
+	MFAToken string `json:"mfa_token"`
+
+	// ExpiresIn is the lifetime of the challenge token in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
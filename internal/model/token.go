@@ -0,0 +1,83 @@
+package model
+
+import "time"
+
+// TokenPair is the response returned after a successful login or token refresh.
+type TokenPair struct {
+	// AccessToken is the short-lived JWT used in the Authorization header.
+	AccessToken string `json:"access_token"`
+
+	// RefreshToken is the long-lived opaque token used to obtain a new token pair.
+	RefreshToken string `json:"refresh_token"`
+
+	// TokenType is always "Bearer".
+	TokenType string `json:"token_type"`
+
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// RefreshToken represents a refresh token as persisted in Firestore.
+// Only the SHA-256 hash of the token is stored; the plaintext is returned to the client once.
+type RefreshToken struct {
+	// ID is the hex-encoded SHA-256 hash of the token, used as the document name.
+	ID string `firestore:"-"`
+
+	// UserID is the ID of the user the token was issued to.
+	UserID string `firestore:"user_id"`
+
+	// FamilyID groups every token descended from the same login through rotation.
+	// Replaying a rotated token revokes the whole family.
+	FamilyID string `firestore:"family_id"`
+
+	// CreatedAt is the time at which the token was issued.
+	CreatedAt time.Time `firestore:"created_at"`
+
+	// ExpiresAt is the time after which the token can no longer be used.
+	ExpiresAt time.Time `firestore:"expires_at"`
+
+	// Used is set once the token has been exchanged for a new pair.
+	Used bool `firestore:"used"`
+
+	// Revoked is set when the token's family has been revoked.
+	Revoked bool `firestore:"revoked"`
+
+	// AuthMethods records how the user authenticated at login, so that access tokens
+	// issued on refresh carry the same amr claim.
+	AuthMethods []string `firestore:"auth_methods"`
+}
+
+// MagicLink represents a passwordless login token as persisted in Firestore.
+// Only the SHA-256 hash of the token is stored; the plaintext is only sent by email.
+type MagicLink struct {
+	// ID is the hex-encoded SHA-256 hash of the token, used as the document name.
+	ID string `firestore:"-"`
+
+	// UserID is the ID of the user the link logs in as.
+	UserID string `firestore:"user_id"`
+
+	// Email is the address the link was sent to. The link is rejected if the user's
+	// email has changed since.
+	Email string `firestore:"email"`
+
+	// CreatedAt is the time at which the link was issued.
+	CreatedAt time.Time `firestore:"created_at"`
+
+	// ExpiresAt is the time after which the link can no longer be used.
+	ExpiresAt time.Time `firestore:"expires_at"`
+
+	// Used is set once the link has been exchanged for a token pair.
+	Used bool `firestore:"used"`
+}
+
+// Session describes the access token of the current request, as verified by the AuthMiddleware.
+type Session struct {
+	// TokenID is the unique ID of the access token (the jti claim).
+	TokenID string
+
+	// UserID is the ID of the user the token was issued to.
+	UserID string
+
+	// ExpiresAt is the time at which the access token expires.
+	ExpiresAt time.Time
+}
@@ -1,6 +1,25 @@
 package model
 
-import "github.com/hermantrym/go-firebase-api/internal/role"
+import (
+	"strings"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// UserStatus is the state of a user's account.
+type UserStatus string
+
+// Defines the states a user's account can be in.
+const (
+	// UserStatusActive is the status of a user who can log in and use the API.
+	UserStatusActive UserStatus = "active"
+	// UserStatusSuspended is the status of a user an administrator has temporarily blocked.
+	UserStatusSuspended UserStatus = "suspended"
+	// UserStatusDeleted is the status of a deleted user. Deleted users can be restored until
+	// they are purged at the end of the retention period.
+	UserStatusDeleted UserStatus = "deleted"
+)
 
 // User represents the data model for a user in the application.
 // It includes struct tags for JSON serialization, Firestore mapping, and validation.
@@ -20,4 +39,175 @@ type User struct {
 
 	// Role defines the user's authorization level (e.g., "admin", "user").
 	Role role.Role `json:"role" firestore:"role"`
+
+	// Password is the plaintext password supplied at registration.
+	// It is never stored in Firestore and is cleared before the user is returned in a response.
+	// The upper bound of 72 bytes, rather than characters, matches the maximum input length
+	// accepted by bcrypt, so multi-byte passwords are rejected before hashing.
+	Password string `json:"password,omitempty" firestore:"-" validate:"required,min=8,maxbytes=72"`
+
+	// PasswordHash is the bcrypt hash of the user's password as stored in Firestore.
+	// The `json:"-"` tag ensures the hash is never rendered in a JSON response.
+	PasswordHash string `json:"-" firestore:"password_hash"`
+
+	// Verified reports whether the user has proven ownership of their email address.
+	// It is reset whenever the email address changes.
+	Verified bool `json:"verified" firestore:"verified"`
+
+	// Status is the state of the user's account. Only active users can log in.
+	Status UserStatus `json:"status" firestore:"status"`
+
+	// SuspendedAt is the time at which the user was suspended, while they are suspended.
+	SuspendedAt *time.Time `json:"suspended_at,omitempty" firestore:"suspended_at,omitempty"`
+
+	// DeletedAt is the time at which the user was deleted, while they are deleted.
+	// The user is purged once the retention period has passed since then.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" firestore:"deleted_at,omitempty"`
+
+	// DisplayName is the name shown to other users, if different from the full name.
+	DisplayName string `json:"display_name,omitempty" firestore:"display_name,omitempty" validate:"omitempty,max=100"`
+
+	// AvatarURL is the address of the user's profile picture.
+	AvatarURL string `json:"avatar_url,omitempty" firestore:"avatar_url,omitempty" validate:"omitempty,http_url,max=2048"`
+
+	// Phone is the user's phone number in E.164 format, e.g. "+6281234567890".
+	Phone string `json:"phone,omitempty" firestore:"phone,omitempty" validate:"omitempty,e164"`
+
+	// Locale is the user's preferred language as a BCP 47 tag, e.g. "id-ID".
+	Locale string `json:"locale,omitempty" firestore:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+
+	// Timezone is the user's IANA time zone, e.g. "Asia/Jakarta".
+	Timezone string `json:"timezone,omitempty" firestore:"timezone,omitempty" validate:"omitempty,timezone"`
+
+	// Metadata holds free-form attributes of the user. See the metadata validation tags.
+	Metadata map[string]interface{} `json:"metadata,omitempty" firestore:"metadata,omitempty" validate:"omitempty,max=50,dive,keys,metadatakey,endkeys,omitempty,metadatavalue"`
+
+	// CreatedAt is the time at which the user was created. It is used for sorting user lists.
+	// It is set by the Firestore server when the user is created.
+	CreatedAt time.Time `json:"created_at" firestore:"created_at,serverTimestamp"`
+
+	// UpdatedAt is the time at which the user was last changed. It is set by the Firestore
+	// server whenever the user is written.
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at,serverTimestamp"`
+
+	// LastLoginAt is the time of the user's most recent login, if they have ever logged in.
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" firestore:"last_login_at,omitempty"`
+}
+
+// IsActive reports whether the user can log in and use the API, i.e. is neither suspended
+// nor deleted. Users stored before statuses were introduced have no status and are active.
+func (u *User) IsActive() bool {
+	return u.Status == "" || u.Status == UserStatusActive
+}
+
+// UserUpdate represents the request body used to replace a user's profile.
+type UserUpdate struct {
+	// Name is the user's full name, with the same constraints as User.Name.
+	Name string `json:"name" validate:"required,min=2,max=100"`
+
+	// Email is the user's email address, with the same constraints as User.Email.
+	Email string `json:"email" validate:"required,email"`
+
+	// The optional profile fields and metadata have the same constraints as on User.
+	// Omitted fields are cleared.
+	DisplayName string                 `json:"display_name" validate:"omitempty,max=100"`
+	AvatarURL   string                 `json:"avatar_url" validate:"omitempty,http_url,max=2048"`
+	Phone       string                 `json:"phone" validate:"omitempty,e164"`
+	Locale      string                 `json:"locale" validate:"omitempty,bcp47_language_tag"`
+	Timezone    string                 `json:"timezone" validate:"omitempty,timezone"`
+	Metadata    map[string]interface{} `json:"metadata" validate:"omitempty,max=50,dive,keys,metadatakey,endkeys,omitempty,metadatavalue"`
+
+	// Role must be omitted or equal to the current role; roles are changed through
+	// PUT /admin/users/:id/role. It is ignored on the account owner's routes.
+	Role role.Role `json:"role"`
+}
+
+// UserPatch represents the request body used to partially update a user.
+// Fields are pointers so that omitted fields can be told apart from empty ones;
+// only the non-nil fields are written to Firestore.
+type UserPatch struct {
+	// Name is the user's new full name, if supplied.
+	Name *string `json:"name" validate:"omitempty,min=2,max=100"`
+
+	// Email is the user's new email address, if supplied.
+	Email *string `json:"email" validate:"omitempty,email"`
+
+	// The optional profile fields have the same constraints as on User. An empty string
+	// clears a field.
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,http_url,max=2048"`
+	Phone       *string `json:"phone" validate:"omitempty,e164"`
+	Locale      *string `json:"locale" validate:"omitempty,bcp47_language_tag"`
+	Timezone    *string `json:"timezone" validate:"omitempty,timezone"`
+
+	// Metadata, if supplied, replaces all of the user's metadata. An empty object clears it.
+	Metadata map[string]interface{} `json:"metadata" validate:"omitempty,max=50,dive,keys,metadatakey,endkeys,omitempty,metadatavalue"`
+
+	// Role must be omitted or equal to the current role; roles are changed through
+	// PUT /admin/users/:id/role. It is ignored on the account owner's routes.
+	Role *role.Role `json:"role"`
+}
+
+// UserListQuery holds the query string parameters accepted when listing users.
+type UserListQuery struct {
+	// Limit is the maximum number of users to return in one page.
+	Limit int `form:"limit" validate:"omitempty,min=1,max=100"`
+
+	// Cursor is the opaque token returned as next_cursor by the previous page.
+	Cursor string `form:"cursor"`
+
+	// Role restricts the results to users with this role.
+	Role role.Role `form:"role"`
+
+	// Email restricts the results to the user with this email address.
+	Email string `form:"email" validate:"omitempty,email"`
+
+	// Sort is the field the results are ordered by. When empty, users are ordered by ID.
+	Sort string `form:"sort" validate:"omitempty,oneof=name email created_at"`
+
+	// IncludeDeleted includes deleted users that have not been purged yet in the results.
+	IncludeDeleted bool `form:"include_deleted"`
+}
+
+// UserPage is the response envelope for a page of users.
+type UserPage struct {
+	// Items holds the users on this page.
+	Items []User `json:"items"`
+
+	// NextCursor is the token to pass as ?cursor= to fetch the next page.
+	// It is empty when there are no more results.
+	NextCursor string `json:"next_cursor"`
+}
+
+// NormalizeEmail returns the canonical form of an email address used for storage,
+// lookups and uniqueness checks. Addresses are compared case-insensitively.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// StatusChange describes a change of a user's status requested by an administrator.
+type StatusChange struct {
+	// UserID is the ID of the user whose status is changed.
+	UserID string
+
+	// NewStatus is the status to assign.
+	NewStatus UserStatus
+
+	// ActorID is the ID of the administrator making the change.
+	ActorID string
+}
+
+// EmailVerification describes the use of an email verification token.
+type EmailVerification struct {
+	// UserID is the ID of the user the token was issued to.
+	UserID string
+
+	// Email is the address the token was sent to. It must still be the user's address.
+	Email string
+
+	// TokenID is the token's unique ID, recorded so that the token can only be used once.
+	TokenID string
+
+	// ExpiresAt is the time at which the token expires.
+	ExpiresAt time.Time
 }
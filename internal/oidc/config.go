@@ -0,0 +1,56 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// providerNamePattern restricts provider names to what can appear in a URL path and an
+// environment variable name.
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// reservedNames cannot be used for OIDC providers because they are used for other
+// identity links or routes.
+var reservedNames = map[string]bool{"firebase": true, "mfa": true, "refresh": true}
+
+// LoadProviders creates the providers listed in the comma-separated OIDC_PROVIDERS
+// environment variable. Each provider NAME is configured with OIDC_<NAME>_ISSUER,
+// OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL and the
+// optional space-separated OIDC_<NAME>_SCOPES (default "email profile").
+// It returns an empty map if no providers are configured.
+func LoadProviders() (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !providerNamePattern.MatchString(name) || reservedNames[name] {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := ProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       []string{"email", "profile"},
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(scopes)
+		}
+
+		if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC provider %q requires %sISSUER, %sCLIENT_ID and %sREDIRECT_URL", name, prefix, prefix, prefix)
+		}
+
+		providers[name] = NewProvider(config, nil)
+	}
+
+	return providers, nil
+}
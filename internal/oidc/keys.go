@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// supportedMethods are the ID token signing algorithms that are accepted.
+var supportedMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// keyRefreshInterval limits how often the key set is fetched again because a token
+// names an unknown key, e.g. after the provider rotated its keys.
+const keyRefreshInterval = time.Minute
+
+// jwk is a JSON Web Key as published in a provider's key set.
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// keySet caches a provider's signing keys, fetching them again when a token names a key
+// that is not in the cache.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// newKeySet creates a key set backed by the provider's jwks_uri.
+func newKeySet(url string, client *http.Client) *keySet {
+	return &keySet{url: url, client: client}
+}
+
+// key returns the public key that the token is signed with, checking that it suits the
+// token's algorithm.
+func (ks *keySet) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.lookup(kid)
+	if !ok && time.Since(ks.fetchedAt) > keyRefreshInterval {
+		if err := ks.fetch(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = ks.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, isRSA := token.Method.(*jwt.SigningMethodRSA); !isRSA {
+			return nil, fmt.Errorf("unexpected signing method %q for RSA key", token.Method.Alg())
+		}
+	case *ecdsa.PublicKey:
+		if _, isECDSA := token.Method.(*jwt.SigningMethodECDSA); !isECDSA {
+			return nil, fmt.Errorf("unexpected signing method %q for EC key", token.Method.Alg())
+		}
+	}
+
+	return key, nil
+}
+
+// lookup finds a key by ID. A token without a kid is accepted if the set has a single key.
+// The caller must hold ks.mu.
+func (ks *keySet) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// fetch downloads the key set, skipping keys that are not for signatures or not supported.
+// The caller must hold ks.mu.
+func (ks *keySet) fetch(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, ks.client, ks.url, &set); err != nil {
+		return fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.KeyID] = key
+		}
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+// publicKey decodes an RSA or EC JSON Web Key.
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,210 @@
+// Package oidc implements OpenID Connect login with the authorization code flow and PKCE.
+// Providers are configured generically by their issuer URL, from which the endpoints and
+// signing keys are discovered, so that any compliant provider (or a local mock) can be used.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// discoveryTimeout bounds requests to a provider's discovery and key endpoints.
+const discoveryTimeout = 10 * time.Second
+
+// ProviderConfig configures an OpenID Connect provider.
+type ProviderConfig struct {
+	// Name identifies the provider in URLs (e.g. /auth/google/start) and in identity links.
+	Name string
+
+	// Issuer is the provider's issuer URL. The provider's configuration is discovered from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer string
+
+	// ClientID and ClientSecret are the credentials registered with the provider.
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the callback URL registered with the provider.
+	RedirectURL string
+
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+}
+
+// Claims holds the verified claims of an ID token that are used to identify the user.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	AuthorizedBy  string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// discovery is the subset of the provider metadata (OpenID Connect Discovery 1.0) that is used.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider performs the authorization code flow against an OpenID Connect provider.
+// Its configuration is discovered on first use, so that the API can start while a
+// provider is unreachable.
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *discovery
+	oauth2   *oauth2.Config
+	keys     *keySet
+}
+
+// NewProvider creates a provider from its configuration. If client is nil, an HTTP client
+// with a short timeout is used.
+func NewProvider(config ProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: discoveryTimeout}
+	}
+
+	return &Provider{config: config, client: client}
+}
+
+// Name returns the name the provider was configured with.
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL of the provider's login page. The state and nonce are
+// returned unchanged in the callback and the ID token, and the PKCE verifier must be
+// passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	oauth2Config, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return oauth2Config.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	), nil
+}
+
+// Exchange redeems an authorization code for tokens, using the PKCE verifier from the
+// start of the flow, and returns the claims of the verified ID token. The token must be
+// signed by the provider, issued by it for this client, unexpired, and carry the nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	oauth2Config, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth2Config.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.client), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	claims := &Claims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(supportedMethods),
+		jwt.WithIssuer(p.metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if _, err := parser.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		return keys.key(ctx, t)
+	}); err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	// With several audiences, the token must have been issued to this client (OIDC Core 3.1.3.7).
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID {
+		return nil, errors.New("invalid id_token: not authorized for this client")
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("invalid id_token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid id_token: missing subject")
+	}
+
+	return claims, nil
+}
+
+// discover fetches the provider's metadata on first use.
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *keySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.oauth2, p.keys, nil
+	}
+
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	var metadata discovery
+	if err := getJSON(ctx, p.client, issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider %q: %w", p.config.Name, err)
+	}
+
+	// The discovered issuer must match the configured one (OIDC Discovery 4.3).
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, nil, fmt.Errorf("OIDC provider %q reports issuer %q, expected %q", p.config.Name, metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("OIDC provider %q metadata is missing required endpoints", p.config.Name)
+	}
+
+	p.metadata = &metadata
+	p.keys = newKeySet(metadata.JWKSURI, p.client)
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       append([]string{"openid"}, p.config.Scopes...),
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  metadata.AuthorizationEndpoint,
+			TokenURL: metadata.TokenEndpoint,
+		},
+	}
+
+	return p.oauth2, p.keys, nil
+}
+
+// getJSON fetches a URL and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
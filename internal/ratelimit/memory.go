@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxMemoryBuckets is the most buckets the in-memory store holds.
+const maxMemoryBuckets = 10000
+
+// bucket is the state of a single token bucket.
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     Limit
+}
+
+// MemoryStore is a Store that keeps buckets in memory. Limits are enforced per instance
+// of the API, so a shared store is needed to enforce them across several instances.
+type MemoryStore struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	maxBuckets int
+	now        func() time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:    make(map[string]*bucket),
+		maxBuckets: maxMemoryBuckets,
+		now:        time.Now,
+	}
+}
+
+// Take removes one token from the bucket identified by key, refilling it for the time
+// that has passed since it was last used.
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	now := s.now()
+	capacity := float64(limit.Requests)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		s.sweep(now)
+		b = &bucket{tokens: capacity, updatedAt: now, limit: limit}
+		s.buckets[key] = b
+	}
+
+	// Add the tokens earned since the last request, up to the bucket's capacity.
+	elapsed := now.Sub(b.updatedAt)
+	b.tokens += elapsed.Seconds() / limit.interval().Seconds()
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updatedAt = now
+	b.limit = limit
+
+	result := Result{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = durationForTokens(1-b.tokens, limit)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = durationForTokens(capacity-b.tokens, limit)
+
+	return result, nil
+}
+
+// sweep makes room for a new bucket once the store has reached its bound. Buckets that
+// have refilled completely are removed first, since a full bucket is indistinguishable from
+// a new one. If that is not enough, e.g. because many clients keep their buckets partly
+// drained, the least recently used buckets are evicted, which resets their limits. A tenth
+// of the bound is freed at once, so that the eviction is not repeated for every new key.
+// The caller must hold s.mu.
+func (s *MemoryStore) sweep(now time.Time) {
+	if len(s.buckets) < s.maxBuckets {
+		return
+	}
+
+	for key, b := range s.buckets {
+		if durationForTokens(float64(b.limit.Requests)-b.tokens, b.limit) <= now.Sub(b.updatedAt) {
+			delete(s.buckets, key)
+		}
+	}
+
+	target := s.maxBuckets - s.maxBuckets/10 - 1
+	if len(s.buckets) <= target {
+		return
+	}
+
+	keys := make([]string, 0, len(s.buckets))
+	for key := range s.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.buckets[keys[i]].updatedAt.Before(s.buckets[keys[j]].updatedAt)
+	})
+	for _, key := range keys[:len(keys)-target] {
+		delete(s.buckets, key)
+	}
+}
+
+// durationForTokens returns the time it takes to earn the given number of tokens.
+func durationForTokens(tokens float64, limit Limit) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+
+	return time.Duration(tokens * float64(limit.interval()))
+}
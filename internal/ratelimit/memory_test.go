@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for the in-memory store.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestStore returns an empty store holding at most maxBuckets buckets, on a fake clock.
+func newTestStore(maxBuckets int) (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.maxBuckets = maxBuckets
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	ctx := context.Background()
+	// One token every 10 seconds, with bursts of up to 3.
+	limit := Limit{Requests: 3, Period: 30 * time.Second}
+
+	tests := []struct {
+		name string
+		// wait is the time to let pass before each request.
+		wait           []time.Duration
+		wantAllowed    bool
+		wantRemaining  int
+		wantReset      time.Duration
+		wantRetryAfter time.Duration
+	}{
+		{name: "first request", wait: []time.Duration{0}, wantAllowed: true, wantRemaining: 2, wantReset: 10 * time.Second},
+		{name: "burst", wait: []time.Duration{0, 0, 0}, wantAllowed: true, wantRemaining: 0, wantReset: 30 * time.Second},
+		{name: "bucket empty", wait: []time.Duration{0, 0, 0, 0}, wantRemaining: 0, wantReset: 30 * time.Second, wantRetryAfter: 10 * time.Second},
+		{name: "partly refilled", wait: []time.Duration{0, 0, 0, 0, 4 * time.Second}, wantRemaining: 0, wantReset: 26 * time.Second, wantRetryAfter: 6 * time.Second},
+		{name: "one token refilled", wait: []time.Duration{0, 0, 0, 10 * time.Second}, wantAllowed: true, wantRemaining: 0, wantReset: 30 * time.Second},
+		{name: "refill stops at capacity", wait: []time.Duration{0, time.Hour}, wantAllowed: true, wantRemaining: 2, wantReset: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, clock := newTestStore(maxMemoryBuckets)
+
+			var result Result
+			for _, wait := range tt.wait {
+				clock.Advance(wait)
+				var err error
+				if result, err = store.Take(ctx, "client", limit); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := Result{Allowed: tt.wantAllowed, Remaining: tt.wantRemaining, Reset: tt.wantReset, RetryAfter: tt.wantRetryAfter}
+			if result != want {
+				t.Errorf("Take = %+v, want %+v", result, want)
+			}
+		})
+	}
+
+	t.Run("clients have separate buckets", func(t *testing.T) {
+		store, _ := newTestStore(maxMemoryBuckets)
+		for i := 0; i < limit.Requests; i++ {
+			if _, err := store.Take(ctx, "a", limit); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if result, _ := store.Take(ctx, "b", limit); !result.Allowed {
+			t.Error("request of another client was refused")
+		}
+	})
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Requests: 2, Period: time.Minute}
+	const maxBuckets = 10
+
+	// fill takes a token for each of the keys "0" to "n-1", one second apart.
+	fill := func(t *testing.T, store *MemoryStore, clock *fakeClock, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			clock.Advance(time.Second)
+			if _, err := store.Take(ctx, strconv.Itoa(i), limit); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("refilled buckets are dropped first", func(t *testing.T) {
+		store, clock := newTestStore(maxBuckets)
+		fill(t, store, clock, maxBuckets)
+		// Key 0 is drained, while the others have refilled by the time the store is full.
+		clock.Advance(time.Minute)
+		store.Take(ctx, "0", limit)
+		store.Take(ctx, "0", limit)
+
+		store.Take(ctx, "new", limit)
+
+		if len(store.buckets) != 2 {
+			t.Errorf("store holds %d buckets, want the drained and the new one", len(store.buckets))
+		}
+		if result, _ := store.Take(ctx, "0", limit); result.Allowed {
+			t.Error("the drained bucket was reset")
+		}
+	})
+
+	t.Run("least recently used buckets are evicted", func(t *testing.T) {
+		store, clock := newTestStore(maxBuckets)
+		// Draining every bucket ensures that none of them has refilled.
+		for i := 0; i < limit.Requests; i++ {
+			fill(t, store, clock, maxBuckets)
+		}
+
+		store.Take(ctx, "new", limit)
+
+		// A tenth of the bound is freed, besides the room for the new bucket.
+		if want := maxBuckets - maxBuckets/10; len(store.buckets) != want {
+			t.Errorf("store holds %d buckets, want %d", len(store.buckets), want)
+		}
+		for _, key := range []string{"0", "1"} {
+			if _, ok := store.buckets[key]; ok {
+				t.Errorf("least recently used bucket %s was kept", key)
+			}
+		}
+		for _, key := range []string{"2", strconv.Itoa(maxBuckets - 1), "new"} {
+			if _, ok := store.buckets[key]; !ok {
+				t.Errorf("bucket %s was evicted", key)
+			}
+		}
+	})
+
+	t.Run("no eviction below the bound", func(t *testing.T) {
+		store, clock := newTestStore(maxBuckets)
+		fill(t, store, clock, maxBuckets-1)
+		clock.Advance(time.Hour)
+
+		store.Take(ctx, "new", limit)
+
+		if len(store.buckets) != maxBuckets {
+			t.Errorf("store holds %d buckets, want %d", len(store.buckets), maxBuckets)
+		}
+	})
+}
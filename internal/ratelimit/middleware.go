@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+)
+
+// KeyFunc identifies the client a request is counted against.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests against the client's IP address.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests against the user ID set by the AuthMiddleware, falling back to
+// the client's IP address for unauthenticated requests.
+// Middleware using it should be registered *after* the AuthMiddleware.
+func ByUser(c *gin.Context) string {
+	if userID := c.GetString("userID"); userID != "" {
+		return "user:" + userID
+	}
+
+	return ByIP(c)
+}
+
+// Middleware creates a gin middleware that allows each client limit.Requests requests
+// per limit.Period, with bursts of up to limit.Requests. The name separates the buckets
+// of different route groups, and identify selects the client a request is counted against.
+// Every response carries RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers; rejected requests receive 429 Too Many Requests and Retry-After.
+// If the store fails, the request is allowed through rather than taking the API down.
+func Middleware(store Store, name string, limit Limit, identify KeyFunc) gin.HandlerFunc {
+	if store == nil || limit.Requests <= 0 || limit.Period <= 0 {
+		panic("ratelimit: Middleware requires a store and a positive limit")
+	}
+	policy := strconv.Itoa(limit.Requests) + ";w=" + strconv.FormatInt(ceilSeconds(limit.Period), 10)
+
+	return func(c *gin.Context) {
+		result, err := store.Take(c.Request.Context(), name+":"+identify(c), limit)
+		if err != nil {
+			log.Printf("Error checking rate limit: %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+		c.Header("RateLimit-Policy", policy)
+
+		if !result.Allowed {
+			apiErr := apierror.NewTooManyRequestsError("Rate limit exceeded, please try again later", result.RetryAfter)
+			apierror.Abort(c, apiErr)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ceilSeconds converts a duration to whole seconds, rounding up so that clients never retry too early.
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
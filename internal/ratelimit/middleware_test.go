@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingStore is a Store that is unavailable.
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("store unavailable")
+}
+
+// newTestRouter returns a router with the middleware on GET /, which responds 200 OK.
+// The X-User header stands in for the user ID set by the AuthMiddleware.
+func newTestRouter(store Store, limit Limit, identify KeyFunc) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if userID := c.GetHeader("X-User"); userID != "" {
+			c.Set("userID", userID)
+		}
+	})
+	r.Use(Middleware(store, "test", limit, identify))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+// send makes a GET / request from the given IP address and user, if any.
+func send(r *gin.Engine, ip, userID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = ip + ":1234"
+	if userID != "" {
+		req.Header.Set("X-User", userID)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limit := Limit{Requests: 2, Period: time.Minute}
+
+	t.Run("headers and rejection", func(t *testing.T) {
+		store, _ := newTestStore(maxMemoryBuckets)
+		r := newTestRouter(store, limit, ByIP)
+
+		tests := []struct {
+			wantStatus     int
+			wantRemaining  string
+			wantReset      string
+			wantRetryAfter string
+		}{
+			{wantStatus: http.StatusOK, wantRemaining: "1", wantReset: "30"},
+			{wantStatus: http.StatusOK, wantRemaining: "0", wantReset: "60"},
+			{wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantReset: "60", wantRetryAfter: "30"},
+		}
+
+		for i, tt := range tests {
+			w := send(r, "192.0.2.1", "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("request %d: status = %d, want %d", i, w.Code, tt.wantStatus)
+			}
+			headers := map[string]string{
+				"RateLimit-Limit":     "2",
+				"RateLimit-Remaining": tt.wantRemaining,
+				"RateLimit-Reset":     tt.wantReset,
+				"RateLimit-Policy":    "2;w=60",
+				"Retry-After":         tt.wantRetryAfter,
+			}
+			for name, want := range headers {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("request %d: %s = %q, want %q", i, name, got, want)
+				}
+			}
+		}
+	})
+
+	t.Run("clients are identified by IP", func(t *testing.T) {
+		store, _ := newTestStore(maxMemoryBuckets)
+		r := newTestRouter(store, limit, ByIP)
+		for i := 0; i < limit.Requests; i++ {
+			send(r, "192.0.2.1", "ada")
+		}
+
+		if w := send(r, "192.0.2.1", "grace"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("another user of the same IP got %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if w := send(r, "192.0.2.2", ""); w.Code != http.StatusOK {
+			t.Errorf("another IP got %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("clients are identified by user", func(t *testing.T) {
+		store, _ := newTestStore(maxMemoryBuckets)
+		r := newTestRouter(store, limit, ByUser)
+		for i := 0; i < limit.Requests; i++ {
+			send(r, "192.0.2.1", "ada")
+		}
+
+		if w := send(r, "192.0.2.2", "ada"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("same user from another IP got %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if w := send(r, "192.0.2.1", "grace"); w.Code != http.StatusOK {
+			t.Errorf("another user got %d, want %d", w.Code, http.StatusOK)
+		}
+		// Unauthenticated requests fall back to the IP address.
+		if w := send(r, "192.0.2.1", ""); w.Code != http.StatusOK {
+			t.Errorf("unauthenticated request got %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("store failures let requests through", func(t *testing.T) {
+		r := newTestRouter(failingStore{}, limit, ByIP)
+
+		w := send(r, "192.0.2.1", "")
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "" {
+			t.Errorf("RateLimit-Limit = %q, want no header", got)
+		}
+	})
+}
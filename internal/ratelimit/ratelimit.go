@@ -0,0 +1,97 @@
+// Package ratelimit provides token-bucket rate limiting for Gin routes.
+// Buckets are kept in a Store, so that limits can be shared between instances of the
+// API by plugging in a shared store instead of the in-memory one.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit describes a token bucket that holds up to Requests tokens and is refilled at a
+// rate of Requests per Period. Each request takes one token, so clients may burst up to
+// Requests at once and sustain Requests per Period afterwards.
+type Limit struct {
+	// Requests is the bucket capacity and the number of tokens added per Period.
+	Requests int
+
+	// Period is the time it takes to refill an empty bucket.
+	Period time.Duration
+}
+
+// String formats the limit as "requests/period", the same form accepted by ParseLimit.
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// interval returns the time it takes to add a single token to the bucket.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	// Allowed reports whether a token was available and the request may proceed.
+	Allowed bool
+
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+
+	// RetryAfter is the time until the next token is available. It is zero if the request was allowed.
+	RetryAfter time.Duration
+}
+
+// Store holds token buckets. Take removes one token from the bucket identified by key,
+// creating a full bucket for the limit if none exists. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// ParseLimit parses a limit in the form "requests/period", e.g. "100/1m".
+func ParseLimit(value string) (Limit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected requests/period", value)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive integer", value)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: period must be a positive duration", value)
+	}
+
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// LimitFromEnv reads a limit from an environment variable in the form accepted by ParseLimit.
+// It returns the fallback if the variable is unset or invalid, and false if it is set to "off".
+func LimitFromEnv(key string, fallback Limit) (Limit, bool) {
+	value := strings.TrimSpace(os.Getenv(key))
+	switch {
+	case value == "":
+		return fallback, true
+	case strings.EqualFold(value, "off"):
+		return Limit{}, false
+	}
+
+	limit, err := ParseLimit(value)
+	if err != nil {
+		log.Printf("Warning: %v in %s, using default of %s", err, key, fallback)
+		return fallback, true
+	}
+
+	return limit, true
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "100/1m", want: Limit{Requests: 100, Period: time.Minute}},
+		{value: " 5 / 30s ", want: Limit{Requests: 5, Period: 30 * time.Second}},
+		{value: "100", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "ten/1m", wantErr: true},
+		{value: "10/-1m", wantErr: true},
+		{value: "10/minute", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLimit(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimit error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLimit = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeyCacheTTL is how long an API key read from Firestore is cached in memory.
+// Revocations made by another instance of the API take up to this long to be observed.
+const apiKeyCacheTTL = 30 * time.Second
+
+// maxAPIKeyCacheEntries bounds the in-memory cache before expired entries are swept.
+const maxAPIKeyCacheEntries = 10000
+
+// APIKeyRepository defines the interface for API key data operations.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key model.APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*model.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, revokedBy string, revokedAt time.Time) (*model.APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// cachedAPIKey is an API key held in the in-memory cache until cachedUntil.
+type cachedAPIKey struct {
+	key         model.APIKey
+	cachedUntil time.Time
+}
+
+// apiKeyRepository is the concrete implementation of APIKeyRepository.
+// It persists keys in the "api_keys" collection and keeps an in-memory cache so that the
+// AuthMiddleware does not need a database read on every request.
+type apiKeyRepository struct {
+	client *firestore.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey
+}
+
+// NewAPIKeyRepository creates a new instance of the API key repository.
+func NewAPIKeyRepository(client *firestore.Client) APIKeyRepository {
+	return &apiKeyRepository{
+		client: client,
+		cache:  make(map[string]cachedAPIKey),
+	}
+}
+
+// CreateAPIKey stores a new API key, using its ID as the document ID.
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key model.APIKey) error {
+	if _, err := r.client.Collection("api_keys").Doc(key.ID).Create(ctx, key); err != nil {
+		log.Printf("Error creating API key in database: %v", err)
+		return apierror.NewInternalServerError("Failed to create API key")
+	}
+
+	return nil
+}
+
+// GetAPIKey retrieves an API key by its ID, from the cache if it was read recently.
+func (r *apiKeyRepository) GetAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	if cached, ok := r.cache[id]; ok && now.Before(cached.cachedUntil) {
+		r.mu.Unlock()
+		key := cached.key
+		return &key, nil
+	}
+	r.mu.Unlock()
+
+	docSnap, err := r.client.Collection("api_keys").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, apierror.NewNotFoundError("API key not found").WithCode(apierror.CodeAPIKeyNotFound)
+		}
+
+		log.Printf("Error getting API key from database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to retrieve API key")
+	}
+
+	key, err := apiKeyFromSnapshot(docSnap)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cacheKey(*key, now)
+	return key, nil
+}
+
+// ListAPIKeys retrieves every API key, including expired and revoked ones, newest first.
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	iter := r.client.Collection("api_keys").OrderBy("created_at", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+
+	keys := []model.APIKey{}
+	for {
+		docSnap, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			log.Printf("Error listing API keys: %v", err)
+			return nil, apierror.NewInternalServerError("Failed to retrieve API keys")
+		}
+
+		key, err := apiKeyFromSnapshot(docSnap)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked by revokedBy and returns it. Revoking a key
+// that is already revoked leaves the original revocation in place.
+func (r *apiKeyRepository) RevokeAPIKey(ctx context.Context, id, revokedBy string, revokedAt time.Time) (*model.APIKey, error) {
+	ref := r.client.Collection("api_keys").Doc(id)
+	var revoked *model.APIKey
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return apierror.NewNotFoundError("API key not found").WithCode(apierror.CodeAPIKeyNotFound)
+			}
+			return err
+		}
+
+		key, err := apiKeyFromSnapshot(docSnap)
+		if err != nil {
+			return err
+		}
+
+		revoked = key
+		if key.RevokedAt != nil {
+			return nil
+		}
+
+		key.RevokedAt = &revokedAt
+		key.RevokedBy = revokedBy
+		return tx.Update(ref, []firestore.Update{
+			{Path: "revoked_at", Value: revokedAt},
+			{Path: "revoked_by", Value: revokedBy},
+		})
+	})
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error revoking API key in database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to revoke API key")
+	}
+
+	r.cacheKey(*revoked, time.Now())
+	return revoked, nil
+}
+
+// TouchAPIKey records that the API key was used at usedAt.
+func (r *apiKeyRepository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := r.client.Collection("api_keys").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "last_used_at", Value: usedAt},
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return apierror.NewNotFoundError("API key not found").WithCode(apierror.CodeAPIKeyNotFound)
+		}
+
+		log.Printf("Error recording API key use in database: %v", err)
+		return apierror.NewInternalServerError("Failed to update API key")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.cache[id]; ok {
+		cached.key.LastUsedAt = &usedAt
+		r.cache[id] = cached
+	}
+	return nil
+}
+
+// cacheKey stores a copy of key in the cache, sweeping expired entries once the cache
+// grows past its bound.
+func (r *apiKeyRepository) cacheKey(key model.APIKey, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.cache) > maxAPIKeyCacheEntries {
+		for id, cached := range r.cache {
+			if now.After(cached.cachedUntil) {
+				delete(r.cache, id)
+			}
+		}
+	}
+	r.cache[key.ID] = cachedAPIKey{key: key, cachedUntil: now.Add(apiKeyCacheTTL)}
+}
+
+// apiKeyFromSnapshot converts a document from the "api_keys" collection to an APIKey.
+func apiKeyFromSnapshot(docSnap *firestore.DocumentSnapshot) (*model.APIKey, error) {
+	var key model.APIKey
+	if err := docSnap.DataTo(&key); err != nil {
+		log.Printf("Error converting API key data: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process API key data")
+	}
+
+	key.ID = docSnap.Ref.ID
+	return &key, nil
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EmailBackfillResult summarizes a run of BackfillEmailIndex.
+type EmailBackfillResult struct {
+	// Scanned is the number of user documents examined.
+	Scanned int
+
+	// Normalized is the number of users whose stored email was rewritten in normalized form.
+	Normalized int
+
+	// Indexed is the number of "emails" index entries created.
+	Indexed int
+
+	// Conflicts lists the IDs of users whose address is already claimed by another user.
+	// They are left unchanged and must be resolved by hand.
+	Conflicts []string
+}
+
+// BackfillEmailIndex brings users created before emails were normalized and indexed in
+// line with the current schema: each user's email is normalized, and an "emails" index
+// entry is created for it if none exists. Each user is updated in its own transaction,
+// so the backfill can be interrupted and run again safely.
+func BackfillEmailIndex(ctx context.Context, client *firestore.Client) (*EmailBackfillResult, error) {
+	repo := &userRepository{client: client}
+	result := &EmailBackfillResult{}
+
+	iter := client.Collection("users").Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to list users: %w", err)
+		}
+
+		result.Scanned++
+		if err := repo.backfillUserEmail(ctx, doc.Ref, result); err != nil {
+			var apiErr *apierror.APIError
+			if errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict {
+				log.Printf("Email of user %s is already claimed by another user, skipping", doc.Ref.ID)
+				result.Conflicts = append(result.Conflicts, doc.Ref.ID)
+				continue
+			}
+			return result, fmt.Errorf("failed to backfill user %s: %w", doc.Ref.ID, err)
+		}
+	}
+
+	return result, nil
+}
+
+// backfillUserEmail normalizes and indexes the email of a single user in a transaction.
+func (r *userRepository) backfillUserEmail(ctx context.Context, docRef *firestore.DocumentRef, result *EmailBackfillResult) error {
+	var normalized, indexed bool
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		normalized, indexed = false, false
+
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				// Deleted since it was listed.
+				return nil
+			}
+			return err
+		}
+
+		stored, _ := docSnap.Data()["email"].(string)
+		email := model.NormalizeEmail(stored)
+		if email == "" {
+			return nil
+		}
+
+		indexSnap, err := tx.Get(r.emailIndexRef(email))
+		switch {
+		case err == nil:
+			if owner, _ := indexSnap.Data()["user_id"].(string); owner != docRef.ID {
+				return apierror.NewConflictError("A user with this email already exists").WithCode(apierror.CodeEmailTaken)
+			}
+		case status.Code(err) == codes.NotFound:
+			indexed = true
+		default:
+			return err
+		}
+
+		if email != stored {
+			normalized = true
+			if err := tx.Update(docRef, []firestore.Update{{Path: "email", Value: email}}); err != nil {
+				return err
+			}
+		}
+		if indexed {
+			return tx.Set(r.emailIndexRef(email), map[string]interface{}{"user_id": docRef.ID})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if normalized {
+		result.Normalized++
+	}
+	if indexed {
+		result.Indexed++
+	}
+	return nil
+}
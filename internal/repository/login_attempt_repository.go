@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LoginAttemptRepository defines the interface for failed login counter data operations.
+// Counters are identified by an opaque key, such as a hash of an email address or IP address.
+type LoginAttemptRepository interface {
+	GetLoginAttempts(ctx context.Context, key string) (*model.LoginAttempts, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*model.LoginAttempts, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// loginAttemptRepository is the concrete implementation of LoginAttemptRepository that interacts with Firestore.
+type loginAttemptRepository struct {
+	client *firestore.Client
+}
+
+// NewLoginAttemptRepository creates a new instance of the login attempt repository.
+func NewLoginAttemptRepository(client *firestore.Client) LoginAttemptRepository {
+	return &loginAttemptRepository{client: client}
+}
+
+// GetLoginAttempts retrieves the failure counter for a key from the "login_attempts" collection.
+// A key without recorded failures returns an empty counter.
+func (r *loginAttemptRepository) GetLoginAttempts(ctx context.Context, key string) (*model.LoginAttempts, error) {
+	docSnap, err := r.client.Collection("login_attempts").Doc(key).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &model.LoginAttempts{}, nil
+		}
+
+		log.Printf("Error getting login attempts from database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process login")
+	}
+
+	var attempts model.LoginAttempts
+	if err := docSnap.DataTo(&attempts); err != nil {
+		log.Printf("Error converting login attempts data: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process login")
+	}
+
+	return &attempts, nil
+}
+
+// RecordLoginFailure increments the failure counter for a key in a single transaction and
+// returns the updated counter. Failures older than window are forgotten before counting.
+func (r *loginAttemptRepository) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*model.LoginAttempts, error) {
+	ref := r.client.Collection("login_attempts").Doc(key)
+	var attempts model.LoginAttempts
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		attempts = model.LoginAttempts{}
+
+		docSnap, err := tx.Get(ref)
+		switch {
+		case err == nil:
+			if err := docSnap.DataTo(&attempts); err != nil {
+				return err
+			}
+		case status.Code(err) != codes.NotFound:
+			return err
+		}
+
+		now := time.Now().UTC()
+		if now.Sub(attempts.LastFailureAt) > window {
+			attempts.Failures = 0
+		}
+		attempts.Failures++
+		attempts.LastFailureAt = now
+
+		return tx.Set(ref, attempts)
+	})
+	if err != nil {
+		log.Printf("Error recording login failure: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process login")
+	}
+
+	return &attempts, nil
+}
+
+// ResetLoginAttempts clears the failure counter for a key, e.g. after a successful login.
+func (r *loginAttemptRepository) ResetLoginAttempts(ctx context.Context, key string) error {
+	if _, err := r.client.Collection("login_attempts").Doc(key).Delete(ctx); err != nil {
+		log.Printf("Error resetting login attempts: %v", err)
+		return apierror.NewInternalServerError("Failed to process login")
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MagicLinkRepository defines the interface for passwordless login token data operations.
+type MagicLinkRepository interface {
+	CreateMagicLink(ctx context.Context, link model.MagicLink) error
+	ConsumeMagicLink(ctx context.Context, id string) (*model.MagicLink, error)
+}
+
+// magicLinkRepository is the concrete implementation of MagicLinkRepository that interacts with Firestore.
+type magicLinkRepository struct {
+	client *firestore.Client
+}
+
+// NewMagicLinkRepository creates a new instance of the magic link repository.
+func NewMagicLinkRepository(client *firestore.Client) MagicLinkRepository {
+	return &magicLinkRepository{client: client}
+}
+
+// CreateMagicLink stores a new magic link in the "magic_links" collection,
+// using the token hash as the document ID.
+func (r *magicLinkRepository) CreateMagicLink(ctx context.Context, link model.MagicLink) error {
+	if _, err := r.client.Collection("magic_links").Doc(link.ID).Create(ctx, link); err != nil {
+		log.Printf("Error creating magic link in database: %v", err)
+		return apierror.NewInternalServerError("Failed to create login link")
+	}
+
+	return nil
+}
+
+// ConsumeMagicLink marks the magic link identified by id (the token hash) as used in a
+// single transaction, so that each link can only be exchanged once.
+// It returns a 401 APIError if the link does not exist, was already used or has expired.
+func (r *magicLinkRepository) ConsumeMagicLink(ctx context.Context, id string) (*model.MagicLink, error) {
+	invalidLink := apierror.NewUnauthorizedError("Invalid or expired login link").WithCode(apierror.CodeInvalidToken)
+	ref := r.client.Collection("magic_links").Doc(id)
+	var link model.MagicLink
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return invalidLink
+			}
+			return err
+		}
+
+		if err := docSnap.DataTo(&link); err != nil {
+			return err
+		}
+		link.ID = docSnap.Ref.ID
+
+		if link.Used || time.Now().After(link.ExpiresAt) {
+			return invalidLink
+		}
+
+		return tx.Update(ref, []firestore.Update{{Path: "used", Value: true}})
+	})
+
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error consuming magic link: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process login link")
+	}
+
+	return &link, nil
+}
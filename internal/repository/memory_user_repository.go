@@ -0,0 +1,649 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// memoryUserRepository is an implementation of UserRepository that keeps users in memory,
+// with the same semantics and errors as the Firestore implementation, e.g. for tests.
+// It is safe for concurrent use; every method runs atomically, like a transaction.
+type memoryUserRepository struct {
+	mu sync.Mutex
+	// users maps a user ID to the stored user.
+	users map[string]model.User
+	// emails maps a normalized email address to the ID of the user who claimed it,
+	// like the "emails" index collection.
+	emails map[string]string
+	// identities maps an external identity's document ID to the identity.
+	identities map[string]model.Identity
+	// usedTokens holds the IDs of the email verification tokens that were used.
+	usedTokens map[string]time.Time
+	// auditLogs holds the audit log entries in the order they were recorded.
+	auditLogs []model.AuditLog
+}
+
+// NewMemoryUserRepository creates an empty in-memory user repository.
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{
+		users:      make(map[string]model.User),
+		emails:     make(map[string]string),
+		identities: make(map[string]model.Identity),
+		usedTokens: make(map[string]time.Time),
+	}
+}
+
+// CreateUser stores a new user with a random ID, claiming their email address.
+// A conflict error is returned if the address is already taken.
+func (r *memoryUserRepository) CreateUser(_ context.Context, user model.User) (*model.User, error) {
+	return r.createUser(user, nil, nil)
+}
+
+// CreateUserWithIdentity creates a new user linked to an external identity.
+// It returns a 409 APIError if the email address is taken or the identity is already linked.
+func (r *memoryUserRepository) CreateUserWithIdentity(_ context.Context, user model.User, identity model.Identity) (*model.User, error) {
+	return r.createUser(user, &identity, nil)
+}
+
+// CreateUserWithAudit creates a new user and records the audit log entry, whose target
+// and time are set to the new user and its creation time.
+func (r *memoryUserRepository) CreateUserWithAudit(_ context.Context, user model.User, entry model.AuditLog) (*model.User, error) {
+	return r.createUser(user, nil, &entry)
+}
+
+// createUser creates a new user, claiming their email address and, if given, linking an
+// external identity and recording an audit log entry.
+func (r *memoryUserRepository) createUser(user model.User, identity *model.Identity, entry *model.AuditLog) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := newDocumentID()
+	if err := r.checkEmailAvailable(user.Email, id); err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		if _, linked := r.identities[identityKey(identity.Provider, identity.Subject)]; linked {
+			return nil, apierror.NewConflictError("External account is already linked to a user").WithCode(apierror.CodeIdentityAlreadyLinked)
+		}
+	}
+
+	now := time.Now().UTC()
+	user.ID = id
+	// The plaintext password is never stored.
+	user.Password = ""
+	user.Status = model.UserStatusActive
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.LastLoginAt = nil
+	user.SuspendedAt = nil
+	user.DeletedAt = nil
+	r.users[id] = cloneUser(user)
+	r.emails[model.NormalizeEmail(user.Email)] = id
+
+	if identity != nil {
+		link := *identity
+		link.UserID = id
+		link.CreatedAt = now
+		r.identities[identityKey(link.Provider, link.Subject)] = link
+	}
+
+	if entry != nil {
+		audit := *entry
+		audit.TargetID = id
+		audit.CreatedAt = now
+		r.recordAudit(audit)
+	}
+
+	return r.getUser(id)
+}
+
+// GetUser retrieves a single user by their ID.
+func (r *memoryUserRepository) GetUser(_ context.Context, id string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.getUser(id)
+}
+
+// getUser returns a copy of the stored user, or a not found error.
+// The caller must hold the lock.
+func (r *memoryUserRepository) getUser(id string) (*model.User, error) {
+	stored, ok := r.users[id]
+	if !ok {
+		return nil, apierror.NewNotFoundError("User with ID '" + id + "' not found").WithCode(apierror.CodeUserNotFound)
+	}
+
+	user := cloneUser(stored)
+	setDefaultStatus(&user)
+	return &user, nil
+}
+
+// GetUserByEmail retrieves a single user by their email address, read through the email
+// index. The address must already be normalized.
+func (r *memoryUserRepository) GetUserByEmail(_ context.Context, email string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notFound := apierror.NewNotFoundError("User with email '" + email + "' not found").WithCode(apierror.CodeUserNotFound)
+
+	userID, ok := r.emails[model.NormalizeEmail(email)]
+	if !ok {
+		return nil, notFound
+	}
+
+	user, err := r.getUser(userID)
+	if err != nil {
+		return nil, notFound
+	}
+
+	return user, nil
+}
+
+// GetAllUsers retrieves one page of users, applying the filters, sort order and cursor
+// from the query like the Firestore query does. Deleted users are left out unless the
+// query includes them. The query's Limit must be set by the caller.
+func (r *memoryUserRepository) GetAllUsers(_ context.Context, query model.UserListQuery) (*model.UserPage, error) {
+	var after []interface{}
+	if query.Cursor != "" {
+		values, err := decodeUserCursor(query.Cursor, query.Sort)
+		if err != nil {
+			return nil, apierror.NewBadRequestError("Invalid cursor").WithCode(apierror.CodeInvalidCursor)
+		}
+		after = values
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]model.User, 0, len(r.users))
+	for _, stored := range r.users {
+		user := cloneUser(stored)
+		setDefaultStatus(&user)
+
+		if !query.IncludeDeleted && user.Status == model.UserStatusDeleted {
+			continue
+		}
+		if query.Role != "" && user.Role != query.Role {
+			continue
+		}
+		if query.Email != "" && user.Email != query.Email {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	// Order by the sort field, then by ID, like the OrderBy clauses of the Firestore query.
+	sort.Slice(users, func(i, j int) bool {
+		return compareSortKeys(sortKey(users[i], query.Sort), sortKey(users[j], query.Sort)) < 0
+	})
+
+	if after != nil {
+		start := sort.Search(len(users), func(i int) bool {
+			return compareSortKeys(sortKey(users[i], query.Sort), after) > 0
+		})
+		users = users[start:]
+	}
+
+	// Fetch one extra user, like the Firestore query, to find out whether another page follows.
+	if len(users) > query.Limit+1 {
+		users = users[:query.Limit+1]
+	}
+
+	page := &model.UserPage{Items: users}
+	if len(users) > query.Limit {
+		page.Items = users[:query.Limit]
+		page.NextCursor = encodeUserCursor(page.Items[query.Limit-1], query.Sort)
+	}
+
+	return page, nil
+}
+
+// sortKey returns the values a user is ordered by for the given sort field, in the form
+// returned by decodeUserCursor.
+func sortKey(user model.User, field string) []interface{} {
+	switch field {
+	case "name":
+		return []interface{}{user.Name, user.ID}
+	case "email":
+		return []interface{}{user.Email, user.ID}
+	case "created_at":
+		return []interface{}{user.CreatedAt, user.ID}
+	default:
+		return []interface{}{user.ID}
+	}
+}
+
+// compareSortKeys compares two sort keys for the same sort field, returning a negative
+// number, zero or a positive number if a comes before, at or after b.
+func compareSortKeys(a, b []interface{}) int {
+	for i := range a {
+		var c int
+		switch value := a[i].(type) {
+		case time.Time:
+			c = value.Compare(b[i].(time.Time))
+		case string:
+			c = strings.Compare(value, b[i].(string))
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// UpdateUser replaces the profile fields and metadata of an existing user.
+// Empty optional fields are cleared. The password hash is left untouched.
+// It returns a not found error if the user does not exist.
+func (r *memoryUserRepository) UpdateUser(_ context.Context, user model.User) (*model.User, error) {
+	return r.updateUser(user.ID, func(stored *model.User) {
+		stored.Name = user.Name
+		stored.Email = user.Email
+		stored.Role = user.Role
+		stored.DisplayName = user.DisplayName
+		stored.AvatarURL = user.AvatarURL
+		stored.Phone = user.Phone
+		stored.Locale = user.Locale
+		stored.Timezone = user.Timezone
+		stored.Metadata = nonEmptyMetadata(user.Metadata)
+	}, &user.Email)
+}
+
+// PatchUser updates only the fields supplied in the patch on an existing user.
+// It returns the user as stored after the update.
+func (r *memoryUserRepository) PatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error) {
+	changed := patch.Name != nil || patch.Email != nil || patch.Role != nil ||
+		patch.DisplayName != nil || patch.AvatarURL != nil || patch.Phone != nil ||
+		patch.Locale != nil || patch.Timezone != nil || patch.Metadata != nil
+
+	// Nothing to change, so simply return the current state of the user.
+	if !changed {
+		return r.GetUser(ctx, id)
+	}
+
+	return r.updateUser(id, func(stored *model.User) {
+		setIfSupplied(&stored.Name, patch.Name)
+		setIfSupplied(&stored.Email, patch.Email)
+		if patch.Role != nil {
+			stored.Role = *patch.Role
+		}
+		setIfSupplied(&stored.DisplayName, patch.DisplayName)
+		setIfSupplied(&stored.AvatarURL, patch.AvatarURL)
+		setIfSupplied(&stored.Phone, patch.Phone)
+		setIfSupplied(&stored.Locale, patch.Locale)
+		setIfSupplied(&stored.Timezone, patch.Timezone)
+		if patch.Metadata != nil {
+			stored.Metadata = nonEmptyMetadata(patch.Metadata)
+		}
+	}, patch.Email)
+}
+
+// setIfSupplied sets the field to the supplied value, if any.
+func setIfSupplied(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+// nonEmptyMetadata returns a copy of the metadata, or nil if it is empty, which is how
+// metadata removed from a Firestore document is read back.
+func nonEmptyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if len(metadata) == 0 {
+		return nil
+	}
+	return cloneMetadata(metadata)
+}
+
+// updateUser applies update to a stored user. If newEmail is not nil and differs from the
+// stored email, the email index entry is moved as well and the address is marked as
+// unverified, and a conflict error is returned if it belongs to another user.
+func (r *memoryUserRepository) updateUser(id string, update func(*model.User), newEmail *string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	emailChanged := newEmail != nil && *newEmail != current.Email
+	if emailChanged {
+		if err := r.checkEmailAvailable(*newEmail, id); err != nil {
+			return nil, err
+		}
+	}
+
+	updated := cloneUser(r.users[id])
+	update(&updated)
+	updated.UpdatedAt = time.Now().UTC()
+	if emailChanged {
+		// A new address has not been verified yet.
+		updated.Verified = false
+		if current.Email != "" {
+			delete(r.emails, model.NormalizeEmail(current.Email))
+		}
+		r.emails[model.NormalizeEmail(*newEmail)] = id
+	}
+	r.users[id] = updated
+
+	return r.getUser(id)
+}
+
+// DeleteUser soft-deletes a user, so that they can be restored until PurgeDeletedUsers
+// removes them. It returns a not found error if the user does not exist or is already deleted,
+// and a conflict error if the user is the last active user holding one of adminRoles.
+func (r *memoryUserRepository) DeleteUser(_ context.Context, id string, adminRoles []role.Role) error {
+	_, err := r.changeStatus(id, model.UserStatusDeleted, adminRoles, nil)
+	return err
+}
+
+// ChangeUserStatus suspends or restores a user and records the change in the audit log.
+// Only active users can be suspended, and only suspended or deleted users can be restored;
+// other changes return a conflict error, as does suspending the last active user holding
+// one of adminRoles. Users are deleted with DeleteUser.
+func (r *memoryUserRepository) ChangeUserStatus(_ context.Context, change model.StatusChange, adminRoles []role.Role) (*model.User, error) {
+	action, ok := statusAuditActions[change.NewStatus]
+	if !ok {
+		return nil, apierror.NewBadRequestError("Invalid status specified")
+	}
+
+	return r.changeStatus(change.UserID, change.NewStatus, adminRoles, &model.AuditLog{
+		Action:  action,
+		ActorID: change.ActorID,
+	})
+}
+
+// changeStatus moves a user to a new status, recording when they were suspended or
+// deleted, and, if given, the audit log entry with the old and new status. An active user
+// holding one of adminRoles can only be suspended or deleted if another active user holds
+// one of them.
+func (r *memoryUserRepository) changeStatus(id string, newStatus model.UserStatus, adminRoles []role.Role, entry *model.AuditLog) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatusTransition(id, current.Status, newStatus); err != nil {
+		return nil, err
+	}
+	if newStatus != model.UserStatusActive && isActiveAdmin(current, adminRoles) && r.activeAdmins(adminRoles) < 2 {
+		return nil, lastAdminError(statusActions[newStatus])
+	}
+
+	now := time.Now().UTC()
+	updated := cloneUser(r.users[id])
+	updated.Status = newStatus
+	updated.UpdatedAt = now
+	// Only the time of the current suspension or deletion is kept.
+	updated.SuspendedAt, updated.DeletedAt = nil, nil
+	switch newStatus {
+	case model.UserStatusSuspended:
+		updated.SuspendedAt = &now
+	case model.UserStatusDeleted:
+		updated.DeletedAt = &now
+	}
+	r.users[id] = updated
+
+	if entry != nil {
+		audit := *entry
+		audit.TargetID = id
+		audit.OldValue = string(current.Status)
+		audit.NewValue = string(newStatus)
+		audit.CreatedAt = now
+		r.recordAudit(audit)
+	}
+
+	return r.getUser(id)
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before deletedBefore, together
+// with their email index entries and external identity links. It returns the number of
+// users removed.
+func (r *memoryUserRepository) PurgeDeletedUsers(_ context.Context, deletedBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for id, user := range r.users {
+		if user.Status != model.UserStatusDeleted || user.DeletedAt == nil || !user.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+
+		delete(r.users, id)
+		for key, identity := range r.identities {
+			if identity.UserID == id {
+				delete(r.identities, key)
+			}
+		}
+		if user.Email != "" {
+			delete(r.emails, model.NormalizeEmail(user.Email))
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// RecordLogin sets the user's last login time to the current time.
+// It returns a not found error if the user does not exist.
+func (r *memoryUserRepository) RecordLogin(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.getUser(id); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	user := r.users[id]
+	user.LastLoginAt = &now
+	r.users[id] = user
+	return nil
+}
+
+// ChangeUserRole assigns a new role to a user and records the change in the audit log.
+// If the user is active and currently holds one of adminRoles and the new role is not one
+// of them, a conflict error is returned when no other active user holds an admin role.
+func (r *memoryUserRepository) ChangeUserRole(_ context.Context, change model.RoleChange, adminRoles []role.Role) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.getUser(change.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if isActiveAdmin(current, adminRoles) && !slices.Contains(adminRoles, change.NewRole) && r.activeAdmins(adminRoles) < 2 {
+		return nil, lastAdminError("demote")
+	}
+
+	now := time.Now().UTC()
+	user := r.users[change.UserID]
+	user.Role = change.NewRole
+	user.UpdatedAt = now
+	r.users[change.UserID] = user
+
+	r.recordAudit(model.AuditLog{
+		Action:    model.AuditActionRoleChanged,
+		ActorID:   change.ActorID,
+		TargetID:  change.UserID,
+		OldValue:  string(current.Role),
+		NewValue:  string(change.NewRole),
+		CreatedAt: now,
+	})
+
+	return r.getUser(change.UserID)
+}
+
+// activeAdmins returns the number of active users holding one of adminRoles.
+// The caller must hold the lock.
+func (r *memoryUserRepository) activeAdmins(adminRoles []role.Role) int {
+	admins := 0
+	for _, user := range r.users {
+		if isActiveAdmin(&user, adminRoles) {
+			admins++
+		}
+	}
+	return admins
+}
+
+// VerifyEmail marks a user's email address as verified and records the verification token,
+// so that each token works only once. It fails if the token was already used or the user's
+// address has changed since it was sent.
+func (r *memoryUserRepository) VerifyEmail(_ context.Context, verification model.EmailVerification) (*model.User, error) {
+	invalidToken := apierror.NewBadRequestError("Invalid or expired verification token").WithCode(apierror.CodeInvalidToken)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[verification.UserID]
+	if !ok || user.Email != verification.Email {
+		return nil, invalidToken
+	}
+	if _, used := r.usedTokens[verification.TokenID]; used {
+		return nil, invalidToken
+	}
+
+	r.usedTokens[verification.TokenID] = verification.ExpiresAt
+	user.Verified = true
+	user.UpdatedAt = time.Now().UTC()
+	r.users[verification.UserID] = user
+
+	return r.getUser(verification.UserID)
+}
+
+// GetUserByIdentity retrieves the user linked to an external identity.
+// It returns a 404 APIError if the identity is not linked to any user.
+func (r *memoryUserRepository) GetUserByIdentity(_ context.Context, provider, subject string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity, ok := r.identities[identityKey(provider, subject)]
+	if !ok {
+		return nil, apierror.NewNotFoundError("External account is not linked to a user")
+	}
+
+	return r.getUser(identity.UserID)
+}
+
+// LinkIdentity links an external identity, whose provider has verified identity.Email, to the
+// existing user with that address, and marks the address as verified. If it was not verified
+// yet, the user's password is removed, since whoever registered the address had not proven
+// they own it. It returns a 404 APIError if the user does not exist, and a 409 APIError if
+// the identity is already linked or the user's address is no longer identity.Email.
+func (r *memoryUserRepository) LinkIdentity(_ context.Context, identity model.Identity) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.getUser(identity.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIdentityEmail(*current, identity); err != nil {
+		return nil, err
+	}
+
+	key := identityKey(identity.Provider, identity.Subject)
+	if _, linked := r.identities[key]; linked {
+		return nil, apierror.NewConflictError("External account is already linked to a user").WithCode(apierror.CodeIdentityAlreadyLinked)
+	}
+
+	now := time.Now().UTC()
+	identity.CreatedAt = now
+	r.identities[key] = identity
+
+	if !current.Verified {
+		user := r.users[identity.UserID]
+		user.Verified = true
+		user.PasswordHash = ""
+		user.UpdatedAt = now
+		r.users[identity.UserID] = user
+	}
+
+	return r.getUser(identity.UserID)
+}
+
+// checkEmailAvailable returns a conflict error if the address is already claimed by a
+// user other than userID. The caller must hold the lock.
+func (r *memoryUserRepository) checkEmailAvailable(email, userID string) error {
+	if owner, ok := r.emails[model.NormalizeEmail(email)]; ok && owner != userID {
+		return apierror.NewConflictError("A user with this email already exists").WithCode(apierror.CodeEmailTaken)
+	}
+
+	return nil
+}
+
+// recordAudit stores an audit log entry with a new ID. The caller must hold the lock.
+func (r *memoryUserRepository) recordAudit(entry model.AuditLog) {
+	entry.ID = newDocumentID()
+	r.auditLogs = append(r.auditLogs, entry)
+}
+
+// identityKey returns the key of an external identity, like the document ID used by
+// the Firestore implementation.
+func identityKey(provider, subject string) string {
+	return url.PathEscape(provider) + ":" + url.PathEscape(subject)
+}
+
+// cloneUser returns a copy of the user that shares no maps or pointers with it.
+func cloneUser(user model.User) model.User {
+	user.Metadata = cloneMetadata(user.Metadata)
+	user.LastLoginAt = cloneTime(user.LastLoginAt)
+	user.SuspendedAt = cloneTime(user.SuspendedAt)
+	user.DeletedAt = cloneTime(user.DeletedAt)
+	return user
+}
+
+// cloneMetadata returns a copy of the metadata map. Metadata values are scalars, so a
+// shallow copy is enough.
+func cloneMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return nil
+	}
+
+	copied := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+// cloneTime returns a copy of the time pointer.
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	copied := *t
+	return &copied
+}
+
+// documentIDAlphabet holds the characters of automatically generated Firestore document IDs.
+const documentIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// newDocumentID returns a random 20-character ID, like those Firestore generates for new documents.
+func newDocumentID() string {
+	id := make([]byte, 20)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(documentIDAlphabet))))
+		if err != nil {
+			panic("repository: failed to generate document ID: " + err.Error())
+		}
+		id[i] = documentIDAlphabet[n.Int64()]
+	}
+
+	return string(id)
+}
@@ -0,0 +1,9 @@
+package repository
+
+import "testing"
+
+func TestMemoryUserRepository(t *testing.T) {
+	testUserRepository(t, func(*testing.T) UserRepository {
+		return NewMemoryUserRepository()
+	})
+}
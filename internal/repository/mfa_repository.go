@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MFARepository defines the interface for two-factor authentication data operations.
+type MFARepository interface {
+	GetMFA(ctx context.Context, userID string) (*model.MFA, error)
+	CreateMFAEnrollment(ctx context.Context, mfa model.MFA) error
+	EnableMFA(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	DeleteMFA(ctx context.Context, userID string) error
+}
+
+// mfaRepository is the concrete implementation of MFARepository that interacts with Firestore.
+type mfaRepository struct {
+	client *firestore.Client
+}
+
+// NewMFARepository creates a new instance of the MFA repository.
+func NewMFARepository(client *firestore.Client) MFARepository {
+	return &mfaRepository{client: client}
+}
+
+// invalidMFACode returns the error for a TOTP code or recovery code that is not accepted.
+func invalidMFACode() *apierror.APIError {
+	return apierror.NewUnauthorizedError("Invalid verification code").WithCode(apierror.CodeInvalidMFACode)
+}
+
+// GetMFA retrieves a user's MFA settings from the "mfa" collection.
+// It returns a 404 APIError if the user has never started enrollment.
+func (r *mfaRepository) GetMFA(ctx context.Context, userID string) (*model.MFA, error) {
+	docSnap, err := r.client.Collection("mfa").Doc(userID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, apierror.NewNotFoundError("MFA is not set up for this user")
+		}
+
+		log.Printf("Error getting MFA settings from database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to retrieve MFA settings")
+	}
+
+	var mfa model.MFA
+	if err := docSnap.DataTo(&mfa); err != nil {
+		log.Printf("Error converting MFA data: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process MFA settings")
+	}
+
+	mfa.UserID = docSnap.Ref.ID
+	return &mfa, nil
+}
+
+// CreateMFAEnrollment stores a pending enrollment, replacing any earlier pending one.
+// It returns a 409 APIError if MFA is already enabled for the user.
+func (r *mfaRepository) CreateMFAEnrollment(ctx context.Context, mfa model.MFA) error {
+	ref := r.client.Collection("mfa").Doc(mfa.UserID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(ref)
+		switch {
+		case err == nil:
+			if enabled, _ := docSnap.DataAt("enabled"); enabled == true {
+				return apierror.NewConflictError("MFA is already enabled").WithCode(apierror.CodeMFAAlreadyEnabled)
+			}
+		case status.Code(err) != codes.NotFound:
+			return err
+		}
+
+		return tx.Set(ref, mfa)
+	})
+
+	return mfaTransactionError(err, "Error creating MFA enrollment", "Failed to start MFA enrollment")
+}
+
+// EnableMFA confirms a pending enrollment with the time step of the code the user entered
+// and stores the hashes of their recovery codes.
+// It returns a 409 APIError if MFA is already enabled, and a 401 APIError if the step was already used.
+func (r *mfaRepository) EnableMFA(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	ref := r.client.Collection("mfa").Doc(userID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		mfa, err := getMFA(tx, ref)
+		if err != nil {
+			return err
+		}
+		if mfa.Enabled {
+			return apierror.NewConflictError("MFA is already enabled").WithCode(apierror.CodeMFAAlreadyEnabled)
+		}
+		if step <= mfa.LastUsedStep {
+			return invalidMFACode()
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "enabled", Value: true},
+			{Path: "last_used_step", Value: step},
+			{Path: "recovery_code_hashes", Value: recoveryCodeHashes},
+			{Path: "confirmed_at", Value: time.Now().UTC()},
+		})
+	})
+
+	return mfaTransactionError(err, "Error enabling MFA", "Failed to enable MFA")
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code. It returns a 401 APIError
+// if MFA is not enabled or a code from the same or a later step was already used.
+func (r *mfaRepository) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	ref := r.client.Collection("mfa").Doc(userID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		mfa, err := getMFA(tx, ref)
+		if err != nil {
+			return err
+		}
+		if !mfa.Enabled || step <= mfa.LastUsedStep {
+			return invalidMFACode()
+		}
+
+		return tx.Update(ref, []firestore.Update{{Path: "last_used_step", Value: step}})
+	})
+
+	return mfaTransactionError(err, "Error recording TOTP code", "Failed to verify code")
+}
+
+// UseRecoveryCode consumes the recovery code with the given hash. It returns a 401 APIError
+// if MFA is not enabled or the code is not one of the user's unused recovery codes.
+func (r *mfaRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	ref := r.client.Collection("mfa").Doc(userID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		mfa, err := getMFA(tx, ref)
+		if err != nil {
+			return err
+		}
+		if !mfa.Enabled {
+			return invalidMFACode()
+		}
+
+		for _, hash := range mfa.RecoveryCodeHashes {
+			if hash == codeHash {
+				return tx.Update(ref, []firestore.Update{{Path: "recovery_code_hashes", Value: firestore.ArrayRemove(hash)}})
+			}
+		}
+
+		return invalidMFACode()
+	})
+
+	return mfaTransactionError(err, "Error using recovery code", "Failed to verify code")
+}
+
+// DeleteMFA removes a user's MFA settings, disabling MFA.
+func (r *mfaRepository) DeleteMFA(ctx context.Context, userID string) error {
+	if _, err := r.client.Collection("mfa").Doc(userID).Delete(ctx); err != nil {
+		log.Printf("Error deleting MFA settings: %v", err)
+		return apierror.NewInternalServerError("Failed to disable MFA")
+	}
+
+	return nil
+}
+
+// getMFA reads a user's MFA settings within a transaction.
+// A user without MFA settings is treated as presenting an invalid code.
+func getMFA(tx *firestore.Transaction, ref *firestore.DocumentRef) (*model.MFA, error) {
+	docSnap, err := tx.Get(ref)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, invalidMFACode()
+		}
+		return nil, err
+	}
+
+	var mfa model.MFA
+	if err := docSnap.DataTo(&mfa); err != nil {
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// mfaTransactionError passes APIErrors returned from a transaction through unchanged and
+// logs any other error, replacing it with a 500 APIError.
+func mfaTransactionError(err error, logMessage, message string) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	log.Printf("%s: %v", logMessage, err)
+	return apierror.NewInternalServerError(message)
+}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// revocationCacheTTL is how long a negative lookup (token not revoked) is cached in memory.
+// Revocations made by another instance of the API take up to this long to be observed.
+const revocationCacheTTL = 30 * time.Second
+
+// maxRevocationCacheEntries bounds each in-memory cache before expired entries are swept.
+const maxRevocationCacheEntries = 10000
+
+// RevocationRepository defines the interface for access token revocation data operations.
+type RevocationRepository interface {
+	RevokeToken(ctx context.Context, tokenID, userID string, expiresAt time.Time) error
+	RevokeUserSessions(ctx context.Context, userID string, revokedAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID, userID string, issuedAt time.Time) (bool, error)
+}
+
+// cachedTime is a time value held in the in-memory cache until cachedUntil.
+type cachedTime struct {
+	value       time.Time
+	cachedUntil time.Time
+}
+
+// revocationRepository is the concrete implementation of RevocationRepository.
+// It persists revocations in Firestore and keeps an in-memory cache so that the
+// AuthMiddleware does not need a database read on every request.
+type revocationRepository struct {
+	client *firestore.Client
+
+	mu sync.Mutex
+	// revokedTokens maps a revoked token ID to the token's expiry. Revocations are final,
+	// so these entries are kept until the token would have expired anyway.
+	revokedTokens map[string]time.Time
+	// activeTokens maps a token ID found not to be revoked to the end of its cache period.
+	activeTokens map[string]time.Time
+	// userRevocations maps a user ID to the time before which their tokens are revoked.
+	userRevocations map[string]cachedTime
+}
+
+// NewRevocationRepository creates a new instance of the revocation repository.
+func NewRevocationRepository(client *firestore.Client) RevocationRepository {
+	return &revocationRepository{
+		client:          client,
+		revokedTokens:   make(map[string]time.Time),
+		activeTokens:    make(map[string]time.Time),
+		userRevocations: make(map[string]cachedTime),
+	}
+}
+
+// RevokeToken revokes a single access token by its ID (the jti claim), e.g. on logout.
+// The expiry is stored so that the document can be cleaned up by a Firestore TTL policy.
+func (r *revocationRepository) RevokeToken(ctx context.Context, tokenID, userID string, expiresAt time.Time) error {
+	_, err := r.client.Collection("revoked_tokens").Doc(tokenID).Set(ctx, map[string]interface{}{
+		"user_id":    userID,
+		"expires_at": expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error revoking token in database: %v", err)
+		return apierror.NewInternalServerError("Failed to revoke token")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.activeTokens, tokenID)
+	r.revokedTokens[tokenID] = expiresAt
+	return nil
+}
+
+// RevokeUserSessions revokes every access token issued to the user before revokedAt.
+func (r *revocationRepository) RevokeUserSessions(ctx context.Context, userID string, revokedAt time.Time) error {
+	_, err := r.client.Collection("user_revocations").Doc(userID).Set(ctx, map[string]interface{}{
+		"revoked_at": revokedAt,
+	})
+	if err != nil {
+		log.Printf("Error revoking user sessions in database: %v", err)
+		return apierror.NewInternalServerError("Failed to revoke user sessions")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.userRevocations[userID] = cachedTime{value: revokedAt, cachedUntil: time.Now().Add(revocationCacheTTL)}
+	return nil
+}
+
+// IsRevoked reports whether the access token with the given ID, issued to userID at
+// issuedAt, has been revoked individually or by revoking all of the user's sessions.
+func (r *revocationRepository) IsRevoked(ctx context.Context, tokenID, userID string, issuedAt time.Time) (bool, error) {
+	revokedAt, err := r.userSessionsRevokedAt(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if revokedBySessions(issuedAt, revokedAt) {
+		return true, nil
+	}
+
+	if tokenID == "" {
+		return false, nil
+	}
+	return r.isTokenRevoked(ctx, tokenID)
+}
+
+// revokedBySessions reports whether a token issued at issuedAt is revoked by a revocation of
+// all of the user's sessions at revokedAt, which is zero if there was none. Issue times are
+// truncated, to whole seconds for tokens without a millisecond iat_ms claim, such as Firebase
+// ID tokens, so a token is only accepted if it was issued strictly after the revocation:
+// a token issued in the same second or millisecond may have been issued before it.
+func revokedBySessions(issuedAt, revokedAt time.Time) bool {
+	return !revokedAt.IsZero() && !issuedAt.After(revokedAt)
+}
+
+// isTokenRevoked checks the cache and then Firestore for an individually revoked token.
+func (r *revocationRepository) isTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	if _, ok := r.revokedTokens[tokenID]; ok {
+		r.mu.Unlock()
+		return true, nil
+	}
+	if until, ok := r.activeTokens[tokenID]; ok && now.Before(until) {
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.mu.Unlock()
+
+	docSnap, err := r.client.Collection("revoked_tokens").Doc(tokenID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Printf("Error checking token revocation: %v", err)
+		return false, apierror.NewInternalServerError("Failed to verify token")
+	}
+	revoked := err == nil
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if revoked {
+		expiresAt, _ := docSnap.Data()["expires_at"].(time.Time)
+		r.sweepExpired(now)
+		r.revokedTokens[tokenID] = expiresAt
+	} else {
+		r.sweepExpired(now)
+		r.activeTokens[tokenID] = now.Add(revocationCacheTTL)
+	}
+
+	return revoked, nil
+}
+
+// userSessionsRevokedAt returns the time before which all of the user's tokens are revoked,
+// or the zero time if their sessions have never been revoked.
+func (r *revocationRepository) userSessionsRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	if cached, ok := r.userRevocations[userID]; ok && now.Before(cached.cachedUntil) {
+		r.mu.Unlock()
+		return cached.value, nil
+	}
+	r.mu.Unlock()
+
+	var revokedAt time.Time
+	docSnap, err := r.client.Collection("user_revocations").Doc(userID).Get(ctx)
+	switch {
+	case err == nil:
+		revokedAt, _ = docSnap.Data()["revoked_at"].(time.Time)
+	case status.Code(err) != codes.NotFound:
+		log.Printf("Error checking user session revocation: %v", err)
+		return time.Time{}, apierror.NewInternalServerError("Failed to verify token")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepExpired(now)
+	r.userRevocations[userID] = cachedTime{value: revokedAt, cachedUntil: now.Add(revocationCacheTTL)}
+	return revokedAt, nil
+}
+
+// sweepExpired removes stale entries once a cache grows past its bound.
+// The caller must hold r.mu.
+func (r *revocationRepository) sweepExpired(now time.Time) {
+	if len(r.revokedTokens) > maxRevocationCacheEntries {
+		for id, expiresAt := range r.revokedTokens {
+			if now.After(expiresAt) {
+				delete(r.revokedTokens, id)
+			}
+		}
+	}
+	if len(r.activeTokens) > maxRevocationCacheEntries {
+		for id, until := range r.activeTokens {
+			if now.After(until) {
+				delete(r.activeTokens, id)
+			}
+		}
+	}
+	if len(r.userRevocations) > maxRevocationCacheEntries {
+		for id, cached := range r.userRevocations {
+			if now.After(cached.cachedUntil) {
+				delete(r.userRevocations, id)
+			}
+		}
+	}
+}
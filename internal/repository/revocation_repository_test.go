@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevokedBySessions(t *testing.T) {
+	revokedAt := time.Date(2026, 1, 1, 12, 0, 0, 500*int(time.Millisecond), time.UTC)
+
+	tests := []struct {
+		name      string
+		issuedAt  time.Time
+		revokedAt time.Time
+		want      bool
+	}{
+		{name: "never revoked", issuedAt: revokedAt, want: false},
+		{name: "issued in an earlier second", issuedAt: revokedAt.Add(-time.Second).Truncate(time.Second), revokedAt: revokedAt, want: true},
+		// A token with only whole seconds may have been issued up to 999ms later than its iat.
+		{name: "whole second of the revocation", issuedAt: revokedAt.Truncate(time.Second), revokedAt: revokedAt, want: true},
+		{name: "same second, milliseconds before", issuedAt: revokedAt.Add(-time.Millisecond), revokedAt: revokedAt, want: true},
+		{name: "same millisecond", issuedAt: revokedAt, revokedAt: revokedAt, want: true},
+		{name: "same second, milliseconds after", issuedAt: revokedAt.Add(time.Millisecond), revokedAt: revokedAt, want: false},
+		{name: "next second", issuedAt: revokedAt.Add(time.Second).Truncate(time.Second), revokedAt: revokedAt, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := revokedBySessions(tt.issuedAt, tt.revokedAt); got != tt.want {
+				t.Errorf("revokedBySessions(%s, %s) = %v, want %v", tt.issuedAt, tt.revokedAt, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BackfillUserStatus marks users created before statuses were introduced as active, so
+// that user lists, which filter on the status, include them. It returns the number of
+// users scanned and updated. Each user is updated in its own transaction, so the backfill
+// can be interrupted and run again safely.
+func BackfillUserStatus(ctx context.Context, client *firestore.Client) (scanned, updated int, err error) {
+	iter := client.Collection("users").Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return scanned, updated, fmt.Errorf("failed to list users: %w", err)
+		}
+
+		scanned++
+		if _, ok := doc.Data()["status"]; ok {
+			continue
+		}
+
+		changed, err := backfillStatus(ctx, client, doc.Ref)
+		if err != nil {
+			return scanned, updated, fmt.Errorf("failed to backfill user %s: %w", doc.Ref.ID, err)
+		}
+		if changed {
+			updated++
+		}
+	}
+
+	return scanned, updated, nil
+}
+
+// backfillStatus sets the status of a single user to active in a transaction, if the
+// user still has no status. It reports whether the user was updated.
+func backfillStatus(ctx context.Context, client *firestore.Client, docRef *firestore.DocumentRef) (bool, error) {
+	var changed bool
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		changed = false
+
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				// Deleted since it was listed.
+				return nil
+			}
+			return err
+		}
+		if _, ok := docSnap.Data()["status"]; ok {
+			return nil
+		}
+
+		changed = true
+		return tx.Update(docRef, []firestore.Update{{Path: "status", Value: model.UserStatusActive}})
+	})
+
+	return changed, err
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrRefreshTokenReused is returned by RotateRefreshToken when a token that has already
+// been rotated is presented again, which indicates that it may have been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// RefreshTokenRepository defines the interface for refresh token data operations.
+type RefreshTokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*model.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id string, next model.RefreshToken) (*model.RefreshToken, error)
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserTokens(ctx context.Context, userID string) error
+}
+
+// refreshTokenRepository is the concrete implementation of RefreshTokenRepository that interacts with Firestore.
+type refreshTokenRepository struct {
+	client *firestore.Client
+}
+
+// NewRefreshTokenRepository creates a new instance of the refresh token repository.
+func NewRefreshTokenRepository(client *firestore.Client) RefreshTokenRepository {
+	return &refreshTokenRepository{client: client}
+}
+
+// CreateRefreshToken stores a new refresh token in the "refresh_tokens" collection,
+// using the token hash as the document ID.
+func (r *refreshTokenRepository) CreateRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	if _, err := r.client.Collection("refresh_tokens").Doc(token.ID).Create(ctx, token); err != nil {
+		log.Printf("Error creating refresh token in database: %v", err)
+		return apierror.NewInternalServerError("Failed to store refresh token")
+	}
+
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by its ID (the token hash).
+func (r *refreshTokenRepository) GetRefreshToken(ctx context.Context, id string) (*model.RefreshToken, error) {
+	docSnap, err := r.client.Collection("refresh_tokens").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, apierror.NewNotFoundError("Refresh token not found")
+		}
+
+		log.Printf("Error getting refresh token from database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to retrieve refresh token")
+	}
+
+	var token model.RefreshToken
+	if err := docSnap.DataTo(&token); err != nil {
+		log.Printf("Error converting refresh token data: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to process refresh token")
+	}
+
+	token.ID = docSnap.Ref.ID
+	return &token, nil
+}
+
+// RotateRefreshToken exchanges the refresh token identified by id for next in a single transaction.
+// The old token is marked as used and next inherits its user, family and authentication methods.
+// It returns the old token, together with ErrRefreshTokenReused if it had already been used.
+func (r *refreshTokenRepository) RotateRefreshToken(ctx context.Context, id string, next model.RefreshToken) (*model.RefreshToken, error) {
+	invalidToken := apierror.NewUnauthorizedError("Invalid or expired refresh token").WithCode(apierror.CodeInvalidToken)
+	oldRef := r.client.Collection("refresh_tokens").Doc(id)
+	var current model.RefreshToken
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(oldRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return invalidToken
+			}
+			return err
+		}
+
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+		current.ID = docSnap.Ref.ID
+
+		switch {
+		case current.Revoked:
+			return invalidToken
+		case current.Used:
+			return ErrRefreshTokenReused
+		case time.Now().After(current.ExpiresAt):
+			return invalidToken
+		}
+
+		if err := tx.Update(oldRef, []firestore.Update{{Path: "used", Value: true}}); err != nil {
+			return err
+		}
+
+		next.UserID = current.UserID
+		next.FamilyID = current.FamilyID
+		next.AuthMethods = current.AuthMethods
+		return tx.Create(r.client.Collection("refresh_tokens").Doc(next.ID), next)
+	})
+
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			return &current, err
+		}
+
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error rotating refresh token: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to refresh token")
+	}
+
+	return &current, nil
+}
+
+// RevokeTokenFamily marks every refresh token in the given family as revoked.
+func (r *refreshTokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	iter := r.client.Collection("refresh_tokens").
+		Where("family_id", "==", familyID).
+		Where("revoked", "==", false).
+		Documents(ctx)
+
+	return r.revokeAll(ctx, iter)
+}
+
+// RevokeUserTokens marks every refresh token issued to the given user as revoked.
+func (r *refreshTokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	iter := r.client.Collection("refresh_tokens").
+		Where("user_id", "==", userID).
+		Where("revoked", "==", false).
+		Documents(ctx)
+
+	return r.revokeAll(ctx, iter)
+}
+
+// revokeAll marks every refresh token returned by the iterator as revoked.
+func (r *refreshTokenRepository) revokeAll(ctx context.Context, iter *firestore.DocumentIterator) error {
+	defer iter.Stop()
+
+	bulkWriter := r.client.BulkWriter(ctx)
+	defer bulkWriter.End()
+
+	for {
+		doc, err := iter.Next()
+		// iterator.Done signifies that all documents have been processed.
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			log.Printf("Error iterating refresh tokens: %v", err)
+			return apierror.NewInternalServerError("Failed to revoke refresh tokens")
+		}
+
+		if _, err := bulkWriter.Update(doc.Ref, []firestore.Update{{Path: "revoked", Value: true}}); err != nil {
+			log.Printf("Error revoking refresh token: %v", err)
+			return apierror.NewInternalServerError("Failed to revoke refresh tokens")
+		}
+	}
+
+	return nil
+}
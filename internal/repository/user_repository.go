@@ -2,15 +2,22 @@ package repository
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"github.com/hermantrym/go-firebase-api/internal/apierror"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
 )
 
 // UserRepository defines the interface for user data operations.
@@ -18,7 +25,19 @@ type UserRepository interface {
 	CreateUser(ctx context.Context, user model.User) (*model.User, error)
 	GetUser(ctx context.Context, id string) (*model.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
-	GetAllUsers(ctx context.Context) ([]model.User, error)
+	GetAllUsers(ctx context.Context, query model.UserListQuery) (*model.UserPage, error)
+	UpdateUser(ctx context.Context, user model.User) (*model.User, error)
+	PatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error)
+	DeleteUser(ctx context.Context, id string, adminRoles []role.Role) error
+	ChangeUserStatus(ctx context.Context, change model.StatusChange, adminRoles []role.Role) (*model.User, error)
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error)
+	RecordLogin(ctx context.Context, id string) error
+	ChangeUserRole(ctx context.Context, change model.RoleChange, adminRoles []role.Role) (*model.User, error)
+	VerifyEmail(ctx context.Context, verification model.EmailVerification) (*model.User, error)
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*model.User, error)
+	LinkIdentity(ctx context.Context, identity model.Identity) (*model.User, error)
+	CreateUserWithIdentity(ctx context.Context, user model.User, identity model.Identity) (*model.User, error)
+	CreateUserWithAudit(ctx context.Context, user model.User, entry model.AuditLog) (*model.User, error)
 }
 
 // userRepository is the concrete implementation of UserRepository that interacts with Firestore.
@@ -32,22 +51,89 @@ func NewUserRepository(client *firestore.Client) UserRepository {
 }
 
 // CreateUser adds a new user document to the "users" collection in Firestore.
+// The user's email is claimed in the "emails" index collection within the same
+// transaction, so a conflict error is returned if the address is already taken.
 func (r *userRepository) CreateUser(ctx context.Context, user model.User) (*model.User, error) {
-	// Create a new document with a random ID in the "users" collection.
-	docRef, _, err := r.client.Collection("users").Add(ctx, map[string]interface{}{
-		"name":  user.Name,
-		"email": user.Email,
-		"role":  user.Role,
+	return r.createUser(ctx, user, nil, nil)
+}
+
+// CreateUserWithIdentity creates a new user linked to an external identity, in a single
+// transaction with the email index entry and the identity link.
+// It returns a 409 APIError if the email address is taken or the identity is already linked.
+func (r *userRepository) CreateUserWithIdentity(ctx context.Context, user model.User, identity model.Identity) (*model.User, error) {
+	return r.createUser(ctx, user, &identity, nil)
+}
+
+// CreateUserWithAudit creates a new user on behalf of an administrator and records the
+// audit log entry in the same transaction. The entry's target and time are set to the
+// new user and its creation time.
+func (r *userRepository) CreateUserWithAudit(ctx context.Context, user model.User, entry model.AuditLog) (*model.User, error) {
+	return r.createUser(ctx, user, nil, &entry)
+}
+
+// createUser creates a new user, claiming their email address and, if given, linking an
+// external identity and recording an audit log entry in the same transaction.
+// The creation and update times are set by the Firestore server, so the stored user is
+// read back once the transaction has committed.
+func (r *userRepository) createUser(ctx context.Context, user model.User, identity *model.Identity, entry *model.AuditLog) (*model.User, error) {
+	// Zero times are replaced by the server timestamp, and a new user has never logged in.
+	user.CreatedAt = time.Time{}
+	user.UpdatedAt = time.Time{}
+	user.LastLoginAt = nil
+	user.Status = model.UserStatusActive
+	user.SuspendedAt = nil
+	user.DeletedAt = nil
+	now := time.Now().UTC()
+
+	// Create a new document reference with a random ID in the "users" collection.
+	docRef := r.client.Collection("users").NewDoc()
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// All reads must happen before any writes in a Firestore transaction.
+		if err := r.checkEmailAvailable(tx, user.Email, docRef.ID); err != nil {
+			return err
+		}
+
+		if err := tx.Create(docRef, user); err != nil {
+			return err
+		}
+
+		if identity != nil {
+			link := *identity
+			link.UserID = docRef.ID
+			link.CreatedAt = now
+			// Create fails if the identity is already linked to another user.
+			if err := tx.Create(r.identityRef(link.Provider, link.Subject), link); err != nil {
+				return err
+			}
+		}
+
+		if entry != nil {
+			audit := *entry
+			audit.TargetID = docRef.ID
+			audit.CreatedAt = now
+			if err := tx.Create(r.client.Collection("audit_logs").NewDoc(), audit); err != nil {
+				return err
+			}
+		}
+
+		return tx.Set(r.emailIndexRef(user.Email), map[string]interface{}{"user_id": docRef.ID})
 	})
 
 	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+		if status.Code(err) == codes.AlreadyExists {
+			return nil, apierror.NewConflictError("External account is already linked to a user").WithCode(apierror.CodeIdentityAlreadyLinked)
+		}
+
 		log.Printf("Error creating user in database: %v", err)
 		return nil, apierror.NewInternalServerError("Failed to create user in database")
 	}
 
-	// Set the auto-generated ID on the user model and return it.
-	user.ID = docRef.ID
-	return &user, nil
+	return r.GetUser(ctx, docRef.ID)
 }
 
 // GetUser retrieves a single user document by its ID from Firestore.
@@ -57,7 +143,7 @@ func (r *userRepository) GetUser(ctx context.Context, id string) (*model.User, e
 	if err != nil {
 		// Specifically handle the case where the document is not found.
 		if status.Code(err) == codes.NotFound {
-			return nil, apierror.NewNotFoundError("User with ID '" + id + "' not found")
+			return nil, apierror.NewNotFoundError("User with ID '" + id + "' not found").WithCode(apierror.CodeUserNotFound)
 		}
 
 		log.Printf("Error getting user from database: %v", err)
@@ -72,15 +158,50 @@ func (r *userRepository) GetUser(ctx context.Context, id string) (*model.User, e
 	}
 
 	user.ID = docSnap.Ref.ID
+	setDefaultStatus(&user)
 	return &user, nil
 }
 
-// GetAllUsers retrieves all user documents from the "users" collection.
-func (r *userRepository) GetAllUsers(ctx context.Context) ([]model.User, error) {
-	var users []model.User
-	iter := r.client.Collection("users").Documents(ctx)
+// setDefaultStatus marks users stored before statuses were introduced as active.
+func setDefaultStatus(user *model.User) {
+	if user.Status == "" {
+		user.Status = model.UserStatusActive
+	}
+}
+
+// GetAllUsers retrieves one page of user documents from the "users" collection,
+// applying the filters, sort order and cursor from the query. Deleted users are left
+// out unless the query includes them. The query's Limit must be set by the caller.
+func (r *userRepository) GetAllUsers(ctx context.Context, query model.UserListQuery) (*model.UserPage, error) {
+	q := r.client.Collection("users").Query
+	if !query.IncludeDeleted {
+		q = q.Where("status", "in", []string{string(model.UserStatusActive), string(model.UserStatusSuspended)})
+	}
+	if query.Role != "" {
+		q = q.Where("role", "==", string(query.Role))
+	}
+	if query.Email != "" {
+		q = q.Where("email", "==", query.Email)
+	}
+	if query.Sort != "" {
+		q = q.OrderBy(query.Sort, firestore.Asc)
+	}
+	// Ordering by document ID last gives a stable order for users sharing the same sort value.
+	q = q.OrderBy(firestore.DocumentID, firestore.Asc)
+
+	if query.Cursor != "" {
+		values, err := decodeUserCursor(query.Cursor, query.Sort)
+		if err != nil {
+			return nil, apierror.NewBadRequestError("Invalid cursor").WithCode(apierror.CodeInvalidCursor)
+		}
+		q = q.StartAfter(values...)
+	}
+
+	// Fetch one extra document to find out whether another page follows this one.
+	iter := q.Limit(query.Limit + 1).Documents(ctx)
 	defer iter.Stop()
 
+	users := make([]model.User, 0, query.Limit)
 	for {
 		doc, err := iter.Next()
 		// iterator.Done signifies that all documents have been processed.
@@ -101,37 +222,757 @@ func (r *userRepository) GetAllUsers(ctx context.Context) ([]model.User, error)
 		}
 
 		user.ID = doc.Ref.ID
+		setDefaultStatus(&user)
 		users = append(users, user)
 	}
 
-	return users, nil
+	page := &model.UserPage{Items: users}
+	if len(users) > query.Limit {
+		page.Items = users[:query.Limit]
+		page.NextCursor = encodeUserCursor(page.Items[query.Limit-1], query.Sort)
+	}
+
+	return page, nil
+}
+
+// userCursor is the decoded form of the opaque pagination cursor.
+// It records the sort field and the position of the last user on the previous page.
+type userCursor struct {
+	Sort  string `json:"s,omitempty"`
+	Value string `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+// encodeUserCursor builds the opaque cursor pointing just after the given user.
+func encodeUserCursor(user model.User, sort string) string {
+	cursor := userCursor{Sort: sort, ID: user.ID}
+	switch sort {
+	case "name":
+		cursor.Value = user.Name
+	case "email":
+		cursor.Value = user.Email
+	case "created_at":
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	// Marshalling a struct of strings cannot fail.
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
-// GetUserByEmail retrieves a single user document by their email address.
+// decodeUserCursor parses an opaque cursor into the values passed to Firestore's StartAfter.
+// It fails if the cursor is malformed or was issued for a different sort order.
+func decodeUserCursor(token, sort string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID == "" || cursor.Sort != sort {
+		return nil, errors.New("cursor does not match the requested sort order")
+	}
+
+	// The values must line up with the OrderBy clauses built in GetAllUsers.
+	switch sort {
+	case "":
+		return []interface{}{cursor.ID}, nil
+	case "created_at":
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{createdAt, cursor.ID}, nil
+	default:
+		return []interface{}{cursor.Value, cursor.ID}, nil
+	}
+}
+
+// GetUserByEmail retrieves a single user by their email address, read through the "emails"
+// index collection. The address must already be normalized.
 func (r *userRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
-	// Query the "users" collection for a document with a matching email field.
-	iter := r.client.Collection("users").Where("email", "==", email).Limit(1).Documents(ctx)
-	// Ensure the iterator is always closed to release resources.
-	defer iter.Stop()
+	notFound := apierror.NewNotFoundError("User with email '" + email + "' not found").WithCode(apierror.CodeUserNotFound)
 
-	doc, err := iter.Next()
+	indexSnap, err := r.emailIndexRef(email).Get(ctx)
 	if err != nil {
-		// The iterator returns a specific error when there are no more documents.
-		if err.Error() == "iterator: no more items" {
-			return nil, apierror.NewNotFoundError("User with email '" + email + "' not found")
+		if status.Code(err) == codes.NotFound {
+			return nil, notFound
 		}
 
 		log.Printf("Error getting user by email from database: %v", err)
 		return nil, apierror.NewInternalServerError("Failed to retrieve user from database")
 	}
 
-	var user model.User
-	// Map the Firestore document data to the User struct.
-	if err := doc.DataTo(&user); err != nil {
-		log.Printf("Error converting user data: %v", err)
+	userID, _ := indexSnap.Data()["user_id"].(string)
+	if userID == "" {
+		return nil, notFound
+	}
+
+	user, err := r.GetUser(ctx, userID)
+	if err != nil {
+		// An index entry left behind by a deleted user does not make the address taken.
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil, notFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser replaces the profile fields and metadata of an existing user document.
+// Empty optional fields are removed from the document. The password hash is left
+// untouched. It returns a not found error if the document does not exist.
+func (r *userRepository) UpdateUser(ctx context.Context, user model.User) (*model.User, error) {
+	return r.updateUser(ctx, user.ID, []firestore.Update{
+		{Path: "name", Value: user.Name},
+		{Path: "email", Value: user.Email},
+		{Path: "role", Value: user.Role},
+		{Path: "display_name", Value: optionalString(user.DisplayName)},
+		{Path: "avatar_url", Value: optionalString(user.AvatarURL)},
+		{Path: "phone", Value: optionalString(user.Phone)},
+		{Path: "locale", Value: optionalString(user.Locale)},
+		{Path: "timezone", Value: optionalString(user.Timezone)},
+		{Path: "metadata", Value: optionalMetadata(user.Metadata)},
+	}, &user.Email)
+}
+
+// PatchUser updates only the fields supplied in the patch on an existing user document.
+// It returns the user as stored after the update.
+func (r *userRepository) PatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error) {
+	var updates []firestore.Update
+	if patch.Name != nil {
+		updates = append(updates, firestore.Update{Path: "name", Value: *patch.Name})
+	}
+	if patch.Email != nil {
+		updates = append(updates, firestore.Update{Path: "email", Value: *patch.Email})
+	}
+	if patch.Role != nil {
+		updates = append(updates, firestore.Update{Path: "role", Value: *patch.Role})
+	}
+	optionalFields := []struct {
+		path  string
+		value *string
+	}{
+		{"display_name", patch.DisplayName},
+		{"avatar_url", patch.AvatarURL},
+		{"phone", patch.Phone},
+		{"locale", patch.Locale},
+		{"timezone", patch.Timezone},
+	}
+	for _, field := range optionalFields {
+		if field.value != nil {
+			updates = append(updates, firestore.Update{Path: field.path, Value: optionalString(*field.value)})
+		}
+	}
+	if patch.Metadata != nil {
+		updates = append(updates, firestore.Update{Path: "metadata", Value: optionalMetadata(patch.Metadata)})
+	}
+
+	// Nothing to change, so simply return the current state of the user.
+	if len(updates) == 0 {
+		return r.GetUser(ctx, id)
+	}
+
+	return r.updateUser(ctx, id, updates, patch.Email)
+}
+
+// optionalString returns the value to store for an optional string field: the string
+// itself, or firestore.Delete to remove the field when it is empty, like omitempty does
+// when the user is created.
+func optionalString(value string) interface{} {
+	if value == "" {
+		return firestore.Delete
+	}
+	return value
+}
+
+// optionalMetadata returns the value to store for the metadata map: the map, which
+// replaces the stored one, or firestore.Delete to remove the field when it is empty.
+func optionalMetadata(metadata map[string]interface{}) interface{} {
+	if len(metadata) == 0 {
+		return firestore.Delete
+	}
+	return metadata
+}
+
+// updateUser applies the updates to a user document in a transaction. If newEmail is
+// not nil and differs from the stored email, the email index entry is moved as well,
+// and a conflict error is returned if the new address belongs to another user.
+func (r *userRepository) updateUser(ctx context.Context, id string, updates []firestore.Update, newEmail *string) (*model.User, error) {
+	docRef := r.client.Collection("users").Doc(id)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return apierror.NewNotFoundError("User with ID '" + id + "' not found").WithCode(apierror.CodeUserNotFound)
+			}
+			return err
+		}
+
+		var current model.User
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+
+		// Copy the updates so that a retried transaction starts from the original list.
+		docUpdates := append([]firestore.Update(nil), updates...)
+		docUpdates = append(docUpdates, firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp})
+
+		emailChanged := newEmail != nil && *newEmail != current.Email
+		if emailChanged {
+			if err := r.checkEmailAvailable(tx, *newEmail, id); err != nil {
+				return err
+			}
+			// A new address has not been verified yet.
+			docUpdates = append(docUpdates, firestore.Update{Path: "verified", Value: false})
+		}
+
+		if err := tx.Update(docRef, docUpdates); err != nil {
+			return err
+		}
+
+		if emailChanged {
+			if current.Email != "" {
+				if err := tx.Delete(r.emailIndexRef(current.Email)); err != nil {
+					return err
+				}
+			}
+			return tx.Set(r.emailIndexRef(*newEmail), map[string]interface{}{"user_id": id})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error updating user in database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to update user in database")
+	}
+
+	return r.GetUser(ctx, id)
+}
+
+// DeleteUser soft-deletes a user: their status becomes "deleted" and the deletion time is
+// recorded, so that they can be restored until PurgeDeletedUsers removes them. Their email
+// address stays claimed and their external identities stay linked until then.
+// It returns a not found error if the document does not exist or is already deleted, and
+// a conflict error if the user is the last active user holding one of adminRoles.
+func (r *userRepository) DeleteUser(ctx context.Context, id string, adminRoles []role.Role) error {
+	_, err := r.changeStatus(ctx, id, model.UserStatusDeleted, adminRoles, nil)
+	return err
+}
+
+// statusAuditActions maps the statuses an administrator can give a user with
+// ChangeUserStatus to the audit action recorded for the change.
+var statusAuditActions = map[model.UserStatus]string{
+	model.UserStatusSuspended: model.AuditActionUserSuspended,
+	model.UserStatusActive:    model.AuditActionUserRestored,
+}
+
+// ChangeUserStatus suspends or restores a user and records the change in the "audit_logs"
+// collection within one transaction. Only active users can be suspended, and only
+// suspended or deleted users can be restored; other changes return a conflict error, as
+// does suspending the last active user holding one of adminRoles. Users are deleted with
+// DeleteUser.
+func (r *userRepository) ChangeUserStatus(ctx context.Context, change model.StatusChange, adminRoles []role.Role) (*model.User, error) {
+	action, ok := statusAuditActions[change.NewStatus]
+	if !ok {
+		return nil, apierror.NewBadRequestError("Invalid status specified")
+	}
+
+	return r.changeStatus(ctx, change.UserID, change.NewStatus, adminRoles, &model.AuditLog{
+		Action:  action,
+		ActorID: change.ActorID,
+	})
+}
+
+// statusTransitions maps each status to the statuses a user can be moved to it from.
+var statusTransitions = map[model.UserStatus][]model.UserStatus{
+	model.UserStatusActive:    {model.UserStatusSuspended, model.UserStatusDeleted},
+	model.UserStatusSuspended: {model.UserStatusActive},
+	model.UserStatusDeleted:   {model.UserStatusActive, model.UserStatusSuspended},
+}
+
+// checkStatusTransition returns the error for moving the user id from the current status
+// to newStatus, or nil if the change is allowed. A deleted user cannot be deleted again
+// and is reported as not found instead.
+func checkStatusTransition(id string, current, newStatus model.UserStatus) error {
+	if current == model.UserStatusDeleted && newStatus == model.UserStatusDeleted {
+		return apierror.NewNotFoundError("User with ID '" + id + "' not found").WithCode(apierror.CodeUserNotFound)
+	}
+	if !slices.Contains(statusTransitions[newStatus], current) {
+		return apierror.NewConflictError("A " + string(current) + " user cannot become " + string(newStatus)).WithCode(apierror.CodeInvalidStatusTransition)
+	}
+
+	return nil
+}
+
+// isActiveAdmin reports whether the user is active and holds one of adminRoles, and so
+// counts towards the administrators who can still manage the API.
+func isActiveAdmin(user *model.User, adminRoles []role.Role) bool {
+	return user.Status == model.UserStatusActive && slices.Contains(adminRoles, user.Role)
+}
+
+// lastAdminError returns the error for an action, e.g. "demote", that would leave no
+// active administrator.
+func lastAdminError(action string) error {
+	return apierror.NewConflictError("Cannot " + action + " the last remaining admin").WithCode(apierror.CodeLastAdmin)
+}
+
+// checkOtherActiveAdmin returns the error for an action that removes an active
+// administrator if no other active user holds one of adminRoles. Reading the admins inside
+// the transaction makes concurrent demotions, suspensions and deletions conflict.
+func (r *userRepository) checkOtherActiveAdmin(tx *firestore.Transaction, adminRoles []role.Role, action string) error {
+	adminRoleNames := make([]string, len(adminRoles))
+	for i, adminRole := range adminRoles {
+		adminRoleNames[i] = string(adminRole)
+	}
+
+	admins, err := tx.Documents(r.client.Collection("users").
+		Where("role", "in", adminRoleNames).
+		Where("status", "==", model.UserStatusActive).
+		Limit(2)).GetAll()
+	if err != nil {
+		return err
+	}
+	if len(admins) < 2 {
+		return lastAdminError(action)
+	}
+
+	return nil
+}
+
+// statusActions names the action of moving a user to each status in error messages.
+var statusActions = map[model.UserStatus]string{
+	model.UserStatusSuspended: "suspend",
+	model.UserStatusDeleted:   "delete",
+}
+
+// changeStatus moves a user to a new status in a transaction, recording when they were
+// suspended or deleted, and, if given, the audit log entry with the old and new status.
+// An active user holding one of adminRoles can only be suspended or deleted if another
+// active user holds one of them.
+func (r *userRepository) changeStatus(ctx context.Context, id string, newStatus model.UserStatus, adminRoles []role.Role, entry *model.AuditLog) (*model.User, error) {
+	docRef := r.client.Collection("users").Doc(id)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return apierror.NewNotFoundError("User with ID '" + id + "' not found").WithCode(apierror.CodeUserNotFound)
+			}
+			return err
+		}
+
+		var current model.User
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+		setDefaultStatus(&current)
+
+		if err := checkStatusTransition(id, current.Status, newStatus); err != nil {
+			return err
+		}
+
+		if newStatus != model.UserStatusActive && isActiveAdmin(&current, adminRoles) {
+			if err := r.checkOtherActiveAdmin(tx, adminRoles, statusActions[newStatus]); err != nil {
+				return err
+			}
+		}
+
+		// Only the time of the current suspension or deletion is kept.
+		var suspendedAt, deletedAt interface{} = firestore.Delete, firestore.Delete
+		switch newStatus {
+		case model.UserStatusSuspended:
+			suspendedAt = firestore.ServerTimestamp
+		case model.UserStatusDeleted:
+			deletedAt = firestore.ServerTimestamp
+		}
+
+		if err := tx.Update(docRef, []firestore.Update{
+			{Path: "status", Value: newStatus},
+			{Path: "suspended_at", Value: suspendedAt},
+			{Path: "deleted_at", Value: deletedAt},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		}); err != nil {
+			return err
+		}
+
+		if entry == nil {
+			return nil
+		}
+		audit := *entry
+		audit.TargetID = id
+		audit.OldValue = string(current.Status)
+		audit.NewValue = string(newStatus)
+		audit.CreatedAt = time.Now().UTC()
+		return tx.Create(r.client.Collection("audit_logs").NewDoc(), audit)
+	})
+
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error changing user status in database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to change user status")
+	}
+
+	return r.GetUser(ctx, id)
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before deletedBefore, together
+// with their email index entries and external identity links, so that their address can
+// be registered again. Each user is removed in its own transaction, so the purge can be
+// interrupted and run again safely. It returns the number of users removed.
+func (r *userRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	iter := r.client.Collection("users").
+		Where("status", "==", string(model.UserStatusDeleted)).
+		Where("deleted_at", "<", deletedBefore).
+		Documents(ctx)
+	defer iter.Stop()
+
+	purged := 0
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			log.Printf("Error iterating deleted users: %v", err)
+			return purged, apierror.NewInternalServerError("Failed to purge deleted users")
+		}
+
+		removed, err := r.purgeUser(ctx, doc.Ref, deletedBefore)
+		if err != nil {
+			log.Printf("Error purging user %s from database: %v", doc.Ref.ID, err)
+			return purged, apierror.NewInternalServerError("Failed to purge deleted users")
+		}
+		if removed {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeUser removes a user document, its email index entry and its external identity links
+// in a transaction, if the user is still deleted since before deletedBefore. It reports
+// whether the user was removed.
+func (r *userRepository) purgeUser(ctx context.Context, docRef *firestore.DocumentRef, deletedBefore time.Time) (bool, error) {
+	var removed bool
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		removed = false
+
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				// Purged by another instance since it was listed.
+				return nil
+			}
+			return err
+		}
+
+		var current model.User
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+		// The user may have been restored since it was listed.
+		if current.Status != model.UserStatusDeleted || current.DeletedAt == nil || !current.DeletedAt.Before(deletedBefore) {
+			return nil
+		}
+
+		identities, err := tx.Documents(r.client.Collection("identities").Where("user_id", "==", docRef.ID)).GetAll()
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(docRef); err != nil {
+			return err
+		}
+
+		// Unlink external identities, so that signing in with them creates a new user.
+		for _, identity := range identities {
+			if err := tx.Delete(identity.Ref); err != nil {
+				return err
+			}
+		}
+
+		removed = true
+
+		// Release the email so that it can be registered again.
+		if current.Email != "" {
+			return tx.Delete(r.emailIndexRef(current.Email))
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// RecordLogin sets the user's last login time to the current server time.
+// It does not change updated_at, which tracks changes to the user's profile.
+// It returns a not found error if the document does not exist.
+func (r *userRepository) RecordLogin(ctx context.Context, id string) error {
+	_, err := r.client.Collection("users").Doc(id).Update(ctx, []firestore.Update{
+		{Path: "last_login_at", Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return apierror.NewNotFoundError("User with ID '" + id + "' not found").WithCode(apierror.CodeUserNotFound)
+		}
+
+		log.Printf("Error recording login in database: %v", err)
+		return apierror.NewInternalServerError("Failed to record login")
+	}
+
+	return nil
+}
+
+// ChangeUserRole assigns a new role to a user and records the change in the "audit_logs"
+// collection within one transaction. If the user is active and currently holds one of
+// adminRoles and the new role is not one of them, a conflict error is returned when no
+// other active user holds an admin role, so that the last administrator cannot be demoted.
+func (r *userRepository) ChangeUserRole(ctx context.Context, change model.RoleChange, adminRoles []role.Role) (*model.User, error) {
+	docRef := r.client.Collection("users").Doc(change.UserID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return apierror.NewNotFoundError("User with ID '" + change.UserID + "' not found").WithCode(apierror.CodeUserNotFound)
+			}
+			return err
+		}
+
+		var current model.User
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+
+		setDefaultStatus(&current)
+
+		// Demoting a suspended or deleted admin leaves the active admins unchanged.
+		if isActiveAdmin(&current, adminRoles) && !slices.Contains(adminRoles, change.NewRole) {
+			if err := r.checkOtherActiveAdmin(tx, adminRoles, "demote"); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Update(docRef, []firestore.Update{
+			{Path: "role", Value: change.NewRole},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		}); err != nil {
+			return err
+		}
+
+		return tx.Create(r.client.Collection("audit_logs").NewDoc(), model.AuditLog{
+			Action:    model.AuditActionRoleChanged,
+			ActorID:   change.ActorID,
+			TargetID:  change.UserID,
+			OldValue:  string(current.Role),
+			NewValue:  string(change.NewRole),
+			CreatedAt: time.Now().UTC(),
+		})
+	})
+
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error changing user role in database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to change user role")
+	}
+
+	return r.GetUser(ctx, change.UserID)
+}
+
+// VerifyEmail marks a user's email address as verified and records the verification token
+// in the "used_tokens" collection within one transaction, so that each token works only once.
+// It fails if the token was already used or the user's address has changed since it was sent.
+func (r *userRepository) VerifyEmail(ctx context.Context, verification model.EmailVerification) (*model.User, error) {
+	invalidToken := apierror.NewBadRequestError("Invalid or expired verification token").WithCode(apierror.CodeInvalidToken)
+	docRef := r.client.Collection("users").Doc(verification.UserID)
+	usedRef := r.client.Collection("used_tokens").Doc(verification.TokenID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return invalidToken
+			}
+			return err
+		}
+
+		var current model.User
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+		if current.Email != verification.Email {
+			return invalidToken
+		}
+
+		if _, err := tx.Get(usedRef); err == nil {
+			return invalidToken
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		// The expiry allows the record to be cleaned up by a Firestore TTL policy.
+		if err := tx.Create(usedRef, map[string]interface{}{"expires_at": verification.ExpiresAt}); err != nil {
+			return err
+		}
+
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "verified", Value: true},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		})
+	})
+
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+
+		log.Printf("Error verifying email in database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to verify email")
+	}
+
+	return r.GetUser(ctx, verification.UserID)
+}
+
+// emailIndexRef returns the reference of the "emails" index document for an email address.
+// The normalized address is path-escaped because Firestore document IDs cannot contain "/".
+func (r *userRepository) emailIndexRef(email string) *firestore.DocumentRef {
+	return r.client.Collection("emails").Doc(url.PathEscape(model.NormalizeEmail(email)))
+}
+
+// checkEmailAvailable reads the email index within a transaction and returns a conflict
+// error if the address is already claimed by a user other than userID.
+func (r *userRepository) checkEmailAvailable(tx *firestore.Transaction, email, userID string) error {
+	indexSnap, err := tx.Get(r.emailIndexRef(email))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+	}
+
+	if owner, _ := indexSnap.Data()["user_id"].(string); owner != userID {
+		return apierror.NewConflictError("A user with this email already exists").WithCode(apierror.CodeEmailTaken)
+	}
+
+	return nil
+}
+
+// GetUserByIdentity retrieves the user linked to an external identity.
+// It returns a 404 APIError if the identity is not linked to any user.
+func (r *userRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	docSnap, err := r.identityRef(provider, subject).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, apierror.NewNotFoundError("External account is not linked to a user")
+		}
+
+		log.Printf("Error getting identity from database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to retrieve user from database")
+	}
+
+	var identity model.Identity
+	if err := docSnap.DataTo(&identity); err != nil {
+		log.Printf("Error converting identity data: %v", err)
 		return nil, apierror.NewInternalServerError("Failed to process user data")
 	}
 
-	user.ID = doc.Ref.ID
-	return &user, nil
+	return r.GetUser(ctx, identity.UserID)
+}
+
+// LinkIdentity links an external identity, whose provider has verified identity.Email, to the
+// existing user with that address in the "identities" collection. Since the provider has
+// proven ownership of the address, the user's address is marked as verified in the same
+// transaction. If it was not verified yet, the user's password is also removed: whoever
+// registered the address had not proven they own it, and must not keep access to the account.
+// It returns a 404 APIError if the user does not exist, and a 409 APIError if the identity
+// is already linked or the user's address is no longer identity.Email.
+func (r *userRepository) LinkIdentity(ctx context.Context, identity model.Identity) (*model.User, error) {
+	identity.CreatedAt = time.Now().UTC()
+	userRef := r.client.Collection("users").Doc(identity.UserID)
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(userRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return apierror.NewNotFoundError("User with ID '" + identity.UserID + "' not found").WithCode(apierror.CodeUserNotFound)
+			}
+			return err
+		}
+
+		var current model.User
+		if err := docSnap.DataTo(&current); err != nil {
+			return err
+		}
+		if err := checkIdentityEmail(current, identity); err != nil {
+			return err
+		}
+
+		if err := tx.Create(r.identityRef(identity.Provider, identity.Subject), identity); err != nil {
+			return err
+		}
+
+		if current.Verified {
+			return nil
+		}
+		return tx.Update(userRef, []firestore.Update{
+			{Path: "verified", Value: true},
+			{Path: "password_hash", Value: ""},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		})
+	})
+
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+		if status.Code(err) == codes.AlreadyExists {
+			return nil, apierror.NewConflictError("External account is already linked to a user").WithCode(apierror.CodeIdentityAlreadyLinked)
+		}
+
+		log.Printf("Error linking identity in database: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to link external account")
+	}
+
+	return r.GetUser(ctx, identity.UserID)
+}
+
+// checkIdentityEmail returns a conflict error unless the identity's verified email address
+// is still the user's address, which it was looked up by.
+func checkIdentityEmail(user model.User, identity model.Identity) error {
+	if identity.Email == "" || model.NormalizeEmail(user.Email) != model.NormalizeEmail(identity.Email) {
+		return apierror.NewConflictError("The user's email address no longer matches the external account")
+	}
+
+	return nil
+}
+
+// identityRef returns the document in the "identities" collection for an external identity.
+// The provider and subject are escaped so that they form a single document ID.
+func (r *userRepository) identityRef(provider, subject string) *firestore.DocumentRef {
+	return r.client.Collection("identities").Doc(url.PathEscape(provider) + ":" + url.PathEscape(subject))
 }
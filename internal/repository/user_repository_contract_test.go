@@ -0,0 +1,695 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// testUserRepository runs the tests every UserRepository implementation must pass, so that
+// the in-memory implementation keeps the semantics of the Firestore one. newRepo returns
+// an empty repository for each test.
+func testUserRepository(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, func(*testing.T) UserRepository)
+	}{
+		{name: "CreateUser", run: testUserRepositoryCreateUser},
+		{name: "CreateUserWithAudit", run: testUserRepositoryCreateUserWithAudit},
+		{name: "Updates", run: testUserRepositoryUpdates},
+		{name: "GetAllUsers", run: testUserRepositoryGetAllUsers},
+		{name: "StatusChanges", run: testUserRepositoryStatusChanges},
+		{name: "PurgeDeletedUsers", run: testUserRepositoryPurgeDeletedUsers},
+		{name: "RecordLogin", run: testUserRepositoryRecordLogin},
+		{name: "ChangeUserRole", run: testUserRepositoryChangeUserRole},
+		{name: "LastActiveAdmin", run: testUserRepositoryLastActiveAdmin},
+		{name: "VerifyEmail", run: testUserRepositoryVerifyEmail},
+		{name: "Identities", run: testUserRepositoryIdentities},
+		{name: "LinkUnverifiedUser", run: testUserRepositoryLinkUnverifiedUser},
+		{name: "ConcurrentCreate", run: testUserRepositoryConcurrentCreate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo)
+		})
+	}
+}
+
+// assertAPIError fails the test unless err is an APIError with the given status and code.
+func assertAPIError(t *testing.T, err error, status int, code string) {
+	t.Helper()
+
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want an APIError %d %s", err, status, code)
+	}
+	if apiErr.Status != status || apiErr.Code != code {
+		t.Fatalf("error = %d %s, want %d %s", apiErr.Status, apiErr.Code, status, code)
+	}
+}
+
+// mustCreateUser creates a user with the given name, email and role, failing the test on error.
+func mustCreateUser(t *testing.T, repo UserRepository, name, email string, userRole role.Role) *model.User {
+	t.Helper()
+
+	user, err := repo.CreateUser(context.Background(), model.User{Name: name, Email: email, Role: userRole, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("failed to create user %s: %v", email, err)
+	}
+	return user
+}
+
+func testUserRepositoryCreateUser(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+
+	created, err := repo.CreateUser(ctx, model.User{Name: "Ada", Email: "ada@example.com", Password: "secret", PasswordHash: "hash", Role: role.User})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(created.ID) != 20 || created.Password != "" || created.Status != model.UserStatusActive || created.CreatedAt.IsZero() {
+		t.Errorf("unexpected created user: %+v", created)
+	}
+
+	got, err := repo.GetUser(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Email != "ada@example.com" || got.PasswordHash != "hash" {
+		t.Errorf("unexpected stored user: %+v", got)
+	}
+
+	byEmail, err := repo.GetUserByEmail(ctx, "ADA@example.com")
+	if err != nil || byEmail.ID != created.ID {
+		t.Errorf("GetUserByEmail = %v, %v, want user %s", byEmail, err, created.ID)
+	}
+
+	_, err = repo.CreateUser(ctx, model.User{Name: "Other", Email: "ada@example.com"})
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeEmailTaken)
+
+	_, err = repo.GetUser(ctx, "missing")
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+
+	_, err = repo.GetUserByEmail(ctx, "missing@example.com")
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+}
+
+func testUserRepositoryCreateUserWithAudit(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+
+	created, err := repo.CreateUserWithAudit(ctx, model.User{Name: "Ada", Email: "ada@example.com", Role: role.Admin, Verified: true}, model.AuditLog{
+		Action:   model.AuditActionUserCreated,
+		ActorID:  "admin-1",
+		NewValue: string(role.Admin),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.Role != role.Admin || !created.Verified || created.Status != model.UserStatusActive {
+		t.Errorf("unexpected created user: %+v", created)
+	}
+
+	_, err = repo.CreateUserWithAudit(ctx, model.User{Name: "Other", Email: "ada@example.com"}, model.AuditLog{Action: model.AuditActionUserCreated})
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeEmailTaken)
+}
+
+func testUserRepositoryUpdates(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	name := "Grace"
+	taken := "taken@example.com"
+	fresh := "fresh@example.com"
+
+	tests := []struct {
+		name       string
+		update     func(repo UserRepository, id string) (*model.User, error)
+		wantStatus int
+		wantCode   string
+		check      func(t *testing.T, user *model.User)
+	}{
+		{
+			name: "replace profile",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.UpdateUser(ctx, model.User{ID: id, Name: "Grace", Email: "ada@example.com", Role: role.User, Locale: "en-GB"})
+			},
+			check: func(t *testing.T, user *model.User) {
+				if user.Name != "Grace" || user.Locale != "en-GB" || user.DisplayName != "" || user.Metadata != nil || !user.Verified {
+					t.Errorf("unexpected user: %+v", user)
+				}
+			},
+		},
+		{
+			name: "replace email",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.UpdateUser(ctx, model.User{ID: id, Name: "Ada", Email: fresh, Role: role.User})
+			},
+			check: func(t *testing.T, user *model.User) {
+				if user.Email != fresh || user.Verified {
+					t.Errorf("unexpected user: %+v", user)
+				}
+			},
+		},
+		{
+			name: "replace with a taken email",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.UpdateUser(ctx, model.User{ID: id, Name: "Ada", Email: taken, Role: role.User})
+			},
+			wantStatus: http.StatusConflict,
+			wantCode:   apierror.CodeEmailTaken,
+		},
+		{
+			name: "replace missing user",
+			update: func(repo UserRepository, _ string) (*model.User, error) {
+				return repo.UpdateUser(ctx, model.User{ID: "missing", Name: "Ada", Email: fresh})
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   apierror.CodeUserNotFound,
+		},
+		{
+			name: "patch name only",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.PatchUser(ctx, id, model.UserPatch{Name: &name})
+			},
+			check: func(t *testing.T, user *model.User) {
+				if user.Name != "Grace" || user.DisplayName != "Countess" || user.Metadata["plan"] != "pro" || !user.Verified {
+					t.Errorf("unexpected user: %+v", user)
+				}
+			},
+		},
+		{
+			name: "patch without changes",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.PatchUser(ctx, id, model.UserPatch{})
+			},
+			check: func(t *testing.T, user *model.User) {
+				if user.Name != "Ada" {
+					t.Errorf("unexpected user: %+v", user)
+				}
+			},
+		},
+		{
+			name: "patch clears metadata",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.PatchUser(ctx, id, model.UserPatch{Metadata: map[string]interface{}{}})
+			},
+			check: func(t *testing.T, user *model.User) {
+				if user.Metadata != nil {
+					t.Errorf("metadata = %v, want nil", user.Metadata)
+				}
+			},
+		},
+		{
+			name: "patch email",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.PatchUser(ctx, id, model.UserPatch{Email: &fresh})
+			},
+			check: func(t *testing.T, user *model.User) {
+				if user.Email != fresh || user.Verified {
+					t.Errorf("unexpected user: %+v", user)
+				}
+			},
+		},
+		{
+			name: "patch with a taken email",
+			update: func(repo UserRepository, id string) (*model.User, error) {
+				return repo.PatchUser(ctx, id, model.UserPatch{Email: &taken})
+			},
+			wantStatus: http.StatusConflict,
+			wantCode:   apierror.CodeEmailTaken,
+		},
+		{
+			name: "patch missing user",
+			update: func(repo UserRepository, _ string) (*model.User, error) {
+				return repo.PatchUser(ctx, "missing", model.UserPatch{Name: &name})
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   apierror.CodeUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t)
+			mustCreateUser(t, repo, "Taken", taken, role.User)
+			user, err := repo.CreateUser(ctx, model.User{
+				Name:        "Ada",
+				Email:       "ada@example.com",
+				Role:        role.User,
+				Verified:    true,
+				DisplayName: "Countess",
+				Metadata:    map[string]interface{}{"plan": "pro"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updated, err := tt.update(repo, user.ID)
+			if tt.wantStatus != 0 {
+				assertAPIError(t, err, tt.wantStatus, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, updated)
+
+			// The email index must follow the user's address.
+			if byEmail, err := repo.GetUserByEmail(ctx, updated.Email); err != nil || byEmail.ID != user.ID {
+				t.Errorf("GetUserByEmail(%q) = %v, %v", updated.Email, byEmail, err)
+			}
+			if updated.Email != "ada@example.com" {
+				if _, err := repo.GetUserByEmail(ctx, "ada@example.com"); err == nil {
+					t.Error("previous email address is still claimed")
+				}
+			}
+		})
+	}
+}
+
+func testUserRepositoryGetAllUsers(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+
+	// Created in this order, with names and emails sorting in different orders.
+	carol := mustCreateUser(t, repo, "Carol", "a-carol@example.com", role.User)
+	alice := mustCreateUser(t, repo, "Alice", "d-alice@example.com", role.Admin)
+	erin := mustCreateUser(t, repo, "Erin", "b-erin@example.com", role.User)
+	bob := mustCreateUser(t, repo, "Bob", "e-bob@example.com", role.User)
+	dave := mustCreateUser(t, repo, "Dave", "c-dave@example.com", role.User)
+	if err := repo.DeleteUser(ctx, dave.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	byID := []string{carol.ID, alice.ID, erin.ID, bob.ID}
+	slices.Sort(byID)
+
+	tests := []struct {
+		name  string
+		query model.UserListQuery
+		want  []string
+	}{
+		{name: "ordered by ID", query: model.UserListQuery{}, want: byID},
+		{name: "ordered by name", query: model.UserListQuery{Sort: "name"}, want: []string{alice.ID, bob.ID, carol.ID, erin.ID}},
+		{name: "ordered by email", query: model.UserListQuery{Sort: "email"}, want: []string{carol.ID, erin.ID, alice.ID, bob.ID}},
+		{name: "ordered by creation", query: model.UserListQuery{Sort: "created_at"}, want: []string{carol.ID, alice.ID, erin.ID, bob.ID}},
+		{name: "including deleted users", query: model.UserListQuery{Sort: "name", IncludeDeleted: true}, want: []string{alice.ID, bob.ID, carol.ID, dave.ID, erin.ID}},
+		{name: "filtered by role", query: model.UserListQuery{Role: role.Admin}, want: []string{alice.ID}},
+		{name: "filtered by email", query: model.UserListQuery{Email: "b-erin@example.com"}, want: []string{erin.ID}},
+		{name: "deleted user filtered by email", query: model.UserListQuery{Email: "c-dave@example.com"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Walk every page of two users, following the cursors.
+			var got []string
+			query := tt.query
+			query.Limit = 2
+			for page := 0; ; page++ {
+				if page > len(tt.want) {
+					t.Fatal("pagination does not terminate")
+				}
+
+				result, err := repo.GetAllUsers(ctx, query)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(result.Items) > query.Limit {
+					t.Fatalf("page has %d users, limit is %d", len(result.Items), query.Limit)
+				}
+				for _, user := range result.Items {
+					got = append(got, user.ID)
+				}
+				if result.NextCursor == "" {
+					break
+				}
+				query.Cursor = result.NextCursor
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("users = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cursor of another sort order", func(t *testing.T) {
+		page, err := repo.GetAllUsers(ctx, model.UserListQuery{Limit: 1, Sort: "name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.GetAllUsers(ctx, model.UserListQuery{Limit: 1, Sort: "email", Cursor: page.NextCursor})
+		assertAPIError(t, err, http.StatusBadRequest, apierror.CodeInvalidCursor)
+	})
+}
+
+func testUserRepositoryStatusChanges(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+
+	suspend := func(repo UserRepository, id string) error {
+		_, err := repo.ChangeUserStatus(ctx, model.StatusChange{UserID: id, NewStatus: model.UserStatusSuspended, ActorID: "admin"}, nil)
+		return err
+	}
+	restore := func(repo UserRepository, id string) error {
+		_, err := repo.ChangeUserStatus(ctx, model.StatusChange{UserID: id, NewStatus: model.UserStatusActive, ActorID: "admin"}, nil)
+		return err
+	}
+	remove := func(repo UserRepository, id string) error {
+		return repo.DeleteUser(ctx, id, nil)
+	}
+
+	type step struct {
+		change     func(UserRepository, string) error
+		wantStatus int
+		wantCode   string
+	}
+
+	tests := []struct {
+		name       string
+		steps      []step
+		wantStatus model.UserStatus
+	}{
+		{name: "suspend", steps: []step{{change: suspend}}, wantStatus: model.UserStatusSuspended},
+		{name: "suspend twice", steps: []step{{change: suspend}, {change: suspend, wantStatus: http.StatusConflict, wantCode: apierror.CodeInvalidStatusTransition}}, wantStatus: model.UserStatusSuspended},
+		{name: "restore suspended", steps: []step{{change: suspend}, {change: restore}}, wantStatus: model.UserStatusActive},
+		{name: "restore active", steps: []step{{change: restore, wantStatus: http.StatusConflict, wantCode: apierror.CodeInvalidStatusTransition}}, wantStatus: model.UserStatusActive},
+		{name: "delete", steps: []step{{change: remove}}, wantStatus: model.UserStatusDeleted},
+		{name: "delete suspended", steps: []step{{change: suspend}, {change: remove}}, wantStatus: model.UserStatusDeleted},
+		{name: "delete twice", steps: []step{{change: remove}, {change: remove, wantStatus: http.StatusNotFound, wantCode: apierror.CodeUserNotFound}}, wantStatus: model.UserStatusDeleted},
+		{name: "suspend deleted", steps: []step{{change: remove}, {change: suspend, wantStatus: http.StatusConflict, wantCode: apierror.CodeInvalidStatusTransition}}, wantStatus: model.UserStatusDeleted},
+		{name: "restore deleted", steps: []step{{change: remove}, {change: restore}}, wantStatus: model.UserStatusActive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo(t)
+			user := mustCreateUser(t, repo, "Ada", "ada@example.com", role.User)
+
+			for _, s := range tt.steps {
+				err := s.change(repo, user.ID)
+				if s.wantStatus != 0 {
+					assertAPIError(t, err, s.wantStatus, s.wantCode)
+				} else if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			got, err := repo.GetUser(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got.Status, tt.wantStatus)
+			}
+			if (got.SuspendedAt != nil) != (got.Status == model.UserStatusSuspended) || (got.DeletedAt != nil) != (got.Status == model.UserStatusDeleted) {
+				t.Errorf("suspended_at = %v, deleted_at = %v for status %q", got.SuspendedAt, got.DeletedAt, got.Status)
+			}
+		})
+	}
+
+	t.Run("missing user", func(t *testing.T) {
+		err := suspend(newRepo(t), "missing")
+		assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+	})
+}
+
+func testUserRepositoryPurgeDeletedUsers(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+
+	kept := mustCreateUser(t, repo, "Kept", "kept@example.com", role.User)
+	deleted, err := repo.CreateUserWithIdentity(ctx, model.User{Name: "Gone", Email: "gone@example.com", Role: role.User}, model.Identity{Provider: "google", Subject: "sub-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteUser(ctx, deleted.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users deleted after the cutoff are kept.
+	purged, err := repo.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Fatalf("PurgeDeletedUsers before deletion = %d, %v, want 0", purged, err)
+	}
+
+	purged, err = repo.PurgeDeletedUsers(ctx, time.Now().Add(time.Second))
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedUsers = %d, %v, want 1", purged, err)
+	}
+
+	_, err = repo.GetUser(ctx, deleted.ID)
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+	if _, err := repo.GetUserByIdentity(ctx, "google", "sub-1"); err == nil {
+		t.Error("identity of the purged user is still linked")
+	}
+	if _, err := repo.GetUser(ctx, kept.ID); err != nil {
+		t.Errorf("active user was purged: %v", err)
+	}
+
+	// The address of a purged user can be registered again.
+	mustCreateUser(t, repo, "Gone again", "gone@example.com", role.User)
+}
+
+func testUserRepositoryRecordLogin(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+	user := mustCreateUser(t, repo, "Ada", "ada@example.com", role.User)
+	if user.LastLoginAt != nil {
+		t.Fatalf("new user has a last login time: %v", user.LastLoginAt)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := repo.RecordLogin(ctx, user.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := repo.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastLoginAt == nil || got.LastLoginAt.Before(before) {
+		t.Errorf("last login time = %v, want after %v", got.LastLoginAt, before)
+	}
+
+	err = repo.RecordLogin(ctx, "missing")
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+}
+
+func testUserRepositoryChangeUserRole(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	adminRoles := role.Including(role.Admin)
+	repo := newRepo(t)
+
+	admin := mustCreateUser(t, repo, "Admin", "admin@example.com", role.Admin)
+	user := mustCreateUser(t, repo, "User", "user@example.com", role.User)
+
+	_, err := repo.ChangeUserRole(ctx, model.RoleChange{UserID: admin.ID, NewRole: role.User, ActorID: admin.ID}, adminRoles)
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeLastAdmin)
+
+	promoted, err := repo.ChangeUserRole(ctx, model.RoleChange{UserID: user.ID, NewRole: role.Admin, ActorID: admin.ID}, adminRoles)
+	if err != nil || promoted.Role != role.Admin {
+		t.Fatalf("ChangeUserRole = %v, %v, want an admin", promoted, err)
+	}
+
+	// Now that there is another admin, the first one can be demoted.
+	if _, err := repo.ChangeUserRole(ctx, model.RoleChange{UserID: admin.ID, NewRole: role.User, ActorID: user.ID}, adminRoles); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = repo.ChangeUserRole(ctx, model.RoleChange{UserID: "missing", NewRole: role.User}, adminRoles)
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+}
+
+func testUserRepositoryLastActiveAdmin(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	adminRoles := role.Including(role.Admin)
+
+	// Each action removes an active admin.
+	actions := map[string]func(context.Context, UserRepository, string) error{
+		"demote": func(ctx context.Context, repo UserRepository, id string) error {
+			_, err := repo.ChangeUserRole(ctx, model.RoleChange{UserID: id, NewRole: role.User, ActorID: "actor"}, adminRoles)
+			return err
+		},
+		"suspend": func(ctx context.Context, repo UserRepository, id string) error {
+			_, err := repo.ChangeUserStatus(ctx, model.StatusChange{UserID: id, NewStatus: model.UserStatusSuspended, ActorID: "actor"}, adminRoles)
+			return err
+		},
+		"delete": func(ctx context.Context, repo UserRepository, id string) error {
+			return repo.DeleteUser(ctx, id, adminRoles)
+		},
+	}
+
+	// Each setup leaves the other admin in some state before the action is taken.
+	setups := []struct {
+		name     string
+		setup    func(context.Context, UserRepository, string) error
+		wantCode string
+	}{
+		{name: "another active admin", setup: func(context.Context, UserRepository, string) error { return nil }},
+		{name: "other admin suspended", setup: actions["suspend"], wantCode: apierror.CodeLastAdmin},
+		{name: "other admin deleted", setup: actions["delete"], wantCode: apierror.CodeLastAdmin},
+		{name: "other admin demoted", setup: actions["demote"], wantCode: apierror.CodeLastAdmin},
+	}
+
+	for _, actionName := range []string{"demote", "suspend", "delete"} {
+		action := actions[actionName]
+		for _, tt := range setups {
+			t.Run(actionName+" with "+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				repo := newRepo(t)
+				admin := mustCreateUser(t, repo, "Admin", "admin@example.com", role.Admin)
+				other := mustCreateUser(t, repo, "Other", "other@example.com", role.Admin)
+
+				if err := tt.setup(ctx, repo, other.ID); err != nil {
+					t.Fatalf("setup failed: %v", err)
+				}
+
+				err := action(ctx, repo, admin.ID)
+				if tt.wantCode == "" {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					return
+				}
+				assertAPIError(t, err, http.StatusConflict, tt.wantCode)
+
+				got, err := repo.GetUser(ctx, admin.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Role != role.Admin || got.Status != model.UserStatusActive {
+					t.Errorf("last admin changed to %s, %s", got.Role, got.Status)
+				}
+			})
+		}
+	}
+
+	t.Run("inactive admins can be demoted", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+		mustCreateUser(t, repo, "Admin", "admin@example.com", role.Admin)
+		suspended := mustCreateUser(t, repo, "Suspended", "suspended@example.com", role.Admin)
+
+		if err := actions["suspend"](ctx, repo, suspended.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := actions["demote"](ctx, repo, suspended.ID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func testUserRepositoryVerifyEmail(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+	user := mustCreateUser(t, repo, "Ada", "ada@example.com", role.User)
+
+	verification := model.EmailVerification{UserID: user.ID, Email: "ada@example.com", TokenID: "token-1", ExpiresAt: time.Now().Add(time.Hour)}
+	verified, err := repo.VerifyEmail(ctx, verification)
+	if err != nil || !verified.Verified {
+		t.Fatalf("VerifyEmail = %v, %v, want a verified user", verified, err)
+	}
+
+	// Tokens work only once.
+	_, err = repo.VerifyEmail(ctx, verification)
+	assertAPIError(t, err, http.StatusBadRequest, apierror.CodeInvalidToken)
+
+	// Tokens sent to a previous address are refused.
+	_, err = repo.VerifyEmail(ctx, model.EmailVerification{UserID: user.ID, Email: "old@example.com", TokenID: "token-2"})
+	assertAPIError(t, err, http.StatusBadRequest, apierror.CodeInvalidToken)
+}
+
+func testUserRepositoryIdentities(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+	user := mustCreateUser(t, repo, "Ada", "ada@example.com", role.User)
+	if _, err := repo.VerifyEmail(ctx, model.EmailVerification{UserID: user.ID, Email: user.Email, TokenID: "token-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	linked, err := repo.LinkIdentity(ctx, model.Identity{Provider: "google", Subject: "sub-1", UserID: user.ID, Email: "Ada@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The password of a verified user is theirs and is kept.
+	if linked.ID != user.ID || !linked.Verified || linked.PasswordHash != "hash" {
+		t.Errorf("LinkIdentity = %+v, want the verified user with their password", linked)
+	}
+
+	found, err := repo.GetUserByIdentity(ctx, "google", "sub-1")
+	if err != nil || found.ID != user.ID {
+		t.Errorf("GetUserByIdentity = %v, %v, want user %s", found, err, user.ID)
+	}
+
+	_, err = repo.LinkIdentity(ctx, model.Identity{Provider: "google", Subject: "sub-1", UserID: user.ID, Email: user.Email})
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeIdentityAlreadyLinked)
+
+	_, err = repo.CreateUserWithIdentity(ctx, model.User{Name: "Other", Email: "other@example.com"}, model.Identity{Provider: "google", Subject: "sub-1"})
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeIdentityAlreadyLinked)
+
+	_, err = repo.LinkIdentity(ctx, model.Identity{Provider: "google", Subject: "sub-2", UserID: "missing", Email: user.Email})
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+
+	// The user's address must still be the one the provider verified.
+	_, err = repo.LinkIdentity(ctx, model.Identity{Provider: "google", Subject: "sub-2", UserID: user.ID, Email: "other@example.com"})
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeConflict)
+
+	if _, err := repo.GetUserByIdentity(ctx, "google", "sub-2"); err == nil {
+		t.Error("expected an error for an unlinked identity")
+	}
+}
+
+func testUserRepositoryLinkUnverifiedUser(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+	// Anyone can register an address they do not own; it stays unverified.
+	squatted := mustCreateUser(t, repo, "Mallory", "ada@example.com", role.User)
+
+	linked, err := repo.LinkIdentity(ctx, model.Identity{Provider: "google", Subject: "sub-1", UserID: squatted.ID, Email: squatted.Email})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !linked.Verified || linked.PasswordHash != "" {
+		t.Errorf("LinkIdentity = %+v, want a verified user without password", linked)
+	}
+
+	got, err := repo.GetUser(ctx, squatted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Verified || got.PasswordHash != "" {
+		t.Errorf("stored user = %+v, want a verified user without password", got)
+	}
+}
+
+func testUserRepositoryConcurrentCreate(t *testing.T, newRepo func(*testing.T) UserRepository) {
+	ctx := context.Background()
+	repo := newRepo(t)
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.CreateUser(ctx, model.User{Name: "Ada", Email: "ada@example.com", Role: role.User}); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("%d users created with the same email, want 1", created)
+	}
+}
@@ -0,0 +1,187 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/hermantrym/go-firebase-api/internal/config"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// emulatorProjectID is the project used when FIREBASE_PROJECT_ID is not set. The "demo-"
+// prefix tells the emulator that the project has no real counterpart.
+const emulatorProjectID = "demo-go-firebase-api"
+
+// TestFirestoreUserRepository runs the repository tests against the Firestore emulator.
+// Run it with:
+//
+//	FIRESTORE_EMULATOR_HOST=localhost:8080 go test -tags integration ./internal/repository/
+func TestFirestoreUserRepository(t *testing.T) {
+	testUserRepository(t, func(t *testing.T) UserRepository {
+		return NewUserRepository(newEmulatorClient(t))
+	})
+}
+
+// TestFirestoreUserRepositoryAudit checks the audit log entries written with the changes
+// made by administrators, which the UserRepository interface does not expose.
+func TestFirestoreUserRepositoryAudit(t *testing.T) {
+	ctx := context.Background()
+	client := newEmulatorClient(t)
+	repo := NewUserRepository(client)
+
+	created, err := repo.CreateUserWithAudit(ctx, model.User{Name: "Ada", Email: "ada@example.com", Role: role.User}, model.AuditLog{
+		Action:   model.AuditActionUserCreated,
+		ActorID:  "admin-1",
+		NewValue: string(role.User),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ChangeUserRole(ctx, model.RoleChange{UserID: created.ID, NewRole: role.Admin, ActorID: "admin-1"}, role.Including(role.Admin)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ChangeUserStatus(ctx, model.StatusChange{UserID: created.ID, NewStatus: model.UserStatusSuspended, ActorID: "admin-1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ChangeUserStatus(ctx, model.StatusChange{UserID: created.ID, NewStatus: model.UserStatusActive, ActorID: "admin-1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := client.Collection("audit_logs").Where("target_id", "==", created.ID).OrderBy("created_at", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []model.AuditLog{
+		{Action: model.AuditActionUserCreated, NewValue: string(role.User)},
+		{Action: model.AuditActionRoleChanged, OldValue: string(role.User), NewValue: string(role.Admin)},
+		{Action: model.AuditActionUserSuspended, OldValue: string(model.UserStatusActive), NewValue: string(model.UserStatusSuspended)},
+		{Action: model.AuditActionUserRestored, OldValue: string(model.UserStatusSuspended), NewValue: string(model.UserStatusActive)},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("got %d audit log entries, want %d", len(docs), len(want))
+	}
+	for i, doc := range docs {
+		var got model.AuditLog
+		if err := doc.DataTo(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Action != want[i].Action || got.ActorID != "admin-1" || got.OldValue != want[i].OldValue || got.NewValue != want[i].NewValue {
+			t.Errorf("entry %d = %+v, want %+v by admin-1", i, got, want[i])
+		}
+		if got.CreatedAt.IsZero() {
+			t.Errorf("entry %d has no creation time", i)
+		}
+	}
+}
+
+// TestFirestoreUserRepositoryEmailIndex checks that the "emails" index follows the users'
+// addresses, so that an address is released when its user changes it or is purged.
+func TestFirestoreUserRepositoryEmailIndex(t *testing.T) {
+	ctx := context.Background()
+	client := newEmulatorClient(t)
+	repo := NewUserRepository(client)
+	r := repo.(*userRepository)
+
+	user := mustCreateUser(t, repo, "Ada", "Ada@Example.com", role.User)
+	assertEmailIndexed(t, r, "ada@example.com", user.ID)
+
+	newEmail := "ada.lovelace@example.com"
+	if _, err := repo.PatchUser(ctx, user.ID, model.UserPatch{Email: &newEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertEmailIndexed(t, r, "ada@example.com", "")
+	assertEmailIndexed(t, r, newEmail, user.ID)
+
+	if err := repo.DeleteUser(ctx, user.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted users keep their address until they are purged, so that they can be restored.
+	assertEmailIndexed(t, r, newEmail, user.ID)
+
+	deleted, err := repo.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.PurgeDeletedUsers(ctx, deleted.DeletedAt.Add(1)); err != nil {
+		t.Fatal(err)
+	}
+	assertEmailIndexed(t, r, newEmail, "")
+}
+
+// assertEmailIndexed fails the test unless the "emails" index maps email to userID, or has
+// no entry for it if userID is empty.
+func assertEmailIndexed(t *testing.T, r *userRepository, email, userID string) {
+	t.Helper()
+
+	doc, err := r.emailIndexRef(email).Get(context.Background())
+	if userID == "" {
+		if doc == nil || doc.Exists() {
+			t.Errorf("email %s is still indexed (err: %v)", email, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("email %s is not indexed: %v", email, err)
+	}
+	if got, _ := doc.DataAt("user_id"); got != userID {
+		t.Errorf("email %s is indexed for %v, want %s", email, got, userID)
+	}
+}
+
+// newEmulatorClient returns a client of the Firestore emulator, configured as the API would
+// be, and deletes all the emulator's documents before and after the test. The test is skipped
+// unless FIRESTORE_EMULATOR_HOST is set, so that it can never run against a real project.
+func newEmulatorClient(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	if os.Getenv("FIREBASE_PROJECT_ID") == "" {
+		t.Setenv("FIREBASE_PROJECT_ID", emulatorProjectID)
+	}
+	projectID := os.Getenv("FIREBASE_PROJECT_ID")
+
+	client, err := config.InitializeFirebase()
+	if err != nil {
+		t.Fatalf("failed to connect to the emulator: %v", err)
+	}
+
+	clearEmulator(t, host, projectID)
+	t.Cleanup(func() {
+		clearEmulator(t, host, projectID)
+		_ = client.Close()
+	})
+
+	return client
+}
+
+// clearEmulator deletes every document of the project through the emulator's REST API.
+func clearEmulator(t *testing.T, host, projectID string) {
+	t.Helper()
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/(default)/documents", host, projectID)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to clear the emulator: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to clear the emulator: %s", resp.Status)
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/model"
+)
+
+func TestUserCursor(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	user := model.User{ID: "user-1", Name: "Ada", Email: "ada@example.com", CreatedAt: createdAt}
+
+	tests := []struct {
+		sort string
+		want []interface{}
+	}{
+		{sort: "", want: []interface{}{"user-1"}},
+		{sort: "name", want: []interface{}{"Ada", "user-1"}},
+		{sort: "email", want: []interface{}{"ada@example.com", "user-1"}},
+		{sort: "created_at", want: []interface{}{createdAt, "user-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("sort "+tt.sort, func(t *testing.T) {
+			got, err := decodeUserCursor(encodeUserCursor(user, tt.sort), tt.sort)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded cursor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeUserCursorInvalid(t *testing.T) {
+	encode := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+	}{
+		{name: "not base64", cursor: "!!!", sort: ""},
+		{name: "not JSON", cursor: encode("not json"), sort: ""},
+		{name: "missing ID", cursor: encode(`{"s":"name","v":"Ada"}`), sort: "name"},
+		{name: "issued for another sort", cursor: encodeUserCursor(model.User{ID: "user-1", Name: "Ada"}, "name"), sort: "email"},
+		{name: "issued without sort", cursor: encodeUserCursor(model.User{ID: "user-1"}, ""), sort: "name"},
+		{name: "invalid time", cursor: encode(`{"s":"created_at","v":"yesterday","id":"user-1"}`), sort: "created_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if values, err := decodeUserCursor(tt.cursor, tt.sort); err == nil {
+				t.Errorf("expected an error, got values %v", values)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header is the request and response header that carries the request ID.
+const Header = "X-Request-ID"
+
+// contextKey is the gin context key the request ID is stored under.
+const contextKey = "requestID"
+
+// maxLength bounds the length of request IDs accepted from clients.
+const maxLength = 128
+
+// Middleware creates a gin middleware that assigns every request an ID, echoed in the
+// X-Request-ID response header and included in error responses. An ID sent by the
+// client or a proxy in the same header is kept if it is reasonably short and only
+// contains letters, digits, '-', '_' and '.'; otherwise a random one is generated.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !isValid(id) {
+			id = generate()
+		}
+
+		c.Set(contextKey, id)
+		c.Header(Header, id)
+		c.Next()
+	}
+}
+
+// Get returns the ID of the request, or an empty string if the middleware is not in use.
+func Get(c *gin.Context) string {
+	return c.GetString(contextKey)
+}
+
+// isValid reports whether a client-supplied request ID is safe to log and echo.
+func isValid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// generate returns a random 128-bit request ID in hex.
+func generate() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
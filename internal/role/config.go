@@ -0,0 +1,158 @@
+package role
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Definition describes a role in the role configuration.
+type Definition struct {
+	// Name is the role's name, as stored on users and in tokens.
+	Name Role `json:"name"`
+
+	// Inherits lists the roles whose permissions this role also grants.
+	Inherits []Role `json:"inherits,omitempty"`
+
+	// Permissions lists the permissions granted directly by this role.
+	Permissions []Permission `json:"permissions,omitempty"`
+}
+
+// DefaultDefinitions are used when no role configuration file is provided.
+// Admins inherit everything a regular user can do, plus every user management permission.
+var DefaultDefinitions = []Definition{
+	{Name: User},
+	{
+		Name:        Admin,
+		Inherits:    []Role{User},
+		Permissions: []Permission{UsersRead, UsersWrite, UsersDelete, UsersSuspend, SessionsRevoke, RolesAssign, APIKeysManage},
+	},
+}
+
+// resolvedRole is a role with its inheritance flattened.
+type resolvedRole struct {
+	// ancestors holds the role itself and every role it inherits from.
+	ancestors map[Role]struct{}
+	// permissions holds every permission granted directly or through inheritance.
+	permissions map[Permission]struct{}
+}
+
+// registry is the resolved set of configured roles.
+type registry struct {
+	roles map[Role]resolvedRole
+}
+
+// active holds the registry in use. It is replaced by Configure, normally once at startup.
+var active atomic.Pointer[registry]
+
+func init() {
+	reg, err := newRegistry(DefaultDefinitions)
+	if err != nil {
+		panic(err)
+	}
+	active.Store(reg)
+}
+
+// current returns the registry in use.
+func current() *registry {
+	return active.Load()
+}
+
+// Configure replaces the configured roles. The definitions must include the built-in
+// User and Admin roles, may only inherit from defined roles, and must not contain cycles.
+func Configure(defs []Definition) error {
+	reg, err := newRegistry(defs)
+	if err != nil {
+		return err
+	}
+
+	active.Store(reg)
+	return nil
+}
+
+// LoadConfig configures the roles from the JSON file named by the ROLES_CONFIG_FILE
+// environment variable. The file has the form {"roles": [Definition, ...]}.
+// If the variable is not set, the default definitions are kept.
+func LoadConfig() error {
+	path := os.Getenv("ROLES_CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read role configuration: %w", err)
+	}
+
+	var file struct {
+		Roles []Definition `json:"roles"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse role configuration: %w", err)
+	}
+
+	return Configure(file.Roles)
+}
+
+// newRegistry validates the definitions and flattens their inheritance.
+func newRegistry(defs []Definition) (*registry, error) {
+	byName := make(map[Role]Definition, len(defs))
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, fmt.Errorf("role definition without a name")
+		}
+		if _, exists := byName[def.Name]; exists {
+			return nil, fmt.Errorf("role %q is defined more than once", def.Name)
+		}
+		byName[def.Name] = def
+	}
+
+	for _, required := range []Role{User, Admin} {
+		if _, ok := byName[required]; !ok {
+			return nil, fmt.Errorf("built-in role %q must be defined", required)
+		}
+	}
+
+	reg := &registry{roles: make(map[Role]resolvedRole, len(defs))}
+	for name := range byName {
+		resolved := resolvedRole{
+			ancestors:   make(map[Role]struct{}),
+			permissions: make(map[Permission]struct{}),
+		}
+		if err := resolve(name, byName, resolved, map[Role]bool{}); err != nil {
+			return nil, err
+		}
+		reg.roles[name] = resolved
+	}
+
+	return reg, nil
+}
+
+// resolve adds the role, its permissions and everything it inherits to resolved.
+// visiting tracks the current inheritance path to detect cycles.
+func resolve(name Role, byName map[Role]Definition, resolved resolvedRole, visiting map[Role]bool) error {
+	if visiting[name] {
+		return fmt.Errorf("role %q inherits from itself", name)
+	}
+
+	def, ok := byName[name]
+	if !ok {
+		return fmt.Errorf("role %q is inherited but not defined", name)
+	}
+
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	resolved.ancestors[name] = struct{}{}
+	for _, p := range def.Permissions {
+		resolved.permissions[p] = struct{}{}
+	}
+	for _, parent := range def.Inherits {
+		if err := resolve(parent, byName, resolved, visiting); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package role
+
+// Permission is a named capability that routes can require, in the form "resource:action".
+type Permission string
+
+// Defines the permissions checked by the application's routes.
+const (
+	// UsersRead allows listing and reading any user.
+	UsersRead Permission = "users:read"
+	// UsersWrite allows creating and updating any user. Creating a user with a role other
+	// than the default one also requires RolesAssign.
+	UsersWrite Permission = "users:write"
+	// UsersDelete allows deleting any user.
+	UsersDelete Permission = "users:delete"
+	// UsersSuspend allows suspending any user and restoring suspended or deleted users.
+	UsersSuspend Permission = "users:suspend"
+	// SessionsRevoke allows revoking all sessions of any user.
+	SessionsRevoke Permission = "sessions:revoke"
+	// RolesAssign allows changing the role of any user.
+	RolesAssign Permission = "roles:assign"
+	// APIKeysManage allows creating, listing and revoking API keys. Creating a key with
+	// a role other than the default one also requires RolesAssign.
+	APIKeysManage Permission = "api_keys:manage"
+)
@@ -3,18 +3,53 @@ package role
 // Role is a custom type representing a user role to ensure type safety.
 type Role string
 
-// Defines the valid role constants available in the application.
+// Defines the built-in role constants that the application relies on.
+// Additional roles can be defined in the role configuration.
 const (
 	Admin Role = "admin"
 	User  Role = "user"
 )
 
-// IsValid checks if the role is one of the predefined valid roles.
+// IsValid checks if the role is one of the roles defined in the current configuration.
 // It returns true if the role is valid, and false otherwise.
 func (r Role) IsValid() bool {
-	switch r {
-	case Admin, User:
-		return true
+	_, ok := current().roles[r]
+	return ok
+}
+
+// Includes reports whether the role is other or inherits from it, directly or indirectly.
+// For example, with the default configuration, Admin includes User.
+func (r Role) Includes(other Role) bool {
+	def, ok := current().roles[r]
+	if !ok {
+		return false
+	}
+
+	_, ok = def.ancestors[other]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission, either directly
+// or through one of the roles it inherits from.
+func (r Role) HasPermission(p Permission) bool {
+	def, ok := current().roles[r]
+	if !ok {
+		return false
 	}
-	return false
+
+	_, ok = def.permissions[p]
+	return ok
+}
+
+// Including returns every configured role that is target or inherits from it.
+// For example, Including(Admin) returns all roles with administrator rights.
+func Including(target Role) []Role {
+	var roles []Role
+	for name, def := range current().roles {
+		if _, ok := def.ancestors[target]; ok {
+			roles = append(roles, name)
+		}
+	}
+
+	return roles
 }
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// maxAPIKeyLifetime caps how far in the future an API key may expire, so that every key
+// is eventually rotated.
+const maxAPIKeyLifetime = 365 * 24 * time.Hour
+
+// APIKeyService defines the interface for managing API keys for machine-to-machine clients.
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, actorID string, actorRole role.Role, req model.APIKeyCreate) (*model.CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, actorID, id string) (*model.APIKey, error)
+}
+
+// apiKeyService is the concrete implementation of the APIKeyService interface.
+type apiKeyService struct {
+	apiKeyRepo repository.APIKeyRepository
+}
+
+// NewAPIKeyService creates a new instance of apiKeyService.
+func NewAPIKeyService(apiKeyRepo repository.APIKeyRepository) APIKeyService {
+	return &apiKeyService{apiKeyRepo: apiKeyRepo}
+}
+
+// CreateAPIKey creates an API key on behalf of the administrator actorID, whose role is
+// actorRole. The key's role defaults to "user"; like when creating a user, any other role
+// can only be given by a role that grants RolesAssign. Scopes must be permissions of the
+// key's role. The plaintext key is returned once and only its hash is stored.
+func (s *apiKeyService) CreateAPIKey(ctx context.Context, actorID string, actorRole role.Role, req model.APIKeyCreate) (*model.CreatedAPIKey, error) {
+	if req.Role == "" {
+		req.Role = role.User
+	}
+
+	if !req.Role.IsValid() {
+		return nil, apierror.NewBadRequestError("Invalid role specified").WithCode(apierror.CodeInvalidRole)
+	}
+
+	if req.Role != role.User && !actorRole.HasPermission(role.RolesAssign) {
+		return nil, apierror.NewForbiddenError("You do not have permission to assign the role '" + string(req.Role) + "'")
+	}
+
+	for _, scope := range req.Scopes {
+		if !req.Role.HasPermission(scope) {
+			return nil, apierror.NewBadRequestError("Scope '" + string(scope) + "' is not granted by the role '" + string(req.Role) + "'")
+		}
+	}
+
+	now := time.Now()
+	if !req.ExpiresAt.After(now) {
+		return nil, apierror.NewBadRequestError("expires_at must be in the future")
+	}
+	if req.ExpiresAt.After(now.Add(maxAPIKeyLifetime)) {
+		return nil, apierror.NewBadRequestError("expires_at must be at most one year in the future")
+	}
+
+	plaintext, id, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to create API key")
+	}
+
+	key := model.APIKey{
+		ID:         id,
+		Name:       req.Name,
+		Role:       req.Role,
+		Scopes:     req.Scopes,
+		SecretHash: secretHash,
+		CreatedBy:  actorID,
+		CreatedAt:  now,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := s.apiKeyRepo.CreateAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return &model.CreatedAPIKey{APIKey: key, Key: plaintext}, nil
+}
+
+// ListAPIKeys returns every API key, without their secrets.
+func (s *apiKeyService) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	return s.apiKeyRepo.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey revokes an API key on behalf of the administrator actorID.
+// Requests with the key are refused from then on.
+func (s *apiKeyService) RevokeAPIKey(ctx context.Context, actorID, id string) (*model.APIKey, error) {
+	return s.apiKeyRepo.RevokeAPIKey(ctx, id, actorID, time.Now())
+}
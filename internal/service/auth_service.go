@@ -0,0 +1,551 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/mail"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+)
+
+// AuthService defines the interface for authentication-related business logic.
+type AuthService interface {
+	LoginUser(ctx context.Context, email, password, clientIP string) (*model.TokenPair, *model.MFAChallenge, error)
+	VerifyMFA(ctx context.Context, mfaToken, code, clientIP string) (*model.TokenPair, error)
+	LoginExternalAccount(ctx context.Context, account model.ExternalAccount) (*model.TokenPair, *model.MFAChallenge, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*model.TokenPair, error)
+	Logout(ctx context.Context, session model.Session, refreshToken string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+	SendVerificationEmail(ctx context.Context, user *model.User) error
+	ResendVerificationEmail(ctx context.Context, email string) error
+	VerifyEmail(ctx context.Context, token string) (*model.User, error)
+	RequestMagicLink(ctx context.Context, email string) error
+	ConsumeMagicLink(ctx context.Context, token string) (*model.TokenPair, *model.MFAChallenge, error)
+}
+
+// authService is the concrete implementation of the AuthService interface.
+type authService struct {
+	userRepo         repository.UserRepository
+	tokenRepo        repository.RefreshTokenRepository
+	revocationRepo   repository.RevocationRepository
+	magicLinkRepo    repository.MagicLinkRepository
+	loginAttemptRepo repository.LoginAttemptRepository
+	mfaRepo          repository.MFARepository
+	tokenConfig      *auth.Config
+	mailer           mail.Mailer
+}
+
+// NewAuthService creates a new instance of authService.
+func NewAuthService(
+	userRepo repository.UserRepository,
+	tokenRepo repository.RefreshTokenRepository,
+	revocationRepo repository.RevocationRepository,
+	magicLinkRepo repository.MagicLinkRepository,
+	loginAttemptRepo repository.LoginAttemptRepository,
+	mfaRepo repository.MFARepository,
+	tokenConfig *auth.Config,
+	mailer mail.Mailer,
+) AuthService {
+	return &authService{
+		userRepo:         userRepo,
+		tokenRepo:        tokenRepo,
+		revocationRepo:   revocationRepo,
+		magicLinkRepo:    magicLinkRepo,
+		loginAttemptRepo: loginAttemptRepo,
+		mfaRepo:          mfaRepo,
+		tokenConfig:      tokenConfig,
+		mailer:           mailer,
+	}
+}
+
+// LoginUser handles the user login process.
+// It finds a user by email, verifies the password against the stored hash,
+// and issues an access token and a refresh token starting a new token family. For users
+// with MFA enabled, it returns an MFA challenge instead, to be completed with VerifyMFA.
+// Failed attempts are counted per email address and per client IP; once either reaches
+// its limit, further attempts are refused with 429 Too Many Requests until the lockout ends.
+// Suspended and deleted users are refused with 403 Forbidden once their password is checked.
+func (s *authService) LoginUser(ctx context.Context, email, password, clientIP string) (*model.TokenPair, *model.MFAChallenge, error) {
+	invalidCredentials := apierror.NewUnauthorizedError("Invalid email or password").WithCode(apierror.CodeInvalidCredentials)
+
+	email = model.NormalizeEmail(email)
+	// Counters are keyed by hashes so that addresses are not stored in plaintext. The email
+	// counter is kept whether or not the account exists, so lockouts do not reveal accounts.
+	accountKey := auth.HashToken("account:" + email)
+	ipKey := auth.HashToken("ip:" + clientIP)
+
+	if err := checkLoginThrottle(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, accountKey, ipKey); err != nil {
+		return nil, nil, err
+	}
+
+	// Find the user by email.
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		var apiErr *apierror.APIError
+		// An unknown email is reported the same way as a wrong password so that
+		// the response does not reveal which accounts exist.
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			auth.CheckDummyPassword(password)
+			return nil, nil, recordLoginFailure(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, invalidCredentials, accountKey, ipKey)
+		}
+		// Return any other error from the repository layer.
+		return nil, nil, err
+	}
+
+	// Accounts without a stored hash cannot log in with a password.
+	if user.PasswordHash == "" || !auth.CheckPassword(user.PasswordHash, password) {
+		return nil, nil, recordLoginFailure(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, invalidCredentials, accountKey, ipKey)
+	}
+
+	// The IP counter is not reset, so that logging in to one account does not clear
+	// failures against others from the same address.
+	if err := s.loginAttemptRepo.ResetLoginAttempts(ctx, accountKey); err != nil {
+		return nil, nil, err
+	}
+
+	if s.tokenConfig.RequireVerifiedEmail && !user.Verified {
+		return nil, nil, apierror.NewForbiddenError("Email address has not been verified").WithCode(apierror.CodeEmailNotVerified)
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+// RefreshToken exchanges a valid refresh token for a new access token and refresh token.
+// The presented token is invalidated. If a token that was already exchanged is presented
+// again, the whole token family is revoked and the user must log in again. Suspended and
+// deleted users are refused.
+func (s *authService) RefreshToken(ctx context.Context, refreshToken string) (*model.TokenPair, error) {
+	invalidToken := apierror.NewUnauthorizedError("Invalid or expired refresh token").WithCode(apierror.CodeInvalidToken)
+
+	nextToken, nextHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating refresh token: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to generate authentication token")
+	}
+
+	now := time.Now().UTC()
+	previous, err := s.tokenRepo.RotateRefreshToken(ctx, auth.HashToken(refreshToken), model.RefreshToken{
+		ID:        nextHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokenConfig.RefreshTokenTTL),
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			log.Printf("Refresh token reuse detected for user %s, revoking token family %s", previous.UserID, previous.FamilyID)
+			if err := s.tokenRepo.RevokeTokenFamily(ctx, previous.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+
+	// Load the user again so that the new access token carries their current role.
+	user, err := s.userRepo.GetUser(ctx, previous.UserID)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+	if err := auth.CheckUserActive(user); err != nil {
+		return nil, err
+	}
+
+	return s.newTokenPair(user, nextToken, previous.AuthMethods)
+}
+
+// Logout revokes the access token of the current session. If the client also supplies
+// its refresh token, the refresh token family is revoked so that it cannot be renewed.
+func (s *authService) Logout(ctx context.Context, session model.Session, refreshToken string) error {
+	// Firebase ID tokens have no token ID; they are signed out through Firebase instead.
+	if session.TokenID != "" {
+		if err := s.revocationRepo.RevokeToken(ctx, session.TokenID, session.UserID, session.ExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.tokenRepo.GetRefreshToken(ctx, auth.HashToken(refreshToken))
+	if err != nil {
+		var apiErr *apierror.APIError
+		// An unknown refresh token has nothing left to revoke.
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	// Never let a user revoke another user's tokens.
+	if stored.UserID != session.UserID {
+		return nil
+	}
+
+	return s.tokenRepo.RevokeTokenFamily(ctx, stored.FamilyID)
+}
+
+// RevokeUserSessions revokes every access token and refresh token issued to the user so far.
+func (s *authService) RevokeUserSessions(ctx context.Context, userID string) error {
+	if err := s.revocationRepo.RevokeUserSessions(ctx, userID, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return s.tokenRepo.RevokeUserTokens(ctx, userID)
+}
+
+// SendVerificationEmail emails the user a signed, single-use link to verify their address.
+func (s *authService) SendVerificationEmail(ctx context.Context, user *model.User) error {
+	token, err := auth.GenerateEmailVerificationToken(s.tokenConfig, user.ID, user.Email)
+	if err != nil {
+		log.Printf("Error generating email verification token: %v", err)
+		return apierror.NewInternalServerError("Failed to send verification email")
+	}
+
+	body := "Use the following token to verify your email address:\n\n" + token
+	if s.tokenConfig.EmailVerificationURL != "" {
+		body = "Open the following link to verify your email address:\n\n" +
+			s.tokenConfig.EmailVerificationURL + "?token=" + url.QueryEscape(token)
+	}
+	body += "\n\nThis link expires in " + s.tokenConfig.EmailVerificationTTL.String() + "."
+
+	if err := s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    body,
+	}); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		return apierror.NewInternalServerError("Failed to send verification email")
+	}
+
+	return nil
+}
+
+// ResendVerificationEmail sends a new verification email if the address belongs to an
+// unverified user. It succeeds without sending anything otherwise, so that the response
+// does not reveal which addresses are registered; for the same reason, delivery failures
+// are only logged.
+func (s *authService) ResendVerificationEmail(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetUserByEmail(ctx, model.NormalizeEmail(email))
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if user.Verified || !user.IsActive() {
+		return nil
+	}
+
+	// A failure here would only happen for unverified addresses and so reveal them.
+	if err := s.SendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Error resending verification email to user %s: %v", user.ID, err)
+	}
+
+	return nil
+}
+
+// VerifyEmail checks a verification token and marks the user's address as verified.
+// Each token can only be used once, and only while it is still the user's address.
+func (s *authService) VerifyEmail(ctx context.Context, token string) (*model.User, error) {
+	claims, err := auth.ParseEmailVerificationToken(s.tokenConfig, token)
+	if err != nil {
+		return nil, apierror.NewBadRequestError("Invalid or expired verification token").WithCode(apierror.CodeInvalidToken)
+	}
+
+	verification := model.EmailVerification{
+		UserID:  claims.Subject,
+		Email:   claims.Email,
+		TokenID: claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		verification.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	return s.userRepo.VerifyEmail(ctx, verification)
+}
+
+// RequestMagicLink emails a single-use login link to the given address if it belongs to
+// a registered user. It succeeds without sending anything otherwise, so that the response
+// does not reveal which addresses are registered; for the same reason, failures to store
+// or send the link are only logged. Only the hash of the token is stored.
+func (s *authService) RequestMagicLink(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetUserByEmail(ctx, model.NormalizeEmail(email))
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	// Inactive users could not log in with the link anyway.
+	if !user.IsActive() {
+		return nil
+	}
+
+	token, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating magic link token: %v", err)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if err := s.magicLinkRepo.CreateMagicLink(ctx, model.MagicLink{
+		ID:        hash,
+		UserID:    user.ID,
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokenConfig.MagicLinkTTL),
+	}); err != nil {
+		// Failing here would only happen for registered addresses and so reveal them.
+		log.Printf("Error storing magic link for user %s: %v", user.ID, err)
+		return nil
+	}
+
+	body := "Use the following token to log in:\n\n" + token
+	if s.tokenConfig.MagicLinkURL != "" {
+		body = "Open the following link to log in:\n\n" +
+			s.tokenConfig.MagicLinkURL + "?token=" + url.QueryEscape(token)
+	}
+	body += "\n\nThis link expires in " + s.tokenConfig.MagicLinkTTL.String() +
+		" and can only be used once. If you did not request it, you can ignore this email."
+
+	if err := s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body:    body,
+	}); err != nil {
+		log.Printf("Error sending magic link email to user %s: %v", user.ID, err)
+	}
+
+	return nil
+}
+
+// ConsumeMagicLink exchanges a magic link token for an access token and a refresh token.
+// Each link can only be used once, and only while it is still the user's address.
+// Since using the link proves ownership of the mailbox, it is accepted even when the
+// user's email has not been verified. Users with MFA enabled receive an MFA challenge.
+func (s *authService) ConsumeMagicLink(ctx context.Context, token string) (*model.TokenPair, *model.MFAChallenge, error) {
+	invalidLink := apierror.NewUnauthorizedError("Invalid or expired login link").WithCode(apierror.CodeInvalidToken)
+
+	link, err := s.magicLinkRepo.ConsumeMagicLink(ctx, auth.HashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetUser(ctx, link.UserID)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil, nil, invalidLink
+		}
+		return nil, nil, err
+	}
+
+	if user.Email != link.Email {
+		return nil, nil, invalidLink
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+// checkLoginThrottle returns a 429 APIError if the account or the client IP is locked out
+// because of previous failed logins. The error carries the longer of the remaining lockouts.
+// Second factors are throttled the same way, keyed by "mfa:" and the user ID instead of the email.
+func checkLoginThrottle(ctx context.Context, attemptRepo repository.LoginAttemptRepository, throttle auth.LoginThrottle, accountKey, ipKey string) error {
+	limits := map[string]int{
+		accountKey: throttle.MaxAccountFailures,
+		ipKey:      throttle.MaxIPFailures,
+	}
+
+	var retryAfter time.Duration
+	for key, limit := range limits {
+		attempts, err := attemptRepo.GetLoginAttempts(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		lockedUntil := attempts.LastFailureAt.Add(throttle.Lockout(attempts.Failures, limit))
+		if remaining := time.Until(lockedUntil); remaining > retryAfter {
+			retryAfter = remaining
+		}
+	}
+
+	if retryAfter > 0 {
+		return apierror.NewTooManyRequestsError("Too many failed login attempts, please try again later", retryAfter).WithCode(apierror.CodeTooManyLoginAttempts)
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the account and the client IP.
+// It returns loginErr, or the repository error if the failure could not be recorded.
+func recordLoginFailure(ctx context.Context, attemptRepo repository.LoginAttemptRepository, throttle auth.LoginThrottle, loginErr error, accountKey, ipKey string) error {
+	for _, key := range []string{accountKey, ipKey} {
+		if _, err := attemptRepo.RecordLoginFailure(ctx, key, throttle.FailureWindow); err != nil {
+			return err
+		}
+	}
+
+	return loginErr
+}
+
+// VerifyMFA completes a login for a user with MFA enabled. It exchanges the challenge token
+// from the first step, together with a TOTP code or a recovery code, for a token pair whose
+// access tokens record that MFA was used. Failed codes are throttled like failed passwords.
+func (s *authService) VerifyMFA(ctx context.Context, mfaToken, code, clientIP string) (*model.TokenPair, error) {
+	userID, err := auth.ParseMFAChallengeToken(s.tokenConfig, mfaToken)
+	if err != nil {
+		return nil, apierror.NewUnauthorizedError("Invalid or expired MFA token").WithCode(apierror.CodeInvalidToken)
+	}
+
+	accountKey, ipKey := mfaThrottleKeys(userID, clientIP)
+	if err := checkLoginThrottle(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, accountKey, ipKey); err != nil {
+		return nil, err
+	}
+
+	if err := verifyMFACode(ctx, s.mfaRepo, userID, code); err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			return nil, recordLoginFailure(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, err, accountKey, ipKey)
+		}
+		return nil, err
+	}
+
+	if err := s.loginAttemptRepo.ResetLoginAttempts(ctx, accountKey); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.CheckUserActive(user); err != nil {
+		return nil, err
+	}
+
+	return s.startSession(ctx, user, []string{auth.MethodOTP, auth.MethodMFA})
+}
+
+// mfaThrottleKeys returns the keys that failed second factors of the user are counted
+// against. Every endpoint that checks a user's second factor shares them, so that
+// guesses cannot be spread across endpoints.
+func mfaThrottleKeys(userID, clientIP string) (accountKey, ipKey string) {
+	return auth.HashToken("mfa:" + userID), auth.HashToken("ip:" + clientIP)
+}
+
+// LoginExternalAccount logs in the user linked to an account authenticated by an external
+// identity provider, linking or creating a user on first login. Users with MFA enabled
+// receive an MFA challenge.
+func (s *authService) LoginExternalAccount(ctx context.Context, account model.ExternalAccount) (*model.TokenPair, *model.MFAChallenge, error) {
+	user, err := findOrCreateExternalUser(ctx, s.userRepo, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.tokenConfig.RequireVerifiedEmail && !user.Verified {
+		return nil, nil, apierror.NewForbiddenError("Email address has not been verified").WithCode(apierror.CodeEmailNotVerified)
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+// completeLogin finishes the first login step for an authenticated user. Suspended and
+// deleted users are refused. Users with MFA enabled receive a challenge to complete with
+// VerifyMFA; everyone else gets a token pair.
+func (s *authService) completeLogin(ctx context.Context, user *model.User) (*model.TokenPair, *model.MFAChallenge, error) {
+	if err := auth.CheckUserActive(user); err != nil {
+		return nil, nil, err
+	}
+
+	mfa, err := s.mfaRepo.GetMFA(ctx, user.ID)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+			return nil, nil, err
+		}
+	}
+
+	if mfa == nil || !mfa.Enabled {
+		tokens, err := s.startSession(ctx, user, nil)
+		return tokens, nil, err
+	}
+
+	mfaToken, err := auth.GenerateMFAChallengeToken(s.tokenConfig, user.ID)
+	if err != nil {
+		log.Printf("Error generating MFA challenge token: %v", err)
+		return nil, nil, apierror.NewInternalServerError("Failed to generate authentication token")
+	}
+
+	return nil, &model.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		ExpiresIn:   int64(s.tokenConfig.MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// startSession issues a token pair for a user who has just authenticated with the given
+// methods, starting a new refresh token family, and records the time of the login.
+func (s *authService) startSession(ctx context.Context, user *model.User, methods []string) (*model.TokenPair, error) {
+	// Every login starts a new refresh token family.
+	familyID, err := auth.GenerateRandomToken(16)
+	if err != nil {
+		log.Printf("Error generating token family ID: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to generate authentication token")
+	}
+
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating refresh token: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to generate authentication token")
+	}
+
+	now := time.Now().UTC()
+	if err := s.tokenRepo.CreateRefreshToken(ctx, model.RefreshToken{
+		ID:          refreshHash,
+		UserID:      user.ID,
+		FamilyID:    familyID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.tokenConfig.RefreshTokenTTL),
+		AuthMethods: methods,
+	}); err != nil {
+		return nil, err
+	}
+
+	// The session is valid either way, so a failure is logged rather than failing the login.
+	if err := s.userRepo.RecordLogin(ctx, user.ID); err != nil {
+		log.Printf("Error recording login of user %s: %v", user.ID, err)
+	}
+
+	return s.newTokenPair(user, refreshToken, methods)
+}
+
+// newTokenPair generates an access token for the user, recording the given authentication
+// methods, and pairs it with the refresh token.
+func (s *authService) newTokenPair(user *model.User, refreshToken string, methods []string) (*model.TokenPair, error) {
+	accessToken, err := auth.GenerateJWT(s.tokenConfig, user.ID, user.Email, user.Role, methods...)
+	if err != nil {
+		log.Printf("Error generating JWT: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to generate authentication token")
+	}
+
+	return &model.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.tokenConfig.AccessTokenTTL.Seconds()),
+	}, nil
+}
@@ -0,0 +1,571 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/mail"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// stubRefreshTokens keeps refresh tokens in a map, rotating them like the Firestore implementation.
+type stubRefreshTokens struct {
+	tokens          map[string]model.RefreshToken
+	revokedFamilies []string
+}
+
+func (s *stubRefreshTokens) CreateRefreshToken(_ context.Context, token model.RefreshToken) error {
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *stubRefreshTokens) GetRefreshToken(_ context.Context, id string) (*model.RefreshToken, error) {
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, apierror.NewNotFoundError("Refresh token not found")
+	}
+	return &token, nil
+}
+
+func (s *stubRefreshTokens) RotateRefreshToken(_ context.Context, id string, next model.RefreshToken) (*model.RefreshToken, error) {
+	invalidToken := apierror.NewUnauthorizedError("Invalid or expired refresh token").WithCode(apierror.CodeInvalidToken)
+
+	current, ok := s.tokens[id]
+	switch {
+	case !ok || current.Revoked:
+		return nil, invalidToken
+	case current.Used:
+		return &current, repository.ErrRefreshTokenReused
+	case time.Now().After(current.ExpiresAt):
+		return nil, invalidToken
+	}
+
+	used := current
+	used.Used = true
+	s.tokens[id] = used
+
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	next.AuthMethods = current.AuthMethods
+	s.tokens[next.ID] = next
+	return &current, nil
+}
+
+func (s *stubRefreshTokens) RevokeTokenFamily(_ context.Context, familyID string) error {
+	s.revokedFamilies = append(s.revokedFamilies, familyID)
+	for id, token := range s.tokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			s.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (s *stubRefreshTokens) RevokeUserTokens(_ context.Context, userID string) error {
+	for id, token := range s.tokens {
+		if token.UserID == userID {
+			token.Revoked = true
+			s.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+// stubLoginAttempts counts failed logins in a map.
+type stubLoginAttempts struct {
+	attempts map[string]model.LoginAttempts
+}
+
+func (s *stubLoginAttempts) GetLoginAttempts(_ context.Context, key string) (*model.LoginAttempts, error) {
+	attempts := s.attempts[key]
+	return &attempts, nil
+}
+
+func (s *stubLoginAttempts) RecordLoginFailure(_ context.Context, key string, _ time.Duration) (*model.LoginAttempts, error) {
+	attempts := s.attempts[key]
+	attempts.Failures++
+	attempts.LastFailureAt = time.Now()
+	s.attempts[key] = attempts
+	return &attempts, nil
+}
+
+func (s *stubLoginAttempts) ResetLoginAttempts(_ context.Context, key string) error {
+	delete(s.attempts, key)
+	return nil
+}
+
+// stubMFA holds the MFA settings of users with MFA set up. Only reading them is supported.
+type stubMFA struct {
+	repository.MFARepository
+	settings map[string]model.MFA
+}
+
+func (s *stubMFA) GetMFA(_ context.Context, userID string) (*model.MFA, error) {
+	mfa, ok := s.settings[userID]
+	if !ok {
+		return nil, apierror.NewNotFoundError("MFA is not set up for this user")
+	}
+	return &mfa, nil
+}
+
+// stubRevocationRepo records the users whose sessions were revoked.
+type stubRevocationRepo struct {
+	repository.RevocationRepository
+	revokedUsers []string
+}
+
+func (s *stubRevocationRepo) RevokeUserSessions(_ context.Context, userID string, _ time.Time) error {
+	s.revokedUsers = append(s.revokedUsers, userID)
+	return nil
+}
+
+// stubMailer records the messages it is asked to send.
+type stubMailer struct {
+	sent []mail.Message
+}
+
+func (s *stubMailer) Send(_ context.Context, msg mail.Message) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// authServiceFixture holds an authService over in-memory repositories.
+type authServiceFixture struct {
+	service     AuthService
+	users       repository.UserRepository
+	tokens      *stubRefreshTokens
+	attempts    *stubLoginAttempts
+	mfa         *stubMFA
+	revocations *stubRevocationRepo
+	cfg         *auth.Config
+}
+
+// newAuthServiceFixture returns an authService with empty repositories and a test token configuration.
+func newAuthServiceFixture(t *testing.T) *authServiceFixture {
+	t.Helper()
+
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeySet(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &authServiceFixture{
+		users:       repository.NewMemoryUserRepository(),
+		tokens:      &stubRefreshTokens{tokens: map[string]model.RefreshToken{}},
+		attempts:    &stubLoginAttempts{attempts: map[string]model.LoginAttempts{}},
+		mfa:         &stubMFA{settings: map[string]model.MFA{}},
+		revocations: &stubRevocationRepo{},
+		cfg: &auth.Config{
+			Keys:            keys,
+			Issuer:          "test-issuer",
+			Audience:        "test-audience",
+			AccessTokenTTL:  time.Minute,
+			RefreshTokenTTL: time.Hour,
+			MFAChallengeTTL: time.Minute,
+			LoginThrottle: auth.LoginThrottle{
+				MaxAccountFailures: 3,
+				MaxIPFailures:      10,
+				BackoffBase:        time.Minute,
+				BackoffMax:         time.Hour,
+				FailureWindow:      time.Hour,
+			},
+		},
+	}
+	f.service = NewAuthService(f.users, f.tokens, f.revocations, nil, f.attempts, f.mfa, f.cfg, &stubMailer{})
+	return f
+}
+
+// createUser stores a user with the given password, failing the test on error.
+func (f *authServiceFixture) createUser(t *testing.T, email, password string) *model.User {
+	t.Helper()
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := f.users.CreateUser(context.Background(), model.User{Name: "Test", Email: email, Role: role.User, PasswordHash: hash, Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// assertAPIError fails the test unless err is an APIError with the given status and code.
+func assertAPIError(t *testing.T, err error, status int, code string) {
+	t.Helper()
+
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want an APIError %d %s", err, status, code)
+	}
+	if apiErr.Status != status || apiErr.Code != code {
+		t.Fatalf("error = %d %s, want %d %s", apiErr.Status, apiErr.Code, status, code)
+	}
+}
+
+func TestAuthServiceLoginUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, f *authServiceFixture, user *model.User)
+		email      string
+		password   string
+		wantStatus int
+		wantCode   string
+		wantMFA    bool
+	}{
+		{name: "valid credentials", email: "ada@example.com", password: "correct horse"},
+		{name: "email in another case", email: " ADA@example.com", password: "correct horse"},
+		{name: "wrong password", email: "ada@example.com", password: "wrong", wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidCredentials},
+		{name: "unknown email", email: "nobody@example.com", password: "correct horse", wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeInvalidCredentials},
+		{
+			name: "locked out",
+			setup: func(_ *testing.T, f *authServiceFixture, _ *model.User) {
+				f.attempts.attempts[auth.HashToken("account:ada@example.com")] = model.LoginAttempts{Failures: 3, LastFailureAt: time.Now()}
+			},
+			email: "ada@example.com", password: "correct horse", wantStatus: http.StatusTooManyRequests, wantCode: apierror.CodeTooManyLoginAttempts,
+		},
+		{
+			name: "unverified email",
+			setup: func(t *testing.T, f *authServiceFixture, user *model.User) {
+				f.cfg.RequireVerifiedEmail = true
+				if _, err := f.users.UpdateUser(ctx, model.User{ID: user.ID, Name: user.Name, Email: "ada@example.org", Role: user.Role}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			email: "ada@example.org", password: "correct horse", wantStatus: http.StatusForbidden, wantCode: apierror.CodeEmailNotVerified,
+		},
+		{
+			name: "suspended user",
+			setup: func(t *testing.T, f *authServiceFixture, user *model.User) {
+				if _, err := f.users.ChangeUserStatus(ctx, model.StatusChange{UserID: user.ID, NewStatus: model.UserStatusSuspended}, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			email: "ada@example.com", password: "correct horse", wantStatus: http.StatusForbidden, wantCode: apierror.CodeAccountSuspended,
+		},
+		{
+			name: "deleted user",
+			setup: func(t *testing.T, f *authServiceFixture, user *model.User) {
+				if err := f.users.DeleteUser(ctx, user.ID, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			email: "ada@example.com", password: "correct horse", wantStatus: http.StatusForbidden, wantCode: apierror.CodeAccountDeleted,
+		},
+		{
+			name: "MFA enabled",
+			setup: func(_ *testing.T, f *authServiceFixture, user *model.User) {
+				f.mfa.settings[user.ID] = model.MFA{UserID: user.ID, Enabled: true}
+			},
+			email: "ada@example.com", password: "correct horse", wantMFA: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAuthServiceFixture(t)
+			user := f.createUser(t, "ada@example.com", "correct horse")
+			if tt.setup != nil {
+				tt.setup(t, f, user)
+			}
+
+			tokens, challenge, err := f.service.LoginUser(ctx, tt.email, tt.password, "192.0.2.1")
+			if tt.wantStatus != 0 {
+				assertAPIError(t, err, tt.wantStatus, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			stored, err := f.users.GetUser(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantMFA {
+				if tokens != nil || challenge == nil || !challenge.MFARequired || challenge.MFAToken == "" {
+					t.Fatalf("LoginUser = %+v, %+v, want an MFA challenge", tokens, challenge)
+				}
+				if stored.LastLoginAt != nil {
+					t.Error("login recorded before the second factor")
+				}
+				return
+			}
+
+			if challenge != nil || tokens == nil || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+				t.Fatalf("LoginUser = %+v, %+v, want a token pair", tokens, challenge)
+			}
+			if stored.LastLoginAt == nil {
+				t.Error("last login time not recorded")
+			}
+			if _, ok := f.tokens.tokens[auth.HashToken(tokens.RefreshToken)]; !ok {
+				t.Error("refresh token not stored")
+			}
+		})
+	}
+
+	t.Run("failures lead to a lockout", func(t *testing.T) {
+		f := newAuthServiceFixture(t)
+		f.createUser(t, "ada@example.com", "correct horse")
+
+		for i := 0; i < f.cfg.LoginThrottle.MaxAccountFailures; i++ {
+			_, _, err := f.service.LoginUser(ctx, "ada@example.com", "wrong", "192.0.2.1")
+			assertAPIError(t, err, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+		}
+
+		// Even the right password is refused until the lockout ends.
+		_, _, err := f.service.LoginUser(ctx, "ada@example.com", "correct horse", "192.0.2.1")
+		assertAPIError(t, err, http.StatusTooManyRequests, apierror.CodeTooManyLoginAttempts)
+	})
+}
+
+func TestAuthServiceLoginExternalAccount(t *testing.T) {
+	ctx := context.Background()
+	account := model.ExternalAccount{Provider: "google", Subject: "sub-1", Email: "Ada@example.com", EmailVerified: true, Name: "Ada"}
+
+	tests := []struct {
+		name          string
+		existing      *model.User
+		emailVerified bool
+		wantStatus    int
+		wantCode      string
+		wantLinked    bool
+		wantPassword  bool
+	}{
+		{name: "new user", emailVerified: true},
+		{name: "new user with an unverified email", emailVerified: false},
+		{
+			name:          "verified existing user",
+			existing:      &model.User{Name: "Ada", Email: "ada@example.com", Role: role.User, Verified: true},
+			emailVerified: true, wantLinked: true, wantPassword: true,
+		},
+		{
+			// The address may have been registered by someone who does not own it.
+			name:          "unverified existing user",
+			existing:      &model.User{Name: "Mallory", Email: "ada@example.com", Role: role.User},
+			emailVerified: true, wantLinked: true,
+		},
+		{
+			name:          "existing user and an unverified email",
+			existing:      &model.User{Name: "Ada", Email: "ada@example.com", Role: role.User, Verified: true},
+			emailVerified: false, wantStatus: http.StatusForbidden, wantCode: apierror.CodeForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAuthServiceFixture(t)
+			f.cfg.RequireVerifiedEmail = true
+
+			var existing *model.User
+			if tt.existing != nil {
+				hash, err := auth.HashPassword("correct horse")
+				if err != nil {
+					t.Fatal(err)
+				}
+				user := *tt.existing
+				user.PasswordHash = hash
+				if existing, err = f.users.CreateUser(ctx, user); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			external := account
+			external.EmailVerified = tt.emailVerified
+			tokens, _, err := f.service.LoginExternalAccount(ctx, external)
+			if tt.wantStatus != 0 {
+				assertAPIError(t, err, tt.wantStatus, tt.wantCode)
+				return
+			}
+			if !tt.emailVerified {
+				// New users keep the provider's verification status.
+				assertAPIError(t, err, http.StatusForbidden, apierror.CodeEmailNotVerified)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tokens == nil || tokens.AccessToken == "" {
+				t.Fatalf("LoginExternalAccount = %+v, want a token pair", tokens)
+			}
+
+			linked, err := f.users.GetUserByIdentity(ctx, account.Provider, account.Subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantLinked && linked.ID != existing.ID {
+				t.Errorf("linked to user %s, want %s", linked.ID, existing.ID)
+			}
+			if !linked.Verified {
+				t.Error("linked user is not verified")
+			}
+
+			if tt.existing == nil {
+				return
+			}
+			_, _, err = f.service.LoginUser(ctx, "ada@example.com", "correct horse", "192.0.2.1")
+			if tt.wantPassword && err != nil {
+				t.Errorf("password login failed: %v", err)
+			}
+			if !tt.wantPassword {
+				assertAPIError(t, err, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+			}
+		})
+	}
+}
+
+func TestFirebaseUserResolverClaimsUnverifiedUser(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	squatted, err := users.CreateUser(ctx, model.User{Name: "Mallory", Email: "ada@example.com", Role: role.User, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := NewFirebaseUserResolver(users).ResolveFirebaseUser(ctx, &auth.FirebaseToken{UID: "uid-1", Email: "ada@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != squatted.ID || !user.Verified || user.PasswordHash != "" {
+		t.Errorf("ResolveFirebaseUser = %+v, want user %s verified and without password", user, squatted.ID)
+	}
+}
+
+func TestFirebaseUserResolverRole(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	resolver := NewFirebaseUserResolver(users)
+	existing, err := users.CreateUser(ctx, model.User{Name: "Ada", Email: "ada@example.com", Role: role.User, Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The custom claim sets the role of new users only.
+	created, err := resolver.ResolveFirebaseUser(ctx, &auth.FirebaseToken{UID: "uid-1", Email: "grace@example.com", EmailVerified: true, Role: role.Admin})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.Role != role.Admin {
+		t.Errorf("new user role = %q, want %q", created.Role, role.Admin)
+	}
+
+	linked, err := resolver.ResolveFirebaseUser(ctx, &auth.FirebaseToken{UID: "uid-2", Email: "ada@example.com", EmailVerified: true, Role: role.Admin})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if linked.ID != existing.ID || linked.Role != role.User {
+		t.Errorf("linked user = %s with role %q, want %s with role %q", linked.ID, linked.Role, existing.ID, role.User)
+	}
+}
+
+func TestAuthServiceRefreshToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rotation", func(t *testing.T) {
+		f := newAuthServiceFixture(t)
+		f.createUser(t, "ada@example.com", "correct horse")
+
+		first, _, err := f.service.LoginUser(ctx, "ada@example.com", "correct horse", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, err := f.service.RefreshToken(ctx, first.RefreshToken)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+			t.Errorf("RefreshToken = %+v, want a new token pair", second)
+		}
+
+		if _, err := f.service.RefreshToken(ctx, second.RefreshToken); err != nil {
+			t.Fatalf("unexpected error refreshing the new token: %v", err)
+		}
+	})
+
+	t.Run("reuse revokes the family", func(t *testing.T) {
+		f := newAuthServiceFixture(t)
+		f.createUser(t, "ada@example.com", "correct horse")
+
+		first, _, err := f.service.LoginUser(ctx, "ada@example.com", "correct horse", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := f.service.RefreshToken(ctx, first.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Replaying the rotated token revokes every token of the family.
+		_, err = f.service.RefreshToken(ctx, first.RefreshToken)
+		assertAPIError(t, err, http.StatusUnauthorized, apierror.CodeInvalidToken)
+
+		family := f.tokens.tokens[auth.HashToken(first.RefreshToken)].FamilyID
+		if len(f.tokens.revokedFamilies) != 1 || f.tokens.revokedFamilies[0] != family {
+			t.Errorf("revoked families = %v, want [%s]", f.tokens.revokedFamilies, family)
+		}
+
+		_, err = f.service.RefreshToken(ctx, second.RefreshToken)
+		assertAPIError(t, err, http.StatusUnauthorized, apierror.CodeInvalidToken)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		f := newAuthServiceFixture(t)
+		_, err := f.service.RefreshToken(ctx, "unknown")
+		assertAPIError(t, err, http.StatusUnauthorized, apierror.CodeInvalidToken)
+	})
+
+	t.Run("suspended user", func(t *testing.T) {
+		f := newAuthServiceFixture(t)
+		user := f.createUser(t, "ada@example.com", "correct horse")
+
+		first, _, err := f.service.LoginUser(ctx, "ada@example.com", "correct horse", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.users.ChangeUserStatus(ctx, model.StatusChange{UserID: user.ID, NewStatus: model.UserStatusSuspended}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.service.RefreshToken(ctx, first.RefreshToken)
+		assertAPIError(t, err, http.StatusForbidden, apierror.CodeAccountSuspended)
+	})
+}
+
+func TestAuthServiceRevokeUserSessions(t *testing.T) {
+	ctx := context.Background()
+	f := newAuthServiceFixture(t)
+	user := f.createUser(t, "ada@example.com", "correct horse")
+
+	tokens, _, err := f.service.LoginUser(ctx, "ada@example.com", "correct horse", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.service.RevokeUserSessions(ctx, user.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.revocations.revokedUsers) != 1 || f.revocations.revokedUsers[0] != user.ID {
+		t.Errorf("revoked users = %v, want [%s]", f.revocations.revokedUsers, user.ID)
+	}
+
+	_, err = f.service.RefreshToken(ctx, tokens.RefreshToken)
+	assertAPIError(t, err, http.StatusUnauthorized, apierror.CodeInvalidToken)
+}
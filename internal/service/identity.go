@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// findOrCreateExternalUser returns the user linked to an external account. An account
+// that is not linked yet is linked to the existing user with the same email address if
+// the provider has verified the address, or to a new user with the default "user" role
+// if nobody has registered the address, or the role the provider assigns. Linking marks the existing user's address as
+// verified, and removes their password if it was not verified before, so that whoever
+// registered someone else's address cannot keep access once its owner signs in.
+func findOrCreateExternalUser(ctx context.Context, userRepo repository.UserRepository, account model.ExternalAccount) (*model.User, error) {
+	user, err := findLinkedUser(ctx, userRepo, account)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	email := model.NormalizeEmail(account.Email)
+	if email == "" {
+		return nil, apierror.NewForbiddenError("The external account has no email address")
+	}
+
+	identity := model.Identity{
+		Provider: account.Provider,
+		Subject:  account.Subject,
+		Email:    email,
+	}
+
+	// Only link to an existing user if the provider has proven ownership of the address,
+	// otherwise anyone could take over an account by claiming its email elsewhere.
+	if account.EmailVerified {
+		existing, err := userRepo.GetUserByEmail(ctx, email)
+		if err == nil {
+			identity.UserID = existing.ID
+			linked, err := userRepo.LinkIdentity(ctx, identity)
+			if err != nil {
+				return retryOnConflict(ctx, userRepo, account, err)
+			}
+			return linked, nil
+		}
+		if !isStatus(err, http.StatusNotFound) {
+			return nil, err
+		}
+	}
+
+	name := strings.TrimSpace(account.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	newRole := role.User
+	if account.Role != "" {
+		newRole = account.Role
+	}
+
+	created, err := userRepo.CreateUserWithIdentity(ctx, model.User{
+		Name:     name,
+		Email:    email,
+		Role:     newRole,
+		Verified: account.EmailVerified,
+	}, identity)
+	if err != nil {
+		if isStatus(err, http.StatusConflict) && !account.EmailVerified {
+			if user, lookupErr := findLinkedUser(ctx, userRepo, account); lookupErr != nil || user != nil {
+				return user, lookupErr
+			}
+			return nil, apierror.NewForbiddenError("The email address must be verified to sign in to an existing account")
+		}
+		return retryOnConflict(ctx, userRepo, account, err)
+	}
+
+	return created, nil
+}
+
+// findLinkedUser returns the user linked to an external account, or nil if it is not linked.
+func findLinkedUser(ctx context.Context, userRepo repository.UserRepository, account model.ExternalAccount) (*model.User, error) {
+	user, err := userRepo.GetUserByIdentity(ctx, account.Provider, account.Subject)
+	if err != nil {
+		if isStatus(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// retryOnConflict handles an error from linking an external account. A conflict means a
+// concurrent request linked it first, in which case the linked user is returned.
+func retryOnConflict(ctx context.Context, userRepo repository.UserRepository, account model.ExternalAccount, err error) (*model.User, error) {
+	if !isStatus(err, http.StatusConflict) {
+		return nil, err
+	}
+
+	user, lookupErr := findLinkedUser(ctx, userRepo, account)
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	if user == nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// isStatus reports whether err is an APIError with the given HTTP status code.
+func isStatus(err error, code int) bool {
+	var apiErr *apierror.APIError
+	return errors.As(err, &apiErr) && apiErr.Status == code
+}
+
+// firebaseUserResolver maps Firebase ID tokens to users, creating and linking users as needed.
+type firebaseUserResolver struct {
+	userRepo repository.UserRepository
+}
+
+// NewFirebaseUserResolver creates an auth.FirebaseUserResolver backed by the user repository.
+// Firebase users are linked by UID under the "firebase" provider.
+func NewFirebaseUserResolver(userRepo repository.UserRepository) auth.FirebaseUserResolver {
+	return &firebaseUserResolver{userRepo: userRepo}
+}
+
+// ResolveFirebaseUser returns the user linked to the Firebase UID, linking or creating one
+// on first sign-in. New users get the role from the token's custom claim, if it has one;
+// existing users keep their stored role.
+func (r *firebaseUserResolver) ResolveFirebaseUser(ctx context.Context, token *auth.FirebaseToken) (*model.User, error) {
+	return findOrCreateExternalUser(ctx, r.userRepo, model.ExternalAccount{
+		Provider:      "firebase",
+		Subject:       token.UID,
+		Email:         token.Email,
+		EmailVerified: token.EmailVerified,
+		Name:          token.Name,
+		Role:          token.Role,
+	})
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// MFAService defines the interface for managing a user's TOTP two-factor authentication.
+type MFAService interface {
+	EnrollTOTP(ctx context.Context, userID string) (*model.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID, code, clientIP string) (*model.RecoveryCodes, error)
+	DisableMFA(ctx context.Context, userID, code, clientIP string) error
+}
+
+// mfaService is the concrete implementation of the MFAService interface.
+type mfaService struct {
+	userRepo         repository.UserRepository
+	mfaRepo          repository.MFARepository
+	loginAttemptRepo repository.LoginAttemptRepository
+	tokenConfig      *auth.Config
+}
+
+// NewMFAService creates a new instance of mfaService.
+// Failed codes are counted in the login attempt repository, like failed logins.
+func NewMFAService(userRepo repository.UserRepository, mfaRepo repository.MFARepository, loginAttemptRepo repository.LoginAttemptRepository, tokenConfig *auth.Config) MFAService {
+	return &mfaService{
+		userRepo:         userRepo,
+		mfaRepo:          mfaRepo,
+		loginAttemptRepo: loginAttemptRepo,
+		tokenConfig:      tokenConfig,
+	}
+}
+
+// EnrollTOTP starts TOTP enrollment by generating a new secret for the user.
+// MFA is not enabled until the user confirms a code generated from the secret.
+func (s *mfaService) EnrollTOTP(ctx context.Context, userID string) (*model.TOTPEnrollment, error) {
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to start MFA enrollment")
+	}
+
+	if err := s.mfaRepo.CreateMFAEnrollment(ctx, model.MFA{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &model.TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(s.tokenConfig.TOTPIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP completes enrollment if the code matches the pending secret, enabling MFA.
+// It returns the user's recovery codes, which are only ever shown this once.
+// Failed codes are throttled like failed MFA logins.
+func (s *mfaService) ConfirmTOTP(ctx context.Context, userID, code, clientIP string) (*model.RecoveryCodes, error) {
+	accountKey, ipKey := mfaThrottleKeys(userID, clientIP)
+	if err := checkLoginThrottle(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, accountKey, ipKey); err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfaRepo.GetMFA(ctx, userID)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return nil, apierror.NewBadRequestError("No MFA enrollment is pending")
+		}
+		return nil, err
+	}
+	if mfa.Enabled {
+		return nil, apierror.NewConflictError("MFA is already enabled").WithCode(apierror.CodeMFAAlreadyEnabled)
+	}
+
+	step, ok := auth.ValidateTOTP(mfa.Secret, code, time.Now())
+	if !ok {
+		return nil, recordLoginFailure(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle,
+			apierror.NewBadRequestError("Invalid verification code").WithCode(apierror.CodeInvalidMFACode), accountKey, ipKey)
+	}
+	if err := s.loginAttemptRepo.ResetLoginAttempts(ctx, accountKey); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to enable MFA")
+	}
+
+	if err := s.mfaRepo.EnableMFA(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	return &model.RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableMFA turns MFA off after checking a current TOTP code or a recovery code.
+// Admins cannot disable MFA while it is required for them. Failed codes are throttled
+// like failed MFA logins, so that a stolen access token is not enough to guess a code.
+func (s *mfaService) DisableMFA(ctx context.Context, userID, code, clientIP string) error {
+	if s.tokenConfig.RequireAdminMFA {
+		user, err := s.userRepo.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.Role.Includes(role.Admin) {
+			return apierror.NewForbiddenError("MFA is required for admin accounts and cannot be disabled").WithCode(apierror.CodeMFARequired)
+		}
+	}
+
+	accountKey, ipKey := mfaThrottleKeys(userID, clientIP)
+	if err := checkLoginThrottle(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, accountKey, ipKey); err != nil {
+		return err
+	}
+
+	if err := verifyMFACode(ctx, s.mfaRepo, userID, code); err != nil {
+		if isStatus(err, http.StatusUnauthorized) {
+			return recordLoginFailure(ctx, s.loginAttemptRepo, s.tokenConfig.LoginThrottle, err, accountKey, ipKey)
+		}
+		return err
+	}
+
+	if err := s.loginAttemptRepo.ResetLoginAttempts(ctx, accountKey); err != nil {
+		return err
+	}
+
+	return s.mfaRepo.DeleteMFA(ctx, userID)
+}
+
+// verifyMFACode checks a TOTP code or, failing that, a recovery code for a user with MFA
+// enabled, and records its use so that it cannot be used again.
+func verifyMFACode(ctx context.Context, mfaRepo repository.MFARepository, userID, code string) error {
+	mfa, err := mfaRepo.GetMFA(ctx, userID)
+	if err != nil {
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return apierror.NewUnauthorizedError("Invalid verification code").WithCode(apierror.CodeInvalidMFACode)
+		}
+		return err
+	}
+	if !mfa.Enabled {
+		return apierror.NewUnauthorizedError("Invalid verification code").WithCode(apierror.CodeInvalidMFACode)
+	}
+
+	if step, ok := auth.ValidateTOTP(mfa.Secret, code, time.Now()); ok {
+		return mfaRepo.UseTOTPStep(ctx, userID, step)
+	}
+
+	return mfaRepo.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code))
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/auth"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCService defines the interface for logging in through OpenID Connect providers.
+type OIDCService interface {
+	StartLogin(ctx context.Context, provider string) (*model.OIDCLogin, error)
+	CompleteLogin(ctx context.Context, provider, code, state, stateToken string) (*model.TokenPair, *model.MFAChallenge, error)
+}
+
+// oidcService is the concrete implementation of the OIDCService interface.
+type oidcService struct {
+	providers   map[string]*oidc.Provider
+	authService AuthService
+	tokenConfig *auth.Config
+}
+
+// NewOIDCService creates a new instance of oidcService for the given providers, by name.
+func NewOIDCService(providers map[string]*oidc.Provider, authService AuthService, tokenConfig *auth.Config) OIDCService {
+	return &oidcService{
+		providers:   providers,
+		authService: authService,
+		tokenConfig: tokenConfig,
+	}
+}
+
+// StartLogin generates a fresh state, nonce and PKCE verifier and returns the provider's
+// login page URL together with a signed token holding them for the callback.
+func (s *oidcService) StartLogin(ctx context.Context, provider string) (*model.OIDCLogin, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, apierror.NewNotFoundError("Unknown login provider '" + provider + "'").WithCode(apierror.CodeUnknownProvider)
+	}
+
+	state, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		log.Printf("Error generating OIDC state: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to start login")
+	}
+	nonce, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		log.Printf("Error generating OIDC nonce: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to start login")
+	}
+
+	oidcState := auth.OIDCState{
+		Provider: provider,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, oidcState.State, oidcState.Nonce, oidcState.Verifier)
+	if err != nil {
+		log.Printf("Error starting OIDC login: %v", err)
+		return nil, apierror.NewAPIError(http.StatusBadGateway, "Login provider is unavailable")
+	}
+
+	stateToken, err := auth.GenerateOIDCStateToken(s.tokenConfig, oidcState)
+	if err != nil {
+		log.Printf("Error generating OIDC state token: %v", err)
+		return nil, apierror.NewInternalServerError("Failed to start login")
+	}
+
+	return &model.OIDCLogin{AuthURL: authURL, StateToken: stateToken}, nil
+}
+
+// CompleteLogin handles the provider's redirect back. It checks the returned state against
+// the state token from StartLogin, redeems the code with the PKCE verifier, verifies the ID
+// token and its nonce, and logs in the user linked to the provider account.
+func (s *oidcService) CompleteLogin(ctx context.Context, provider, code, state, stateToken string) (*model.TokenPair, *model.MFAChallenge, error) {
+	invalidLogin := apierror.NewUnauthorizedError("Login could not be verified, please try again")
+
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, nil, apierror.NewNotFoundError("Unknown login provider '" + provider + "'").WithCode(apierror.CodeUnknownProvider)
+	}
+
+	oidcState, err := auth.ParseOIDCStateToken(s.tokenConfig, stateToken)
+	if err != nil || oidcState.Provider != provider ||
+		subtle.ConstantTimeCompare([]byte(oidcState.State), []byte(state)) != 1 {
+		return nil, nil, invalidLogin
+	}
+
+	if code == "" {
+		return nil, nil, apierror.NewBadRequestError("Authorization code is required")
+	}
+
+	claims, err := p.Exchange(ctx, code, oidcState.Verifier, oidcState.Nonce)
+	if err != nil {
+		log.Printf("Error completing OIDC login with %s: %v", provider, err)
+		return nil, nil, invalidLogin
+	}
+
+	return s.authService.LoginExternalAccount(ctx, model.ExternalAccount{
+		Provider:      provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	})
+}
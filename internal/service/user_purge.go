@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default values used when the corresponding environment variables are not set.
+const (
+	defaultUserRetention     = 30 * 24 * time.Hour
+	defaultUserPurgeInterval = time.Hour
+)
+
+// UserPurgeConfig configures the scheduled purge of deleted users.
+type UserPurgeConfig struct {
+	// Retention is how long deleted users are kept, and can be restored, before they are purged.
+	Retention time.Duration
+
+	// Interval is the time between two purges. Zero disables the scheduled purge.
+	Interval time.Duration
+}
+
+// LoadUserPurgeConfig builds the purge configuration from the optional USER_RETENTION_PERIOD
+// and USER_PURGE_INTERVAL environment variables. USER_PURGE_INTERVAL=off disables the
+// scheduled purge, e.g. when it runs elsewhere. It returns an error if a value is not a
+// positive duration.
+func LoadUserPurgeConfig() (UserPurgeConfig, error) {
+	cfg := UserPurgeConfig{Retention: defaultUserRetention, Interval: defaultUserPurgeInterval}
+
+	if value := strings.TrimSpace(os.Getenv("USER_RETENTION_PERIOD")); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid USER_RETENTION_PERIOD %q: must be a positive duration", value)
+		}
+		cfg.Retention = d
+	}
+
+	switch value := strings.TrimSpace(os.Getenv("USER_PURGE_INTERVAL")); {
+	case value == "":
+	case strings.EqualFold(value, "off"):
+		cfg.Interval = 0
+	default:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid USER_PURGE_INTERVAL %q: must be a positive duration or \"off\"", value)
+		}
+		cfg.Interval = d
+	}
+
+	return cfg, nil
+}
+
+// RunUserPurge purges deleted users past the retention period once every interval, until
+// ctx is cancelled. Failures are logged and retried at the next interval. Running it on
+// several instances is safe, since every user is purged in its own transaction.
+func RunUserPurge(ctx context.Context, userService UserService, cfg UserPurgeConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := userService.PurgeDeletedUsers(ctx, cfg.Retention)
+		if err != nil {
+			log.Printf("Error purging deleted users after %d purged: %v", purged, err)
+		} else if purged > 0 {
+			log.Printf("Purged %d users deleted more than %s ago", purged, cfg.Retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
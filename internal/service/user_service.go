@@ -2,10 +2,12 @@ package service
 
 import (
 	"context"
+	"errors"
 	"github.com/hermantrym/go-firebase-api/internal/apierror"
 	"github.com/hermantrym/go-firebase-api/internal/auth"
 	"github.com/hermantrym/go-firebase-api/internal/role"
 	"log"
+	"time"
 
 	"github.com/hermantrym/go-firebase-api/internal/model"
 	"github.com/hermantrym/go-firebase-api/internal/repository"
@@ -14,33 +16,66 @@ import (
 // UserService defines the interface for user-related business logic.
 type UserService interface {
 	RegisterUser(ctx context.Context, user model.User) (*model.User, error)
-	AdminRegisterUser(ctx context.Context, user model.User) (*model.User, error)
+	AdminRegisterUser(ctx context.Context, actorID string, actorRole role.Role, user model.User) (*model.User, error)
 	FindUserByID(ctx context.Context, id string) (*model.User, error)
-	LoginUser(ctx context.Context, email string) (string, error)
-	FindAllUsers(ctx context.Context) ([]model.User, error)
+	FindAllUsers(ctx context.Context, query model.UserListQuery) (*model.UserPage, error)
+	UpdateUser(ctx context.Context, id string, update model.UserUpdate) (*model.User, error)
+	AdminUpdateUser(ctx context.Context, id string, update model.UserUpdate) (*model.User, error)
+	PatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error)
+	AdminPatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error)
+	DeleteUser(ctx context.Context, id string) error
+	ChangeUserRole(ctx context.Context, actorID, id string, newRole role.Role) (*model.User, error)
+	SuspendUser(ctx context.Context, actorID, id string) (*model.User, error)
+	RestoreUser(ctx context.Context, actorID, id string) (*model.User, error)
+	PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error)
 }
 
+// errRoleChangeNotAllowed is returned when a profile update tries to change the user's role.
+var errRoleChangeNotAllowed = apierror.NewBadRequestError("Use PUT /admin/users/:id/role to change a user's role").WithCode(apierror.CodeRoleChangeNotAllowed)
+
 // userService is the concrete implementation of the UserService interface.
 type userService struct {
-	userRepo repository.UserRepository
+	userRepo    repository.UserRepository
+	authService AuthService
 }
 
 // NewUserService creates a new instance of userService.
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{userRepo: repo}
+// The auth service is used to revoke a user's sessions when they are deleted or suspended,
+// or their role changes.
+func NewUserService(repo repository.UserRepository, authSvc AuthService) UserService {
+	return &userService{
+		userRepo:    repo,
+		authService: authSvc,
+	}
 }
 
 // RegisterUser handles the business logic for creating a new user with a default "user" role.
+// The new user's email address is unverified, and a verification email is sent to it.
 func (s *userService) RegisterUser(ctx context.Context, user model.User) (*model.User, error) {
 	// Always assign the default "user" role for public registrations.
 	user.Role = role.User
-	return s.userRepo.CreateUser(ctx, user)
+	user.Verified = false
+
+	createdUser, err := s.createUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account exists either way, and the user can request another email, so a
+	// delivery failure is logged rather than failing the registration.
+	if err := s.authService.SendVerificationEmail(ctx, createdUser); err != nil {
+		log.Printf("Error sending verification email to new user %s: %v", createdUser.ID, err)
+	}
+
+	return createdUser, nil
 }
 
-// AdminRegisterUser handles user creation by an administrator.
-// It allows specifying a role, defaulting to "user" if none is provided,
-// and validates the role before creation.
-func (s *userService) AdminRegisterUser(ctx context.Context, user model.User) (*model.User, error) {
+// AdminRegisterUser handles user creation by the administrator actorID, whose role is actorRole.
+// It allows specifying a role, defaulting to "user" if none is provided, and validates the
+// role before creation. Any other role than "user" can only be given by a role that grants
+// RolesAssign, like changing a role afterwards. The email address is marked as verified,
+// and the creation is recorded in the audit log.
+func (s *userService) AdminRegisterUser(ctx context.Context, actorID string, actorRole role.Role, user model.User) (*model.User, error) {
 	// If no role is specified in the request, assign the default "user" role.
 	if user.Role == "" {
 		user.Role = role.User
@@ -48,30 +83,56 @@ func (s *userService) AdminRegisterUser(ctx context.Context, user model.User) (*
 
 	// Validate that the provided role is a valid one (e.g., "admin" or "user").
 	if !user.Role.IsValid() {
-		return nil, apierror.NewBadRequestError("Invalid role specified")
+		return nil, apierror.NewBadRequestError("Invalid role specified").WithCode(apierror.CodeInvalidRole)
 	}
 
-	return s.userRepo.CreateUser(ctx, user)
+	// Otherwise users:write alone would be enough to create admins.
+	if user.Role != role.User && !actorRole.HasPermission(role.RolesAssign) {
+		return nil, apierror.NewForbiddenError("You do not have permission to assign the role '" + string(user.Role) + "'")
+	}
+
+	// Addresses entered by an administrator are trusted and do not need verification.
+	user.Verified = true
+
+	user, err := hashUserPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.userRepo.CreateUserWithAudit(ctx, user, model.AuditLog{
+		Action:   model.AuditActionUserCreated,
+		ActorID:  actorID,
+		NewValue: string(user.Role),
+	})
 }
 
-// LoginUser handles the user login process.
-// It finds a user by email and generates a JWT if the user is found.
-func (s *userService) LoginUser(ctx context.Context, email string) (string, error) {
-	// Find the user by email.
-	user, err := s.userRepo.GetUserByEmail(ctx, email)
+// createUser hashes the user's plaintext password and persists the user.
+func (s *userService) createUser(ctx context.Context, user model.User) (*model.User, error) {
+	user, err := hashUserPassword(user)
 	if err != nil {
-		// Return the error from the repository layer.
-		return "", err
+		return nil, err
 	}
 
-	// If the user is found, generate a JWT.
-	token, err := auth.GenerateJWT(user.ID, user.Email, user.Role)
+	return s.userRepo.CreateUser(ctx, user)
+}
+
+// hashUserPassword normalizes a new user's email and replaces their plaintext password with
+// its hash. The plaintext password is cleared so that it is never stored or returned.
+func hashUserPassword(user model.User) (model.User, error) {
+	user.Email = model.NormalizeEmail(user.Email)
+
+	hash, err := auth.HashPassword(user.Password)
+	if errors.Is(err, auth.ErrPasswordTooLong) {
+		return user, apierror.NewBadRequestError("Password must be at most 72 bytes long").WithCode(apierror.CodePasswordTooLong)
+	}
 	if err != nil {
-		log.Printf("Error generating JWT: %v", err)
-		return "", apierror.NewInternalServerError("Failed to generate authentication token")
+		log.Printf("Error hashing password: %v", err)
+		return user, apierror.NewInternalServerError("Failed to process password")
 	}
 
-	return token, nil
+	user.PasswordHash = hash
+	user.Password = ""
+	return user, nil
 }
 
 // FindUserByID retrieves a user by their unique ID.
@@ -79,6 +140,187 @@ func (s *userService) FindUserByID(ctx context.Context, id string) (*model.User,
 	return s.userRepo.GetUser(ctx, id)
 }
 
-func (s *userService) FindAllUsers(ctx context.Context) ([]model.User, error) {
-	return s.userRepo.GetAllUsers(ctx)
+// defaultPageSize is the number of users returned per page when no limit is requested.
+const defaultPageSize = 20
+
+// FindAllUsers retrieves one page of users matching the query.
+// It applies the default page size and validates the role filter.
+func (s *userService) FindAllUsers(ctx context.Context, query model.UserListQuery) (*model.UserPage, error) {
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+
+	if query.Role != "" && !query.Role.IsValid() {
+		return nil, apierror.NewBadRequestError("Invalid role specified").WithCode(apierror.CodeInvalidRole)
+	}
+
+	query.Email = model.NormalizeEmail(query.Email)
+
+	return s.userRepo.GetAllUsers(ctx, query)
+}
+
+// UpdateUser replaces the profile of a user on behalf of the account owner.
+// Any role supplied in the update is ignored and the user's current role is kept.
+func (s *userService) UpdateUser(ctx context.Context, id string, update model.UserUpdate) (*model.User, error) {
+	existing, err := s.userRepo.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.userRepo.UpdateUser(ctx, updatedUser(id, existing.Role, update))
+}
+
+// AdminUpdateUser replaces the profile of a user on behalf of an administrator.
+// Roles are changed through ChangeUserRole, so a role that differs from the user's
+// current role is rejected rather than silently ignored.
+func (s *userService) AdminUpdateUser(ctx context.Context, id string, update model.UserUpdate) (*model.User, error) {
+	existing, err := s.userRepo.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Role != "" && update.Role != existing.Role {
+		return nil, errRoleChangeNotAllowed
+	}
+
+	return s.userRepo.UpdateUser(ctx, updatedUser(id, existing.Role, update))
+}
+
+// updatedUser returns the user described by a profile update, keeping the given role.
+func updatedUser(id string, currentRole role.Role, update model.UserUpdate) model.User {
+	return model.User{
+		ID:          id,
+		Name:        update.Name,
+		Email:       model.NormalizeEmail(update.Email),
+		Role:        currentRole,
+		DisplayName: update.DisplayName,
+		AvatarURL:   update.AvatarURL,
+		Phone:       update.Phone,
+		Locale:      update.Locale,
+		Timezone:    update.Timezone,
+		Metadata:    update.Metadata,
+	}
+}
+
+// PatchUser partially updates a user on behalf of the account owner.
+// Any role supplied in the patch is ignored for security reasons.
+func (s *userService) PatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error) {
+	patch.Role = nil
+	return s.userRepo.PatchUser(ctx, id, normalizePatchEmail(patch))
+}
+
+// AdminPatchUser partially updates a user on behalf of an administrator.
+// Roles are changed through ChangeUserRole, so a role that differs from the user's
+// current role is rejected rather than silently ignored.
+func (s *userService) AdminPatchUser(ctx context.Context, id string, patch model.UserPatch) (*model.User, error) {
+	if patch.Role != nil {
+		existing, err := s.userRepo.GetUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if *patch.Role != existing.Role {
+			return nil, errRoleChangeNotAllowed
+		}
+		patch.Role = nil
+	}
+
+	return s.userRepo.PatchUser(ctx, id, normalizePatchEmail(patch))
+}
+
+// ChangeUserRole assigns a new role to a user on behalf of the administrator actorID.
+// The last remaining admin cannot be demoted. The change is recorded in the audit log,
+// and the user's outstanding tokens are revoked because they carry the old role.
+func (s *userService) ChangeUserRole(ctx context.Context, actorID, id string, newRole role.Role) (*model.User, error) {
+	if !newRole.IsValid() {
+		return nil, apierror.NewBadRequestError("Invalid role specified").WithCode(apierror.CodeInvalidRole)
+	}
+
+	existing, err := s.userRepo.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing changes, so there is nothing to record or revoke.
+	if existing.Role == newRole {
+		return existing, nil
+	}
+
+	user, err := s.userRepo.ChangeUserRole(ctx, model.RoleChange{
+		UserID:  id,
+		NewRole: newRole,
+		ActorID: actorID,
+	}, role.Including(role.Admin))
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens carry the role, so outstanding sessions must not outlive a role change.
+	if err := s.authService.RevokeUserSessions(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// normalizePatchEmail normalizes the email of a patch, if one is supplied.
+func normalizePatchEmail(patch model.UserPatch) model.UserPatch {
+	if patch.Email != nil {
+		email := model.NormalizeEmail(*patch.Email)
+		patch.Email = &email
+	}
+	return patch
+}
+
+// DeleteUser soft-deletes a user by their unique ID and revokes their sessions.
+// The user can be restored until they are purged at the end of the retention period.
+// The last remaining active admin cannot be deleted.
+func (s *userService) DeleteUser(ctx context.Context, id string) error {
+	if err := s.userRepo.DeleteUser(ctx, id, role.Including(role.Admin)); err != nil {
+		return err
+	}
+
+	return s.authService.RevokeUserSessions(ctx, id)
+}
+
+// SuspendUser suspends an active user on behalf of the administrator actorID and revokes
+// their sessions, so that they can neither log in nor use the tokens issued before.
+// Administrators cannot suspend themselves, and the last remaining active admin cannot be
+// suspended. The change is recorded in the audit log.
+func (s *userService) SuspendUser(ctx context.Context, actorID, id string) (*model.User, error) {
+	if actorID == id {
+		return nil, apierror.NewBadRequestError("You cannot suspend your own account").WithCode(apierror.CodeInvalidStatusTransition)
+	}
+
+	user, err := s.userRepo.ChangeUserStatus(ctx, model.StatusChange{
+		UserID:    id,
+		NewStatus: model.UserStatusSuspended,
+		ActorID:   actorID,
+	}, role.Including(role.Admin))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RevokeUserSessions(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// RestoreUser makes a suspended or deleted user active again on behalf of the administrator
+// actorID. Deleted users can only be restored until they are purged. The user has to log in
+// again, since their sessions were revoked. The change is recorded in the audit log.
+func (s *userService) RestoreUser(ctx context.Context, actorID, id string) (*model.User, error) {
+	return s.userRepo.ChangeUserStatus(ctx, model.StatusChange{
+		UserID:    id,
+		NewStatus: model.UserStatusActive,
+		ActorID:   actorID,
+	}, role.Including(role.Admin))
+}
+
+// PurgeDeletedUsers permanently removes the users that were deleted more than the retention
+// period ago. It returns the number of users removed.
+func (s *userService) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error) {
+	return s.userRepo.PurgeDeletedUsers(ctx, time.Now().UTC().Add(-retention))
 }
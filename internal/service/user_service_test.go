@@ -0,0 +1,399 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hermantrym/go-firebase-api/internal/apierror"
+	"github.com/hermantrym/go-firebase-api/internal/model"
+	"github.com/hermantrym/go-firebase-api/internal/repository"
+	"github.com/hermantrym/go-firebase-api/internal/role"
+)
+
+// stubAuthService records the sessions revoked and the verification emails sent by the
+// user service. Its other methods are not used.
+type stubAuthService struct {
+	AuthService
+	revokedUsers  []string
+	verifiedUsers []string
+}
+
+func (s *stubAuthService) RevokeUserSessions(_ context.Context, userID string) error {
+	s.revokedUsers = append(s.revokedUsers, userID)
+	return nil
+}
+
+func (s *stubAuthService) SendVerificationEmail(_ context.Context, user *model.User) error {
+	s.verifiedUsers = append(s.verifiedUsers, user.ID)
+	return nil
+}
+
+// newUserServiceFixture returns a userService over an empty in-memory repository.
+func newUserServiceFixture() (UserService, repository.UserRepository, *stubAuthService) {
+	repo := repository.NewMemoryUserRepository()
+	authSvc := &stubAuthService{}
+	return NewUserService(repo, authSvc), repo, authSvc
+}
+
+func TestUserServiceRegisterUser(t *testing.T) {
+	ctx := context.Background()
+	svc, _, authSvc := newUserServiceFixture()
+
+	user, err := svc.RegisterUser(ctx, model.User{Name: "Ada", Email: " Ada@Example.com ", Password: "correct horse", Role: role.Admin, Verified: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Role != role.User || user.Verified || user.Email != "ada@example.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.Password != "" || user.PasswordHash == "" || user.PasswordHash == "correct horse" {
+		t.Errorf("password not replaced by its hash: %+v", user)
+	}
+	if len(authSvc.verifiedUsers) != 1 || authSvc.verifiedUsers[0] != user.ID {
+		t.Errorf("verification emails sent to %v, want [%s]", authSvc.verifiedUsers, user.ID)
+	}
+
+	_, err = svc.RegisterUser(ctx, model.User{Name: "Other", Email: "ADA@example.com", Password: "correct horse"})
+	assertAPIError(t, err, http.StatusConflict, apierror.CodeEmailTaken)
+
+	_, err = svc.RegisterUser(ctx, model.User{Name: "Long", Email: "long@example.com", Password: strings.Repeat("x", 73)})
+	assertAPIError(t, err, http.StatusBadRequest, apierror.CodePasswordTooLong)
+}
+
+func TestUserServiceAdminRegisterUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		actorRole  role.Role
+		userRole   role.Role
+		wantRole   role.Role
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "default role", actorRole: role.Admin, wantRole: role.User},
+		{name: "admin role", actorRole: role.Admin, userRole: role.Admin, wantRole: role.Admin},
+		{name: "invalid role", actorRole: role.Admin, userRole: "superuser", wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidRole},
+		{name: "role not assignable by the actor", actorRole: role.User, userRole: role.Admin, wantStatus: http.StatusForbidden, wantCode: apierror.CodeForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, _ := newUserServiceFixture()
+
+			user, err := svc.AdminRegisterUser(ctx, "admin-1", tt.actorRole, model.User{Name: "Ada", Email: "ada@example.com", Password: "correct horse", Role: tt.userRole})
+			if tt.wantStatus != 0 {
+				assertAPIError(t, err, tt.wantStatus, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if user.Role != tt.wantRole || !user.Verified {
+				t.Errorf("unexpected user: %+v", user)
+			}
+		})
+	}
+}
+
+func TestUserServiceFindAllUsers(t *testing.T) {
+	ctx := context.Background()
+	svc, repo, _ := newUserServiceFixture()
+
+	for i := 0; i < defaultPageSize+1; i++ {
+		email := "user" + string(rune('a'+i)) + "@example.com"
+		if _, err := repo.CreateUser(ctx, model.User{Name: "User", Email: email, Role: role.User}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := svc.FindAllUsers(ctx, model.UserListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Items) != defaultPageSize || page.NextCursor == "" {
+		t.Errorf("page has %d users and cursor %q, want %d users and a cursor", len(page.Items), page.NextCursor, defaultPageSize)
+	}
+
+	page, err = svc.FindAllUsers(ctx, model.UserListQuery{Email: "USERA@example.com"})
+	if err != nil || len(page.Items) != 1 {
+		t.Errorf("FindAllUsers by email = %v, %v, want one user", page, err)
+	}
+
+	_, err = svc.FindAllUsers(ctx, model.UserListQuery{Role: "superuser"})
+	assertAPIError(t, err, http.StatusBadRequest, apierror.CodeInvalidRole)
+}
+
+func TestUserServiceUpdates(t *testing.T) {
+	ctx := context.Background()
+	adminRole := role.Admin
+	userRole := role.User
+	name := "Grace"
+
+	tests := []struct {
+		name       string
+		update     func(svc UserService, id string) (*model.User, error)
+		wantName   string
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name: "owner update ignores the role",
+			update: func(svc UserService, id string) (*model.User, error) {
+				return svc.UpdateUser(ctx, id, model.UserUpdate{Name: "Grace", Email: "ada@example.com", Role: role.Admin})
+			},
+			wantName: "Grace",
+		},
+		{
+			name: "admin update with the current role",
+			update: func(svc UserService, id string) (*model.User, error) {
+				return svc.AdminUpdateUser(ctx, id, model.UserUpdate{Name: "Grace", Email: "ada@example.com", Role: role.User})
+			},
+			wantName: "Grace",
+		},
+		{
+			name: "admin update changing the role",
+			update: func(svc UserService, id string) (*model.User, error) {
+				return svc.AdminUpdateUser(ctx, id, model.UserUpdate{Name: "Grace", Email: "ada@example.com", Role: role.Admin})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeRoleChangeNotAllowed,
+		},
+		{
+			name: "update of a missing user",
+			update: func(svc UserService, _ string) (*model.User, error) {
+				return svc.UpdateUser(ctx, "missing", model.UserUpdate{Name: "Grace", Email: "ada@example.com"})
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   apierror.CodeUserNotFound,
+		},
+		{
+			name: "owner patch ignores the role",
+			update: func(svc UserService, id string) (*model.User, error) {
+				return svc.PatchUser(ctx, id, model.UserPatch{Name: &name, Role: &adminRole})
+			},
+			wantName: "Grace",
+		},
+		{
+			name: "admin patch with the current role",
+			update: func(svc UserService, id string) (*model.User, error) {
+				return svc.AdminPatchUser(ctx, id, model.UserPatch{Name: &name, Role: &userRole})
+			},
+			wantName: "Grace",
+		},
+		{
+			name: "admin patch changing the role",
+			update: func(svc UserService, id string) (*model.User, error) {
+				return svc.AdminPatchUser(ctx, id, model.UserPatch{Role: &adminRole})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeRoleChangeNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo, _ := newUserServiceFixture()
+			user, err := repo.CreateUser(ctx, model.User{Name: "Ada", Email: "ada@example.com", Role: role.User})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updated, err := tt.update(svc, user.ID)
+			if tt.wantStatus != 0 {
+				assertAPIError(t, err, tt.wantStatus, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated.Name != tt.wantName || updated.Role != role.User {
+				t.Errorf("unexpected user: %+v", updated)
+			}
+		})
+	}
+}
+
+func TestUserServiceChangeUserRole(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		target      string
+		newRole     role.Role
+		wantRole    role.Role
+		wantRevoked bool
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "promote", target: "user", newRole: role.Admin, wantRole: role.Admin, wantRevoked: true},
+		{name: "unchanged role", target: "user", newRole: role.User, wantRole: role.User},
+		{name: "invalid role", target: "user", newRole: "superuser", wantStatus: http.StatusBadRequest, wantCode: apierror.CodeInvalidRole},
+		{name: "demote the last admin", target: "admin", newRole: role.User, wantStatus: http.StatusConflict, wantCode: apierror.CodeLastAdmin},
+		{name: "missing user", target: "missing", newRole: role.Admin, wantStatus: http.StatusNotFound, wantCode: apierror.CodeUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo, authSvc := newUserServiceFixture()
+			admin, err := repo.CreateUser(ctx, model.User{Name: "Admin", Email: "admin@example.com", Role: role.Admin})
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, err := repo.CreateUser(ctx, model.User{Name: "User", Email: "user@example.com", Role: role.User})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := map[string]string{"admin": admin.ID, "user": user.ID, "missing": "missing"}
+
+			changed, err := svc.ChangeUserRole(ctx, admin.ID, ids[tt.target], tt.newRole)
+			if tt.wantStatus != 0 {
+				assertAPIError(t, err, tt.wantStatus, tt.wantCode)
+				if len(authSvc.revokedUsers) != 0 {
+					t.Errorf("sessions revoked for %v after a failed change", authSvc.revokedUsers)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed.Role != tt.wantRole {
+				t.Errorf("role = %q, want %q", changed.Role, tt.wantRole)
+			}
+			if revoked := len(authSvc.revokedUsers) == 1 && authSvc.revokedUsers[0] == changed.ID; revoked != tt.wantRevoked {
+				t.Errorf("revoked users = %v, want revoked %t", authSvc.revokedUsers, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestUserServiceStatusChanges(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		change      func(svc UserService, actorID, id string) error
+		wantStatus  model.UserStatus
+		wantRevoked bool
+		wantErr     int
+		wantCode    string
+	}{
+		{
+			name: "suspend",
+			change: func(svc UserService, actorID, id string) error {
+				_, err := svc.SuspendUser(ctx, actorID, id)
+				return err
+			},
+			wantStatus: model.UserStatusSuspended, wantRevoked: true,
+		},
+		{
+			name: "suspend oneself",
+			change: func(svc UserService, actorID, _ string) error {
+				_, err := svc.SuspendUser(ctx, actorID, actorID)
+				return err
+			},
+			wantErr: http.StatusBadRequest, wantCode: apierror.CodeInvalidStatusTransition,
+		},
+		{
+			name: "restore an active user",
+			change: func(svc UserService, actorID, id string) error {
+				_, err := svc.RestoreUser(ctx, actorID, id)
+				return err
+			},
+			wantErr: http.StatusConflict, wantCode: apierror.CodeInvalidStatusTransition,
+		},
+		{
+			name: "restore a suspended user",
+			change: func(svc UserService, actorID, id string) error {
+				if _, err := svc.SuspendUser(ctx, actorID, id); err != nil {
+					return err
+				}
+				_, err := svc.RestoreUser(ctx, actorID, id)
+				return err
+			},
+			wantStatus: model.UserStatusActive, wantRevoked: true,
+		},
+		{
+			name: "delete",
+			change: func(svc UserService, _, id string) error {
+				return svc.DeleteUser(ctx, id)
+			},
+			wantStatus: model.UserStatusDeleted, wantRevoked: true,
+		},
+		{
+			name: "delete a missing user",
+			change: func(svc UserService, _, _ string) error {
+				return svc.DeleteUser(ctx, "missing")
+			},
+			wantErr: http.StatusNotFound, wantCode: apierror.CodeUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo, authSvc := newUserServiceFixture()
+			admin, err := repo.CreateUser(ctx, model.User{Name: "Admin", Email: "admin@example.com", Role: role.Admin})
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, err := repo.CreateUser(ctx, model.User{Name: "User", Email: "user@example.com", Role: role.User})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.change(svc, admin.ID, user.ID)
+			if tt.wantErr != 0 {
+				assertAPIError(t, err, tt.wantErr, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			stored, err := repo.GetUser(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", stored.Status, tt.wantStatus)
+			}
+			if revoked := len(authSvc.revokedUsers) > 0 && authSvc.revokedUsers[0] == user.ID; revoked != tt.wantRevoked {
+				t.Errorf("revoked users = %v, want revoked %t", authSvc.revokedUsers, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestUserServicePurgeDeletedUsers(t *testing.T) {
+	ctx := context.Background()
+	svc, repo, _ := newUserServiceFixture()
+
+	user, err := repo.CreateUser(ctx, model.User{Name: "User", Email: "user@example.com", Role: role.User})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user is still within the retention period.
+	if purged, err := svc.PurgeDeletedUsers(ctx, time.Hour); err != nil || purged != 0 {
+		t.Fatalf("PurgeDeletedUsers(1h) = %d, %v, want 0", purged, err)
+	}
+	if _, err := svc.RestoreUser(ctx, "admin", user.ID); err != nil {
+		t.Fatalf("unexpected error restoring the user: %v", err)
+	}
+
+	if err := svc.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := svc.PurgeDeletedUsers(ctx, -time.Second); err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedUsers = %d, %v, want 1", purged, err)
+	}
+
+	_, err = svc.FindUserByID(ctx, user.ID)
+	assertAPIError(t, err, http.StatusNotFound, apierror.CodeUserNotFound)
+}